@@ -0,0 +1,68 @@
+package apikeys
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/render"
+	"gopkg.in/thedevsaddam/govalidator.v1"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+type APIKeyPayload struct {
+	Name string `json:"name"`
+}
+
+func (a *APIKeyPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (a *APIKeyPayload) validate() url.Values {
+	rules := govalidator.MapData{
+		"name": []string{"required", "max:128"},
+	}
+	opts := govalidator.Options{
+		Data:  a,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	return e
+}
+
+type APIKeyResponse struct {
+	ID        int32      `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Key       string     `json:"key,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+func (a *APIKeyResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewAPIKeyResponse(apiKey *models.APIKey) *APIKeyResponse {
+	resp := &APIKeyResponse{
+		ID:        apiKey.ID,
+		Name:      apiKey.Name,
+		Prefix:    apiKey.Prefix,
+		CreatedAt: apiKey.CreatedAt,
+	}
+	if apiKey.IsRevoked() {
+		resp.RevokedAt = &apiKey.RevokedAt
+	}
+	return resp
+}
+
+func NewAPIKeyListResponse(apiKeys []*models.APIKey) []render.Renderer {
+	list := make([]render.Renderer, 0)
+	for _, apiKey := range apiKeys {
+		list = append(list, NewAPIKeyResponse(apiKey))
+	}
+	return list
+}
@@ -0,0 +1,59 @@
+package apikeys
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/identifier"
+)
+
+// HeaderName is the request header carrying the api key
+const HeaderName = "X-API-Key"
+
+type authenticator struct {
+	service Service
+}
+
+var _ auth.Authenticator = (*authenticator)(nil)
+
+// NewAuthenticator authenticates requests by the api key in the X-API-Key header
+// or sent as bearer token, as done by SCIM clients. Api keys are granted every
+// scope within their organization.
+func NewAuthenticator(service Service) auth.Authenticator {
+	return &authenticator{service: service}
+}
+
+func (a *authenticator) Authenticate(r *http.Request) (*auth.Caller, error) {
+	key := r.Header.Get(HeaderName)
+	if key == "" {
+		key = bearerKey(r)
+	}
+	if key == "" {
+		return nil, auth.ErrNoCredentials
+	}
+	apiKey, err := a.service.Verify(key)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Caller{
+		Kind:           auth.KindAPIKey,
+		Subject:        identifier.APIKeyID(apiKey.ID).String(),
+		OrganizationID: apiKey.OrganizationID,
+		Scopes:         []string{auth.ScopeAdmin},
+	}, nil
+}
+
+// bearerKey returns the api key sent as bearer token, other bearer tokens
+// are left to the other authenticators
+func bearerKey(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	token := strings.TrimSpace(header[7:])
+	if !strings.HasPrefix(token, keyPrefix+"_") {
+		return ""
+	}
+	return token
+}
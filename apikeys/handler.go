@@ -0,0 +1,107 @@
+package apikeys
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9"
+	param "github.com/oceanicdev/chi-param"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// Handler handles api keys http method
+type Handler interface {
+	List(w http.ResponseWriter, r *http.Request)
+	Create(w http.ResponseWriter, r *http.Request)
+	Revoke(w http.ResponseWriter, r *http.Request)
+}
+
+type apiKeyHandler struct {
+	service Service
+	db      *pg.DB
+}
+
+var _ Handler = (*apiKeyHandler)(nil)
+
+// NewAPIKeyHandler construct api key handler
+func NewAPIKeyHandler(db *pg.DB, service Service) Handler {
+	return &apiKeyHandler{
+		service: service,
+		db:      db,
+	}
+}
+
+func (a *apiKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	apiKeys, err := a.service.List(organization)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewAPIKeyListResponse(apiKeys)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
+func (a *apiKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	data := &APIKeyPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+
+	validationErrors := data.validate()
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	apiKey, key, err := a.service.Create(data.Name, organization.ID)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+
+	resp := NewAPIKeyResponse(apiKey)
+	resp.Key = key
+	render.Status(r, http.StatusCreated)
+	_ = render.Render(w, r, resp)
+}
+
+func (a *apiKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	id, err := param.Int32(r, "id")
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+		return
+	}
+	apiKey, err := a.service.FindByIdAndOrganizationId(id, organization.ID)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(404, "api key not found", err))
+		return
+	}
+	if err := a.service.Revoke(apiKey); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	render.NoContent(w, r)
+}
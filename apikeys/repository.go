@@ -0,0 +1,68 @@
+package apikeys
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/models"
+)
+
+type Repository interface {
+	List(organizationId int32) ([]*models.APIKey, error)
+	Create(apiKey *models.APIKey) (*models.APIKey, error)
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.APIKey, error)
+	FindByPrefix(prefix string) (*models.APIKey, error)
+	Revoke(apiKey *models.APIKey) error
+}
+
+type apiKeyRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*apiKeyRepository)(nil)
+
+func NewAPIKeyRepository(db *pg.DB) Repository {
+	return &apiKeyRepository{
+		db,
+	}
+}
+
+func (a *apiKeyRepository) List(organizationId int32) ([]*models.APIKey, error) {
+	var apiKeys []*models.APIKey
+	err := a.db.Model(&apiKeys).
+		Where("organization_id = ?", organizationId).
+		Order("id ASC").
+		Select()
+	return apiKeys, err
+}
+
+func (a *apiKeyRepository) Create(apiKey *models.APIKey) (*models.APIKey, error) {
+	_, err := a.db.Model(apiKey).Returning("*").Insert()
+	return apiKey, err
+}
+
+func (a *apiKeyRepository) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	err := a.db.Model(&apiKey).
+		Where("id = ?", Id).
+		Where("organization_id = ?", Oid).
+		First()
+	return &apiKey, err
+}
+
+func (a *apiKeyRepository) FindByPrefix(prefix string) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	err := a.db.Model(&apiKey).
+		Where("prefix = ?", prefix).
+		First()
+	return &apiKey, err
+}
+
+func (a *apiKeyRepository) Revoke(apiKey *models.APIKey) error {
+	apiKey.RevokedAt = time.Now()
+	_, err := a.db.Model(apiKey).
+		Set("revoked_at = ?revoked_at").
+		Where("id = ?id").
+		Update()
+	return err
+}
@@ -0,0 +1,115 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/models"
+)
+
+// keyPrefix marks authz api keys
+const keyPrefix = "azk"
+
+// ErrInvalidKey is returned when an api key is malformed, unknown or revoked
+var ErrInvalidKey = errors.New("invalid api key")
+
+type Service interface {
+	List(organization *models.Organization) ([]*models.APIKey, error)
+	// Create generates a new key, the plain text key is only returned once
+	Create(name string, organizationID int32) (*models.APIKey, string, error)
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.APIKey, error)
+	Revoke(apiKey *models.APIKey) error
+	// Verify returns the active api key matching the plain text key
+	Verify(key string) (*models.APIKey, error)
+}
+
+type apiKeyService struct {
+	db         *pg.DB
+	repository Repository
+}
+
+var _ Service = (*apiKeyService)(nil)
+
+func NewAPIKeyService(db *pg.DB) Service {
+	return &apiKeyService{
+		db:         db,
+		repository: NewAPIKeyRepository(db),
+	}
+}
+
+func (a *apiKeyService) List(organization *models.Organization) ([]*models.APIKey, error) {
+	return a.repository.List(organization.ID)
+}
+
+func (a *apiKeyService) Create(name string, organizationID int32) (*models.APIKey, string, error) {
+	prefix, err := randomString(4, hex.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(24, base64.RawURLEncoding.EncodeToString)
+	if err != nil {
+		return nil, "", err
+	}
+	key := fmt.Sprintf("%s_%s_%s", keyPrefix, prefix, secret)
+
+	apiKey := &models.APIKey{
+		Name:           name,
+		Prefix:         prefix,
+		KeyHash:        hashKey(key),
+		OrganizationID: organizationID,
+	}
+	apiKey, err = a.repository.Create(apiKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+func (a *apiKeyService) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.APIKey, error) {
+	return a.repository.FindByIdAndOrganizationId(Id, Oid)
+}
+
+func (a *apiKeyService) Revoke(apiKey *models.APIKey) error {
+	return a.repository.Revoke(apiKey)
+}
+
+func (a *apiKeyService) Verify(key string) (*models.APIKey, error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != keyPrefix {
+		return nil, ErrInvalidKey
+	}
+	apiKey, err := a.repository.FindByPrefix(parts[1])
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashKey(key))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if apiKey.IsRevoked() {
+		return nil, ErrInvalidKey
+	}
+	return apiKey, nil
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int, encode func([]byte) string) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encode(b), nil
+}
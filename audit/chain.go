@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+// hashTimeLayout formats created_at in hashes, timestamps are stored in UTC
+// with microsecond precision
+const hashTimeLayout = "2006-01-02T15:04:05.000000Z"
+
+// Break is the first broken link of an organization's chain
+type Break struct {
+	OrganizationID int32
+	EntryID        int32
+	Reason         string
+}
+
+// String describes the broken link
+func (b *Break) String() string {
+	return fmt.Sprintf("organization %d: entry %d %s", b.OrganizationID, b.EntryID, b.Reason)
+}
+
+// Hash returns the hex sha256 of the entry's content and PrevHash, JSON
+// documents are hashed in canonical form so the hash survives their jsonb
+// round trip
+func Hash(entry *models.AuditEntry) (string, error) {
+	before, err := canonical(entry.Before)
+	if err != nil {
+		return "", err
+	}
+	after, err := canonical(entry.After)
+	if err != nil {
+		return "", err
+	}
+	content, err := json.Marshal(struct {
+		OrganizationID int32           `json:"organization_id"`
+		Actor          string          `json:"actor"`
+		Action         string          `json:"action"`
+		TargetType     string          `json:"target_type"`
+		TargetID       int32           `json:"target_id"`
+		Before         json.RawMessage `json:"before"`
+		After          json.RawMessage `json:"after"`
+		RequestID      string          `json:"request_id"`
+		CreatedAt      string          `json:"created_at"`
+		PrevHash       string          `json:"prev_hash"`
+	}{
+		OrganizationID: entry.OrganizationID,
+		Actor:          entry.Actor,
+		Action:         entry.Action,
+		TargetType:     entry.TargetType,
+		TargetID:       entry.TargetID,
+		Before:         before,
+		After:          after,
+		RequestID:      entry.RequestID,
+		CreatedAt:      entry.CreatedAt.UTC().Format(hashTimeLayout),
+		PrevHash:       entry.PrevHash,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonical re-encodes a JSON document with sorted keys and no whitespace
+func canonical(raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 {
+		return json.RawMessage("null"), nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// chain verifies consecutive entries of one organization
+type chain struct {
+	organizationID int32
+	last           *models.AuditEntry
+	broken         *Break
+}
+
+// next checks that entry links to the previous entry and matches its hash
+func (c *chain) next(entry *models.AuditEntry) {
+	if c.broken != nil {
+		return
+	}
+	prevHash := ""
+	if c.last != nil {
+		prevHash = c.last.Hash
+	}
+	hash, err := Hash(entry)
+	switch {
+	case err != nil:
+		c.broken = &Break{OrganizationID: c.organizationID, EntryID: entry.ID, Reason: "can not be hashed: " + err.Error()}
+	case entry.PrevHash != prevHash:
+		c.broken = &Break{OrganizationID: c.organizationID, EntryID: entry.ID, Reason: "does not link to the previous entry"}
+	case entry.Hash != hash:
+		c.broken = &Break{OrganizationID: c.organizationID, EntryID: entry.ID, Reason: "does not match its hash"}
+	default:
+		c.last = entry
+	}
+}
+
+// now returns the current time as it is stored by the database
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
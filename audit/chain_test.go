@@ -0,0 +1,266 @@
+package audit
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+// testRepository serves the chain of entries held in memory
+type testRepository struct {
+	Repository
+	entries []*models.AuditEntry
+}
+
+func (r *testRepository) Chain(afterOrganizationId int32, afterId int32, limit int) ([]*models.AuditEntry, error) {
+	sorted := append([]*models.AuditEntry(nil), r.entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].OrganizationID != sorted[j].OrganizationID {
+			return sorted[i].OrganizationID < sorted[j].OrganizationID
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	var list []*models.AuditEntry
+	for _, entry := range sorted {
+		if entry.OrganizationID < afterOrganizationId ||
+			entry.OrganizationID == afterOrganizationId && entry.ID <= afterId {
+			continue
+		}
+		if len(list) == limit {
+			break
+		}
+		list = append(list, entry)
+	}
+	return list, nil
+}
+
+// testChain records n linked entries of the organization from id on
+func testChain(t *testing.T, organizationID int32, id int32, n int) []*models.AuditEntry {
+	t.Helper()
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var entries []*models.AuditEntry
+	prevHash := ""
+	for i := 0; i < n; i++ {
+		entry := &models.AuditEntry{
+			ID:             id + int32(i),
+			OrganizationID: organizationID,
+			Actor:          "user:1",
+			Action:         "group.updated",
+			TargetType:     "group",
+			TargetID:       int32(i + 1),
+			Before:         json.RawMessage(`{"name":"a","permissions":[1,2]}`),
+			After:          json.RawMessage(`{"name":"b","permissions":[1,2,3]}`),
+			RequestID:      "req",
+			CreatedAt:      created.Add(time.Duration(i) * time.Second),
+			PrevHash:       prevHash,
+		}
+		hash, err := Hash(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Hash = hash
+		prevHash = hash
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// verify walks the entries with a chain and returns its first break
+func verify(entries []*models.AuditEntry) *Break {
+	c := &chain{organizationID: 1}
+	for _, entry := range entries {
+		c.next(entry)
+	}
+	return c.broken
+}
+
+func TestChainIntact(t *testing.T) {
+	entries := testChain(t, 1, 1, 5)
+	if broken := verify(entries); broken != nil {
+		t.Fatalf("intact chain broken: %s", broken)
+	}
+
+	// jsonb reorders keys and drops whitespace
+	entries[2].After = json.RawMessage(`{ "permissions": [1, 2, 3], "name": "b" }`)
+	if broken := verify(entries); broken != nil {
+		t.Errorf("chain broken by the json round trip: %s", broken)
+	}
+}
+
+func TestChainTampered(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func(entries []*models.AuditEntry) []*models.AuditEntry
+		entry  int32
+		reason string
+	}{
+		{
+			name: "modified actor",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[2].Actor = "user:2"
+				return entries
+			},
+			entry:  3,
+			reason: "does not match its hash",
+		},
+		{
+			name: "modified document",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[1].After = json.RawMessage(`{"name":"b","permissions":[1,2,3,4]}`)
+				return entries
+			},
+			entry:  2,
+			reason: "does not match its hash",
+		},
+		{
+			name: "modified time",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[3].CreatedAt = entries[3].CreatedAt.Add(time.Microsecond)
+				return entries
+			},
+			entry:  4,
+			reason: "does not match its hash",
+		},
+		{
+			name: "modified and rehashed",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[2].Action = "group.deleted"
+				entries[2].Hash, _ = Hash(entries[2])
+				return entries
+			},
+			entry:  4,
+			reason: "does not link to the previous entry",
+		},
+		{
+			name: "removed entry",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				return append(entries[:2], entries[3:]...)
+			},
+			entry:  4,
+			reason: "does not link to the previous entry",
+		},
+		{
+			name: "removed first entry",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				return entries[1:]
+			},
+			entry:  2,
+			reason: "does not link to the previous entry",
+		},
+		{
+			name: "reordered entries",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[1], entries[2] = entries[2], entries[1]
+				return entries
+			},
+			entry:  3,
+			reason: "does not link to the previous entry",
+		},
+		{
+			name: "invalid document",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[0].Before = json.RawMessage(`{`)
+				return entries
+			},
+			entry:  1,
+			reason: "can not be hashed: unexpected EOF",
+		},
+	} {
+		broken := verify(tc.tamper(testChain(t, 1, 1, 5)))
+		if broken == nil {
+			t.Errorf("%s: chain not broken", tc.name)
+			continue
+		}
+		if broken.EntryID != tc.entry || broken.Reason != tc.reason {
+			t.Errorf("%s: broken at %s, want entry %d %s", tc.name, broken, tc.entry, tc.reason)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	chains := func() []*models.AuditEntry {
+		return append(testChain(t, 1, 1, 4), testChain(t, 2, 5, 3)...)
+	}
+	service := &auditService{repository: &testRepository{entries: chains()}}
+	breaks, heads, err := service.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(breaks) != 0 {
+		t.Fatalf("intact chains broken: %v", breaks)
+	}
+	if len(heads) != 2 || heads[0].EntryID != 4 || heads[0].Entries != 4 || heads[1].EntryID != 7 || heads[1].Entries != 3 {
+		t.Fatalf("heads %+v", heads)
+	}
+	checkpoints := heads
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(entries []*models.AuditEntry) []*models.AuditEntry
+		want   Break
+	}{
+		{
+			name: "modified entry",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[5].TargetID = 42
+				return entries
+			},
+			want: Break{OrganizationID: 2, EntryID: 6, Reason: "does not match its hash"},
+		},
+		{
+			name: "removed entry",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				return append(entries[:1], entries[2:]...)
+			},
+			want: Break{OrganizationID: 1, EntryID: 3, Reason: "does not link to the previous entry"},
+		},
+		{
+			name: "reordered ids",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				entries[1].ID, entries[2].ID = entries[2].ID, entries[1].ID
+				return entries
+			},
+			want: Break{OrganizationID: 1, EntryID: 2, Reason: "does not link to the previous entry"},
+		},
+		{
+			// the rest of the chain is intact, only the checkpoint tells
+			name: "removed last entries",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				return append(entries[:2], entries[4:]...)
+			},
+			want: Break{OrganizationID: 1, EntryID: 4, Reason: "of the checkpoint is missing"},
+		},
+		{
+			name: "removed chain",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				return entries[:4]
+			},
+			want: Break{OrganizationID: 2, EntryID: 7, Reason: "of the checkpoint is missing"},
+		},
+		{
+			name: "rewritten chain",
+			tamper: func(entries []*models.AuditEntry) []*models.AuditEntry {
+				rewritten := testChain(t, 1, 1, 4)
+				rewritten[1].Actor = "user:2"
+				for i := 1; i < len(rewritten); i++ {
+					rewritten[i].PrevHash = rewritten[i-1].Hash
+					rewritten[i].Hash, _ = Hash(rewritten[i])
+				}
+				return append(rewritten, entries[4:]...)
+			},
+			want: Break{OrganizationID: 1, EntryID: 4, Reason: "does not match the checkpoint"},
+		},
+	} {
+		service := &auditService{repository: &testRepository{entries: tc.tamper(chains())}}
+		breaks, _, err := service.Verify(checkpoints...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(breaks) != 1 || *breaks[0] != tc.want {
+			t.Errorf("%s: breaks %v, want %s", tc.name, breaks, &tc.want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"time"
+)
+
+// ErrInvalidSignature is returned for checkpoints not signed by the key
+var ErrInvalidSignature = errors.New("invalid checkpoint signature")
+
+// Checkpoint is the head of an organization's chain when it was exported
+type Checkpoint struct {
+	OrganizationID int32  `json:"organization_id"`
+	EntryID        int32  `json:"entry_id"`
+	Hash           string `json:"hash"`
+	Entries        int    `json:"entries"`
+}
+
+// SignedCheckpoint is an export of the chain heads signed with ed25519,
+// archived outside of the database it proves later edits of the audit log
+type SignedCheckpoint struct {
+	CreatedAt   time.Time     `json:"created_at"`
+	Checkpoints []*Checkpoint `json:"checkpoints"`
+	Signature   string        `json:"signature"`
+}
+
+// Sign signs the checkpoints with key
+func Sign(checkpoints []*Checkpoint, key ed25519.PrivateKey) (*SignedCheckpoint, error) {
+	signed := &SignedCheckpoint{CreatedAt: now(), Checkpoints: checkpoints}
+	payload, err := signed.payload()
+	if err != nil {
+		return nil, err
+	}
+	signed.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return signed, nil
+}
+
+// Verify checks the signature of the checkpoints against key
+func (s *SignedCheckpoint) Verify(key ed25519.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(s.Signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	payload, err := s.payload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, payload, signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (s *SignedCheckpoint) payload() ([]byte, error) {
+	return json.Marshal(struct {
+		CreatedAt   string        `json:"created_at"`
+		Checkpoints []*Checkpoint `json:"checkpoints"`
+	}{s.CreatedAt.UTC().Format(hashTimeLayout), s.Checkpoints})
+}
+
+// LoadSigningKey reads a PEM encoded PKCS #8 ed25519 private key, as
+// generated by `openssl genpkey -algorithm ed25519`
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an ed25519 key")
+	}
+	return privateKey, nil
+}
+
+// LoadVerifyingKey reads a PEM encoded PKIX ed25519 public key, as
+// generated by `openssl pkey -pubout`
+func LoadVerifyingKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("verifying key is not an ed25519 key")
+	}
+	return publicKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found in " + path)
+	}
+	return block, nil
+}
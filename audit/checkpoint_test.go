@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func testKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, private
+}
+
+func testCheckpoints() []*Checkpoint {
+	return []*Checkpoint{
+		{OrganizationID: 1, EntryID: 4, Hash: "aa", Entries: 4},
+		{OrganizationID: 2, EntryID: 7, Hash: "bb", Entries: 3},
+	}
+}
+
+func TestSignedCheckpoint(t *testing.T) {
+	public, private := testKey(t)
+	signed, err := Sign(testCheckpoints(), private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signed.Verify(public); err != nil {
+		t.Fatalf("signed checkpoint rejected: %s", err)
+	}
+
+	// the checkpoint is archived as json
+	data, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archived SignedCheckpoint
+	if err := json.Unmarshal(data, &archived); err != nil {
+		t.Fatal(err)
+	}
+	if err := archived.Verify(public); err != nil {
+		t.Errorf("archived checkpoint rejected: %s", err)
+	}
+
+	other, _ := testKey(t)
+	for _, tc := range []struct {
+		name   string
+		tamper func(s *SignedCheckpoint)
+		key    ed25519.PublicKey
+	}{
+		{"modified hash", func(s *SignedCheckpoint) { s.Checkpoints[0].Hash = "cc" }, public},
+		{"modified head", func(s *SignedCheckpoint) { s.Checkpoints[1].EntryID = 6 }, public},
+		{"modified count", func(s *SignedCheckpoint) { s.Checkpoints[1].Entries = 2 }, public},
+		{"removed checkpoint", func(s *SignedCheckpoint) { s.Checkpoints = s.Checkpoints[1:] }, public},
+		{"reordered checkpoints", func(s *SignedCheckpoint) {
+			s.Checkpoints[0], s.Checkpoints[1] = s.Checkpoints[1], s.Checkpoints[0]
+		}, public},
+		{"modified time", func(s *SignedCheckpoint) { s.CreatedAt = s.CreatedAt.Add(-1) }, public},
+		{"invalid signature", func(s *SignedCheckpoint) { s.Signature = "not base64" }, public},
+		{"other key", func(s *SignedCheckpoint) {}, other},
+	} {
+		var s SignedCheckpoint
+		if err := json.Unmarshal(data, &s); err != nil {
+			t.Fatal(err)
+		}
+		tc.tamper(&s)
+		if err := s.Verify(tc.key); err != ErrInvalidSignature {
+			t.Errorf("%s: error %v, want %s", tc.name, err, ErrInvalidSignature)
+		}
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	public, private := testKey(t)
+	dir := t.TempDir()
+	write := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingKey, err := LoadSigningKey(write("signing.pem", "PRIVATE KEY", privateDER))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyingKey, err := LoadVerifyingKey(write("verifying.pem", "PUBLIC KEY", publicDER))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := Sign(testCheckpoints(), signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signed.Verify(verifyingKey); err != nil {
+		t.Errorf("checkpoint signed with the loaded key rejected: %s", err)
+	}
+
+	if _, err := LoadSigningKey(write("public.pem", "PUBLIC KEY", publicDER)); err == nil {
+		t.Error("public key loaded as signing key")
+	}
+	if _, err := LoadVerifyingKey(write("private.pem", "PRIVATE KEY", privateDER)); err == nil {
+		t.Error("private key loaded as verifying key")
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSigningKey(empty); err == nil {
+		t.Error("key loaded from a file without PEM data")
+	}
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+type EntryResponse struct {
+	ID         int32           `json:"id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   int32           `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	RequestID  string          `json:"request_id,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+func (e *EntryResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewEntryResponse(entry *models.AuditEntry) *EntryResponse {
+	return &EntryResponse{
+		ID:         entry.ID,
+		Actor:      entry.Actor,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Before:     nullable(entry.Before),
+		After:      nullable(entry.After),
+		RequestID:  entry.RequestID,
+		CreatedAt:  entry.CreatedAt,
+	}
+}
+
+func NewEntryListResponse(entries []*models.AuditEntry) []render.Renderer {
+	list := make([]render.Renderer, 0)
+	for _, entry := range entries {
+		list = append(list, NewEntryResponse(entry))
+	}
+	return list
+}
+
+// nullable renders missing JSON documents as null
+func nullable(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return json.RawMessage("null")
+	}
+	return raw
+}
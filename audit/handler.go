@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/httputil"
+	"github.com/imtanmoy/authz/utils/pagination"
+)
+
+// Handler handles audit log http method
+type Handler interface {
+	List(w http.ResponseWriter, r *http.Request)
+}
+
+type auditHandler struct {
+	service Service
+	db      *pg.DB
+}
+
+var _ Handler = (*auditHandler)(nil)
+
+// NewAuditHandler construct audit log handler
+func NewAuditHandler(db *pg.DB, service Service) Handler {
+	return &auditHandler{
+		service: service,
+		db:      db,
+	}
+}
+
+// List serves the audit entries of the organization filtered by actor,
+// action, target_type, target_id, created_after and created_before
+func (a *auditHandler) List(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	params, validationErrors := pagination.ParseParams(r)
+
+	query := r.URL.Query()
+	filter := &Filter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+	}
+	if targetID := query.Get("target_id"); targetID != "" {
+		id, err := strconv.ParseInt(targetID, 10, 32)
+		if err != nil {
+			validationErrors.Add("target_id", "The target_id field must be an integer")
+		}
+		filter.TargetID = int32(id)
+	}
+	if createdBefore := query.Get("created_before"); createdBefore != "" {
+		t, err := time.Parse(time.RFC3339, createdBefore)
+		if err != nil {
+			validationErrors.Add("created_before", "The created_before field must be a RFC3339 timestamp")
+		}
+		filter.CreatedBefore = t.UTC()
+	}
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	entries, err := a.service.List(organization, filter, params)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	n, page := params.Page(len(entries), func(i int) (int32, interface{}) {
+		return entries[i].ID, entries[i].ID
+	})
+	if err := render.Render(w, r, pagination.NewListResponse(NewEntryListResponse(entries[:n]), page)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"errors"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/pagination"
+)
+
+type Repository interface {
+	List(organizationId int32, filter *Filter, params *pagination.Params) ([]*models.AuditEntry, error)
+	Create(tx *pg.Tx, entry *models.AuditEntry) (*models.AuditEntry, error)
+	// LastHash returns the hash of the organization's last entry, or an
+	// empty string for a new chain
+	LastHash(tx *pg.Tx, organizationId int32) (string, error)
+	// Chain returns entries ordered by organization and id, following the
+	// given organization and entry id
+	Chain(afterOrganizationId int32, afterId int32, limit int) ([]*models.AuditEntry, error)
+}
+
+type auditRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*auditRepository)(nil)
+
+func NewAuditRepository(db *pg.DB) Repository {
+	return &auditRepository{
+		db,
+	}
+}
+
+func (a *auditRepository) List(organizationId int32, filter *Filter, params *pagination.Params) ([]*models.AuditEntry, error) {
+	var entries []*models.AuditEntry
+	q := a.db.Model(&entries).Where("audit_entry.organization_id = ?", organizationId)
+	if filter.Actor != "" {
+		q = q.Where("audit_entry.actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		q = q.Where("audit_entry.action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		q = q.Where("audit_entry.target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != 0 {
+		q = q.Where("audit_entry.target_id = ?", filter.TargetID)
+	}
+	if !params.CreatedAfter.IsZero() {
+		q = q.Where("audit_entry.created_at > ?", params.CreatedAfter)
+	}
+	if !filter.CreatedBefore.IsZero() {
+		q = q.Where("audit_entry.created_at < ?", filter.CreatedBefore)
+	}
+	err := params.Apply(q, "audit_entry.id", "audit_entry.id").Select()
+	return entries, err
+}
+
+func (a *auditRepository) Create(tx *pg.Tx, entry *models.AuditEntry) (*models.AuditEntry, error) {
+	_, err := tx.Model(entry).Returning("*").Insert()
+	return entry, err
+}
+
+func (a *auditRepository) LastHash(tx *pg.Tx, organizationId int32) (string, error) {
+	var hash string
+	_, err := tx.QueryOne(pg.Scan(&hash), `
+		SELECT hash FROM audit_log
+		WHERE coalesce(organization_id, 0) = ?
+		ORDER BY id DESC LIMIT 1`, organizationId)
+	if errors.Is(err, pg.ErrNoRows) {
+		return "", nil
+	}
+	return hash, err
+}
+
+func (a *auditRepository) Chain(afterOrganizationId int32, afterId int32, limit int) ([]*models.AuditEntry, error) {
+	var entries []*models.AuditEntry
+	err := a.db.Model(&entries).
+		Where("(coalesce(audit_entry.organization_id, 0), audit_entry.id) > (?, ?)", afterOrganizationId, afterId).
+		OrderExpr("coalesce(audit_entry.organization_id, 0) ASC, audit_entry.id ASC").
+		Limit(limit).
+		Select()
+	return entries, err
+}
@@ -0,0 +1,246 @@
+// Package audit records every access control change into the append-only
+// audit_log table.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/pagination"
+)
+
+// Audited actions
+const (
+	OrganizationCreated = "organization.created"
+	OrganizationUpdated = "organization.updated"
+	OrganizationDeleted = "organization.deleted"
+
+	OrganizationMemberAdded   = "organization.member_added"
+	OrganizationMemberRemoved = "organization.member_removed"
+
+	UserCreated = "user.created"
+	UserUpdated = "user.updated"
+	UserDeleted = "user.deleted"
+
+	GroupCreated  = "group.created"
+	GroupUpdated  = "group.updated"
+	GroupDeleted  = "group.deleted"
+	GroupRestored = "group.restored"
+	GroupPurged   = "group.purged"
+
+	GroupUsersAdded         = "group.users_added"
+	GroupUsersRemoved       = "group.users_removed"
+	GroupPermissionsAdded   = "group.permissions_added"
+	GroupPermissionsRemoved = "group.permissions_removed"
+	GroupPoliciesDeleted    = "group.policies_deleted"
+
+	ServiceAccountCreated = "service_account.created"
+	ServiceAccountUpdated = "service_account.updated"
+	ServiceAccountDeleted = "service_account.deleted"
+
+	ServiceAccountGroupsAdded        = "service_account.groups_added"
+	ServiceAccountGroupsRemoved      = "service_account.groups_removed"
+	ServiceAccountPermissionsAdded   = "service_account.permissions_added"
+	ServiceAccountPermissionsRemoved = "service_account.permissions_removed"
+	ServiceAccountPoliciesDeleted    = "service_account.policies_deleted"
+)
+
+// Audited target types
+const (
+	TargetOrganization   = "organization"
+	TargetUser           = "user"
+	TargetGroup          = "group"
+	TargetServiceAccount = "service_account"
+)
+
+// chainLock is the advisory lock class serializing the chain of an organization
+const chainLock = 0x617564
+
+// chainBatch is the number of entries read at once while verifying chains
+const chainBatch = 1000
+
+// SystemActor is the actor of changes made without an authenticated caller,
+// such as background jobs and in-process calls
+const SystemActor = "system"
+
+// Change describes a mutation to record, Before and After are stored as JSON
+type Change struct {
+	OrganizationID int32
+	Action         string
+	TargetType     string
+	TargetID       int32
+	Before         interface{}
+	After          interface{}
+}
+
+// Filter narrows the audit entries of an organization
+type Filter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   int32
+	// CreatedBefore filters entries created before the time when set
+	CreatedBefore time.Time
+}
+
+type Service interface {
+	// Record appends the change within tx, so the entry is committed with the
+	// mutation it records, the actor and request id are taken from ctx
+	Record(ctx context.Context, tx *pg.Tx, change *Change) error
+	// List returns a page of entries, fetching one lookahead entry past params.Limit
+	List(organization *models.Organization, filter *Filter, params *pagination.Params) ([]*models.AuditEntry, error)
+	// Verify walks the hash chain of every organization and returns the first
+	// broken link of each broken chain along with the heads of the chains,
+	// the chains must also contain the given checkpoints
+	Verify(checkpoints ...*Checkpoint) ([]*Break, []*Checkpoint, error)
+}
+
+type auditService struct {
+	db         *pg.DB
+	repository Repository
+}
+
+var _ Service = (*auditService)(nil)
+
+func NewAuditService(db *pg.DB) Service {
+	return &auditService{
+		db:         db,
+		repository: NewAuditRepository(db),
+	}
+}
+
+func (a *auditService) Record(ctx context.Context, tx *pg.Tx, change *Change) error {
+	entry := &models.AuditEntry{
+		OrganizationID: change.OrganizationID,
+		Actor:          Actor(ctx),
+		Action:         change.Action,
+		TargetType:     change.TargetType,
+		TargetID:       change.TargetID,
+		RequestID:      middleware.GetReqID(ctx),
+		CreatedAt:      now(),
+	}
+	var err error
+	if entry.Before, err = marshal(change.Before); err != nil {
+		return err
+	}
+	if entry.After, err = marshal(change.After); err != nil {
+		return err
+	}
+	// entries of an organization are chained one at a time, the lock is held
+	// until tx ends
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(?, ?)", chainLock, entry.OrganizationID); err != nil {
+		return err
+	}
+	prevHash, err := a.repository.LastHash(tx, entry.OrganizationID)
+	if err != nil {
+		return err
+	}
+	entry.PrevHash = prevHash
+	if entry.Hash, err = Hash(entry); err != nil {
+		return err
+	}
+	_, err = a.repository.Create(tx, entry)
+	return err
+}
+
+func (a *auditService) List(organization *models.Organization, filter *Filter, params *pagination.Params) ([]*models.AuditEntry, error) {
+	return a.repository.List(organization.ID, filter, params)
+}
+
+func (a *auditService) Verify(checkpoints ...*Checkpoint) ([]*Break, []*Checkpoint, error) {
+	expected := make(map[int32]*Checkpoint, len(checkpoints))
+	for _, checkpoint := range checkpoints {
+		expected[checkpoint.OrganizationID] = checkpoint
+	}
+
+	breaks := make([]*Break, 0)
+	heads := make([]*Checkpoint, 0)
+	var current *chain
+	entries := 0
+	finish := func() {
+		if current == nil {
+			return
+		}
+		if checkpoint, ok := expected[current.organizationID]; ok && current.broken == nil {
+			current.broken = &Break{
+				OrganizationID: current.organizationID,
+				EntryID:        checkpoint.EntryID,
+				Reason:         "of the checkpoint is missing",
+			}
+		}
+		if current.broken != nil {
+			breaks = append(breaks, current.broken)
+		} else if current.last != nil {
+			heads = append(heads, &Checkpoint{
+				OrganizationID: current.organizationID,
+				EntryID:        current.last.ID,
+				Hash:           current.last.Hash,
+				Entries:        entries,
+			})
+		}
+		delete(expected, current.organizationID)
+	}
+
+	var afterOrganizationID, afterID int32
+	for {
+		list, err := a.repository.Chain(afterOrganizationID, afterID, chainBatch)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range list {
+			if current == nil || current.organizationID != entry.OrganizationID {
+				finish()
+				current = &chain{organizationID: entry.OrganizationID}
+				entries = 0
+			}
+			current.next(entry)
+			entries++
+			checkpoint, ok := expected[entry.OrganizationID]
+			if ok && current.broken == nil && entry.ID == checkpoint.EntryID {
+				if entry.Hash != checkpoint.Hash || entries != checkpoint.Entries {
+					current.broken = &Break{
+						OrganizationID: entry.OrganizationID,
+						EntryID:        entry.ID,
+						Reason:         "does not match the checkpoint",
+					}
+				}
+				delete(expected, entry.OrganizationID)
+			}
+			afterOrganizationID, afterID = entry.OrganizationID, entry.ID
+		}
+		if len(list) < chainBatch {
+			break
+		}
+	}
+	finish()
+	// checkpoints of organizations without any entry left
+	for _, checkpoint := range expected {
+		breaks = append(breaks, &Break{
+			OrganizationID: checkpoint.OrganizationID,
+			EntryID:        checkpoint.EntryID,
+			Reason:         "of the checkpoint is missing",
+		})
+	}
+	return breaks, heads, nil
+}
+
+// Actor returns the subject of the caller of ctx
+func Actor(ctx context.Context) string {
+	if caller, ok := auth.FromContext(ctx); ok && caller.Subject != "" {
+		return caller.Subject
+	}
+	return SystemActor
+}
+
+func marshal(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,178 @@
+// Package auth authenticates api callers and restricts them to the
+// organizations they belong to.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/render"
+	param "github.com/oceanicdev/chi-param"
+
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request does not
+// carry the credentials it handles
+var ErrNoCredentials = errors.New("no credentials provided")
+
+// Scopes granted to callers, each scope implies the ones below it
+const (
+	ScopeRead  = "authz:read"
+	ScopeWrite = "authz:write"
+	ScopeAdmin = "authz:admin"
+)
+
+// scopeLevels orders scopes so a higher scope implies the lower ones
+var scopeLevels = map[string]int{
+	ScopeRead:  1,
+	ScopeWrite: 2,
+	ScopeAdmin: 3,
+}
+
+// Kinds of callers
+const (
+	// KindAPIKey callers are organization level credentials
+	KindAPIKey = "api_key"
+	// KindUser callers act as an authz user
+	KindUser = "user"
+	// KindSystem callers are in-process jobs, such as the ldap sync
+	KindSystem = "system"
+)
+
+// Caller is the authenticated identity behind a request
+type Caller struct {
+	// Kind of the caller, one of the Kind constants
+	Kind string
+	// Subject identifies the caller, e.g. "api_key::1"
+	Subject string
+	// UserID is the authz user acting through the caller, zero when the
+	// caller is not a user, e.g. an api key
+	UserID int32
+	// OrganizationID restricts the caller to a single organization,
+	// zero means the caller is a superadmin and may access every organization
+	OrganizationID int32
+	// Scopes granted to the caller
+	Scopes []string
+}
+
+// HasScope reports whether the caller was granted scope or a scope implying it
+func (c *Caller) HasScope(scope string) bool {
+	required, ok := scopeLevels[scope]
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+		if ok && scopeLevels[s] >= required {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSuperAdmin reports whether the caller may access every organization
+func (c *Caller) IsSuperAdmin() bool {
+	return c.OrganizationID == 0
+}
+
+// CanAccess reports whether the caller may access the organization
+func (c *Caller) CanAccess(organizationID int32) bool {
+	return c.IsSuperAdmin() || c.OrganizationID == organizationID
+}
+
+// Authenticator authenticates a request
+type Authenticator interface {
+	// Authenticate returns the caller of r, or ErrNoCredentials when r does
+	// not carry credentials handled by the authenticator
+	Authenticate(r *http.Request) (*Caller, error)
+}
+
+// NewContext returns a copy of ctx carrying caller
+func NewContext(ctx context.Context, caller *Caller) context.Context {
+	return context.WithValue(ctx, "caller", caller)
+}
+
+// FromContext returns the caller stored in ctx
+func FromContext(ctx context.Context) (*Caller, bool) {
+	caller, ok := ctx.Value("caller").(*Caller)
+	return caller, ok
+}
+
+// Middleware authenticates requests with the first authenticator accepting
+// their credentials and rejects unauthenticated requests
+func Middleware(authenticators ...Authenticator) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, authenticator := range authenticators {
+				caller, err := authenticator.Authenticate(r)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if err != nil {
+					_ = render.Render(w, r, httputil.NewAPIError(401, "Invalid credentials", err))
+					return
+				}
+				next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), caller)))
+				return
+			}
+			_ = render.Render(w, r, httputil.NewAPIError(401, "Authentication required", ErrNoCredentials))
+		})
+	}
+}
+
+// RequireScope rejects callers which were not granted scope
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, ok := FromContext(r.Context())
+			if !ok || !caller.HasScope(scope) {
+				_ = render.Render(w, r, httputil.NewAPIError(403, "Missing required scope "+scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSuperAdmin rejects callers restricted to an organization
+func RequireSuperAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, ok := FromContext(r.Context())
+		if !ok || !caller.IsSuperAdmin() {
+			_ = render.Render(w, r, httputil.NewAPIError(403, "Forbidden"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireOrganization rejects callers which may not access the organization
+// identified by the url parameter name. Superadmins access every
+// organization, including organizations given by an external id which is
+// not known yet.
+func RequireOrganization(name string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, ok := FromContext(r.Context())
+			if !ok {
+				_ = render.Render(w, r, httputil.NewAPIError(403, "Forbidden"))
+				return
+			}
+			if caller.IsSuperAdmin() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			oid, err := param.Int32(r, name)
+			if err != nil {
+				_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+				return
+			}
+			if !caller.CanAccess(oid) {
+				_ = render.Render(w, r, httputil.NewAPIError(403, "Forbidden"))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,393 @@
+// Package jwt authenticates callers by JWT bearer tokens issued by a gateway.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // register hashes used by the supported algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/identifier"
+	"github.com/imtanmoy/authz/utils"
+)
+
+// AllOrganizations is the organization claim value granting access to every organization
+const AllOrganizations = "*"
+
+var (
+	ErrMalformedToken       = errors.New("malformed token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrInvalidSignature     = errors.New("invalid token signature")
+	ErrTokenExpired         = errors.New("token is expired")
+	ErrMissingExpiration    = errors.New("token has no expiration")
+	ErrTokenLifetime        = errors.New("token lifetime is too long")
+	ErrTokenNotValidYet     = errors.New("token is not valid yet")
+	ErrInvalidIssuer        = errors.New("invalid token issuer")
+	ErrInvalidAudience      = errors.New("invalid token audience")
+	ErrInvalidOrganization  = errors.New("invalid token organization")
+	ErrUnknownSubject       = errors.New("unknown token subject")
+)
+
+// Resolver maps the external ids of the identity provider to authz ids
+type Resolver interface {
+	// OrganizationID returns the id of the organization with the external id
+	OrganizationID(externalID string) (int32, error)
+	// UserID returns the id of the user of the organization with the
+	// external id
+	UserID(organizationID int32, externalID string) (int32, error)
+	// CheckUserID fails when the user with the authz id is not a user or a
+	// member of the organization, or does not exist for superadmins
+	CheckUserID(organizationID int32, id int32) error
+}
+
+// Options configures token verification and how claims map to callers
+type Options struct {
+	// Keys verifying token signatures
+	Keys []Key
+	// Issuer is the required iss claim, empty accepts any issuer
+	Issuer string
+	// Audience must be one of the aud claim values, empty accepts any audience
+	Audience string
+	// OrganizationClaim names the claim holding the caller organization id,
+	// AllOrganizations grants superadmin access. Defaults to "org"
+	OrganizationClaim string
+	// ScopeClaim names the claim holding space separated or listed scopes.
+	// Defaults to "scope"
+	ScopeClaim string
+	// Leeway tolerated when validating exp and nbf
+	Leeway time.Duration
+	// MaxLifetime limits the time between iat, or now without iat, and exp,
+	// zero does not limit it. Tokens always require exp
+	MaxLifetime time.Duration
+	// Resolver resolves sub and organization claims holding external ids and
+	// checks the users of sub claims holding authz ids belong to the
+	// organization, without it the claims must hold authz ids
+	Resolver Resolver
+}
+
+// Claims are the decoded claims of a verified token
+type Claims map[string]interface{}
+
+type authenticator struct {
+	opts Options
+	now  func() time.Time
+}
+
+var _ auth.Authenticator = (*authenticator)(nil)
+
+// NewAuthenticator authenticates requests by the bearer token of the
+// Authorization header, the sub claim holds the authz user id or, with a
+// resolver, the external id of the user
+func NewAuthenticator(opts Options) auth.Authenticator {
+	if opts.OrganizationClaim == "" {
+		opts.OrganizationClaim = "org"
+	}
+	if opts.ScopeClaim == "" {
+		opts.ScopeClaim = "scope"
+	}
+	return &authenticator{opts: opts, now: time.Now}
+}
+
+func (a *authenticator) Authenticate(r *http.Request) (*auth.Caller, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil, auth.ErrNoCredentials
+	}
+	claims, err := a.verify(strings.TrimSpace(header[7:]))
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID, err := a.organizationID(claims[a.opts.OrganizationClaim])
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	userID, err := a.userID(organizationID, sub)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.Caller{
+		Kind:           auth.KindUser,
+		Subject:        identifier.UserID(userID).String(),
+		UserID:         userID,
+		OrganizationID: organizationID,
+		Scopes:         scopesFromClaim(claims[a.opts.ScopeClaim]),
+	}, nil
+}
+
+// verify checks the token signature and registered claims
+func (a *authenticator) verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformedToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedToken
+	}
+	if err := a.verifySignature(header.Alg, header.Kid, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := a.validate(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *authenticator) verifySignature(alg string, kid string, input []byte, signature []byte) error {
+	if _, _, err := algorithm(alg); err != nil {
+		return err
+	}
+	for _, key := range a.opts.Keys {
+		if kid != "" && key.ID != "" && key.ID != kid {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != alg {
+			continue
+		}
+		if verifyWithKey(alg, key.Key, input, signature) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func (a *authenticator) validate(claims Claims) error {
+	now := a.now()
+	exp, ok := numericClaim(claims["exp"])
+	if !ok {
+		return ErrMissingExpiration
+	}
+	if now.After(time.Unix(exp, 0).Add(a.opts.Leeway)) {
+		return ErrTokenExpired
+	}
+	if a.opts.MaxLifetime > 0 {
+		issued := now
+		if iat, ok := numericClaim(claims["iat"]); ok {
+			issued = time.Unix(iat, 0)
+		}
+		if time.Unix(exp, 0).Sub(issued) > a.opts.MaxLifetime+a.opts.Leeway {
+			return ErrTokenLifetime
+		}
+	}
+	if nbf, ok := numericClaim(claims["nbf"]); ok && now.Add(a.opts.Leeway).Before(time.Unix(nbf, 0)) {
+		return ErrTokenNotValidYet
+	}
+	if a.opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.opts.Issuer {
+			return ErrInvalidIssuer
+		}
+	}
+	if a.opts.Audience != "" && !containsAudience(claims["aud"], a.opts.Audience) {
+		return ErrInvalidAudience
+	}
+	return nil
+}
+
+// algorithm returns the key family and hash of a JWS algorithm
+func algorithm(alg string) (string, crypto.Hash, error) {
+	if alg == "EdDSA" {
+		return alg, 0, nil
+	}
+	if len(alg) != 5 {
+		return "", 0, ErrUnsupportedAlgorithm
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return "", 0, ErrUnsupportedAlgorithm
+	}
+	switch family := alg[:2]; family {
+	case "HS", "RS", "PS", "ES":
+		return family, hash, nil
+	}
+	return "", 0, ErrUnsupportedAlgorithm
+}
+
+func verifyWithKey(alg string, key interface{}, input []byte, signature []byte) bool {
+	family, hash, _ := algorithm(alg)
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write(input)
+		digest = h.Sum(nil)
+	}
+
+	switch k := key.(type) {
+	case []byte:
+		if family != "HS" {
+			return false
+		}
+		mac := hmac.New(hash.New, k)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case *rsa.PublicKey:
+		switch family {
+		case "RS":
+			return rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil
+		case "PS":
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			return rsa.VerifyPSS(k, hash, digest, signature, opts) == nil
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if family != "ES" || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	case ed25519.PublicKey:
+		return family == "EdDSA" && ed25519.Verify(k, input, signature)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformedToken
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return ErrMalformedToken
+	}
+	return nil
+}
+
+func numericClaim(value interface{}) (int64, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func containsAudience(value interface{}, audience string) bool {
+	switch aud := value.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, v := range aud {
+			if s, ok := v.(string); ok && s == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userID returns the user id of the sub claim, external ids are resolved
+// and authz ids are checked within the organization of the caller
+func (a *authenticator) userID(organizationID int32, sub string) (int32, error) {
+	if sub == "" {
+		return 0, ErrMalformedToken
+	}
+	id, externalID := utils.ParseID(sub)
+	if externalID == "" {
+		if a.opts.Resolver == nil {
+			return id, nil
+		}
+		if err := a.opts.Resolver.CheckUserID(organizationID, id); err != nil {
+			return 0, fmt.Errorf("%w : %s", ErrUnknownSubject, err)
+		}
+		return id, nil
+	}
+	if a.opts.Resolver == nil || organizationID == 0 {
+		// superadmins have no organization to resolve their id in
+		return 0, ErrMalformedToken
+	}
+	id, err := a.opts.Resolver.UserID(organizationID, externalID)
+	if err != nil {
+		return 0, fmt.Errorf("%w : %s", ErrUnknownSubject, err)
+	}
+	if id <= 0 {
+		return 0, ErrUnknownSubject
+	}
+	return id, nil
+}
+
+// organizationID returns the organization id of the organization claim,
+// external ids are resolved
+func (a *authenticator) organizationID(value interface{}) (int32, error) {
+	var raw string
+	switch v := value.(type) {
+	case json.Number:
+		raw = v.String()
+	case string:
+		raw = v
+	default:
+		return 0, ErrInvalidOrganization
+	}
+	if raw == AllOrganizations {
+		return 0, nil
+	}
+	if raw == "" {
+		return 0, ErrInvalidOrganization
+	}
+	id, externalID := utils.ParseID(raw)
+	if externalID == "" {
+		return id, nil
+	}
+	if a.opts.Resolver == nil {
+		return 0, ErrInvalidOrganization
+	}
+	id, err := a.opts.Resolver.OrganizationID(externalID)
+	if err != nil {
+		return 0, fmt.Errorf("%w : %s", ErrInvalidOrganization, err)
+	}
+	return id, nil
+}
+
+func scopesFromClaim(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		scopes := make([]string, 0, len(v))
+		for _, s := range v {
+			if scope, ok := s.(string); ok {
+				scopes = append(scopes, scope)
+			}
+		}
+		return scopes
+	}
+	return nil
+}
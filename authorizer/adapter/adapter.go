@@ -10,6 +10,8 @@ import (
 
 	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
+
+	"github.com/imtanmoy/authz/identifier"
 )
 
 const (
@@ -41,12 +43,27 @@ type Filter struct {
 	V5    []string
 }
 
+// Transactional is implemented by adapters which can save policy changes
+// within the transaction of the caller. The enforcer must not save the
+// changes itself, its auto save is disabled, and it keeps the changes in
+// memory when tx is rolled back, reload its policy then.
+type Transactional interface {
+	// AddPolicyTx saves the policy line within tx
+	AddPolicyTx(tx *pg.Tx, ptype string, rule []string) error
+	// RemovePolicyTx removes the policy line within tx
+	RemovePolicyTx(tx *pg.Tx, ptype string, rule []string) error
+	// RemoveFilteredPolicyTx removes the policy lines matching the field
+	// values within tx
+	RemoveFilteredPolicyTx(tx *pg.Tx, ptype string, fieldIndex int, fieldValues ...string) error
+}
+
 type adapter struct {
 	db         *pg.DB
 	isFiltered bool
 }
 
 var _ persist.FilteredAdapter = (*adapter)(nil)
+var _ Transactional = (*adapter)(nil)
 
 // NewAdapter is the constructor for Adapter.
 func NewAdapter(db *pg.DB) persist.FilteredAdapter {
@@ -71,53 +88,81 @@ func (a *adapter) LoadPolicy(model model.Model) error {
 	return nil
 }
 
-// SavePolicy saves policy to database.
+// SavePolicy saves policy to database. The lines are validated before the
+// stored policy is replaced within one transaction, so an invalid line
+// leaves the stored policy untouched.
 func (a *adapter) SavePolicy(model model.Model) error {
-	err := a.dropTable()
-	if err != nil {
-		return err
-	}
-	err = a.createTable()
-	if err != nil {
-		return err
-	}
-
 	var lines []*CasbinRule
 
-	for ptype, ast := range model["p"] {
-		for _, rule := range ast.Policy {
-			line := a.savePolicyLine(ptype, rule)
-			lines = append(lines, line)
+	for _, sec := range []string{"p", "g"} {
+		for ptype, ast := range model[sec] {
+			for _, rule := range ast.Policy {
+				if err := validatePolicyLine(ptype, rule); err != nil {
+					return err
+				}
+				lines = append(lines, a.savePolicyLine(ptype, rule))
+			}
 		}
 	}
 
-	for ptype, ast := range model["g"] {
-		for _, rule := range ast.Policy {
-			line := a.savePolicyLine(ptype, rule)
-			lines = append(lines, line)
+	return a.db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := a.dropTable(tx); err != nil {
+			return err
 		}
-	}
-
-	err = a.db.Insert(&lines)
-	return err
+		if err := a.createTable(tx); err != nil {
+			return err
+		}
+		if len(lines) == 0 {
+			return nil
+		}
+		_, err := tx.Model(&lines).Insert()
+		return err
+	})
 }
 
 // AddPolicy adds a policy rule to the storage.
 func (a *adapter) AddPolicy(sec string, ptype string, rule []string) error {
+	return a.addPolicy(a.db, ptype, rule)
+}
+
+// AddPolicyTx adds a policy rule to the storage within tx.
+func (a *adapter) AddPolicyTx(tx *pg.Tx, ptype string, rule []string) error {
+	return a.addPolicy(tx, ptype, rule)
+}
+
+func (a *adapter) addPolicy(db orm.DB, ptype string, rule []string) error {
+	if err := validatePolicyLine(ptype, rule); err != nil {
+		return err
+	}
 	line := a.savePolicyLine(ptype, rule)
-	err := a.db.Insert(line)
+	err := db.Insert(line)
 	return err
 }
 
 // RemovePolicy removes a policy rule from the storage.
 func (a *adapter) RemovePolicy(sec string, ptype string, rule []string) error {
 	line := a.savePolicyLine(ptype, rule)
-	err := a.rawDelete(line)
-	return err
+	return a.rawDelete(a.db, line)
+}
+
+// RemovePolicyTx removes a policy rule from the storage within tx.
+func (a *adapter) RemovePolicyTx(tx *pg.Tx, ptype string, rule []string) error {
+	line := a.savePolicyLine(ptype, rule)
+	return a.rawDelete(tx, line)
 }
 
 // RemoveFilteredPolicy removes policy rules that match the filter from the storage.
 func (a *adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int, fieldValues ...string) error {
+	return a.rawDelete(a.db, filteredPolicyLine(ptype, fieldIndex, fieldValues...))
+}
+
+// RemoveFilteredPolicyTx removes policy rules that match the filter from the storage within tx.
+func (a *adapter) RemoveFilteredPolicyTx(tx *pg.Tx, ptype string, fieldIndex int, fieldValues ...string) error {
+	return a.rawDelete(tx, filteredPolicyLine(ptype, fieldIndex, fieldValues...))
+}
+
+// filteredPolicyLine returns the line matching the field values from fieldIndex on
+func filteredPolicyLine(ptype string, fieldIndex int, fieldValues ...string) *CasbinRule {
 	line := &CasbinRule{PType: ptype}
 
 	idx := fieldIndex + len(fieldValues)
@@ -139,8 +184,23 @@ func (a *adapter) RemoveFilteredPolicy(sec string, ptype string, fieldIndex int,
 	if fieldIndex <= 5 && idx > 5 {
 		line.V5 = fieldValues[5-fieldIndex]
 	}
+	return line
+}
 
-	err := a.rawDelete(line)
+// validatePolicyLine checks the identifiers of a `p, subject, permission,
+// action` or a `g, member, group` line so malformed lines are not saved
+func validatePolicyLine(ptype string, rule []string) error {
+	if len(rule) < 2 {
+		return fmt.Errorf("%s line has %d fields", ptype, len(rule))
+	}
+	object := identifier.Permission
+	if strings.HasPrefix(ptype, "g") {
+		object = identifier.Group
+	}
+	if _, err := identifier.Parse(rule[0]); err != nil {
+		return err
+	}
+	_, err := identifier.ParseKind(rule[1], object)
 	return err
 }
 
@@ -203,8 +263,8 @@ func loadPolicyLine(line *CasbinRule, model model.Model) {
 	persist.LoadPolicyLine(sb.String(), model)
 }
 
-func (a *adapter) createTable() error {
-	err := a.db.CreateTable((*CasbinRule)(nil), &orm.CreateTableOptions{
+func (a *adapter) createTable(tx *pg.Tx) error {
+	err := tx.CreateTable((*CasbinRule)(nil), &orm.CreateTableOptions{
 		Temp: false,
 	})
 	if err != nil {
@@ -216,8 +276,8 @@ func (a *adapter) createTable() error {
 	return nil
 }
 
-func (a *adapter) dropTable() error {
-	err := a.db.DropTable((*CasbinRule)(nil), &orm.DropTableOptions{})
+func (a *adapter) dropTable(tx *pg.Tx) error {
+	err := tx.DropTable((*CasbinRule)(nil), &orm.DropTableOptions{})
 	if err != nil {
 		return err
 	}
@@ -225,7 +285,7 @@ func (a *adapter) dropTable() error {
 	return nil
 }
 
-func (a *adapter) rawDelete(line *CasbinRule) (err error) {
+func (a *adapter) rawDelete(db orm.DB, line *CasbinRule) (err error) {
 	queryArgs := []interface{}{line.PType}
 	query := fmt.Sprintf("DELETE FROM %s WHERE p_type = ?", "casbin_rules")
 	if line.V0 != "" {
@@ -252,7 +312,7 @@ func (a *adapter) rawDelete(line *CasbinRule) (err error) {
 		query += " AND v5 = ?"
 		queryArgs = append(queryArgs, line.V5)
 	}
-	_, err = a.db.Exec(query, queryArgs...)
+	_, err = db.Exec(query, queryArgs...)
 	if err != nil {
 		return
 	}
@@ -1,21 +1,16 @@
 package authorizer
 
 import (
+	"time"
+
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
 	"github.com/go-pg/pg/v9"
-	"time"
 
 	"github.com/imtanmoy/authz/authorizer/adapter"
 )
 
-//casbin enforcer
-var enforcer *casbin.SyncedEnforcer
-
-// Init initialze the Conf
-func Init(db *pg.DB) error {
-	text :=
-		`
+const modelText = `
 		[request_definition]
 		r = sub, obj, act
 		
@@ -32,20 +27,28 @@ func Init(db *pg.DB) error {
 		m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
 		`
 
-	m, err := model.NewModelFromString(text)
+// NewEnforcer creates a casbin enforcer backed by the casbin_rules table,
+// policy changes are only kept in memory and must be saved through the
+// adapter.Transactional adapter.
+// A positive autoLoad interval periodically reloads the policy from the
+// database, call StopAutoLoadPolicy on the enforcer to release it.
+func NewEnforcer(db *pg.DB, autoLoad time.Duration) (*casbin.SyncedEnforcer, error) {
+	m, err := model.NewModelFromString(modelText)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	// Load the policy rules from the .CSV file adapter.
-	// Replace it with your adapter to avoid files.
 	a := adapter.NewAdapter(db)
 
 	// Create the enforcer.
-	enforcer, err = casbin.NewSyncedEnforcer(m, a)
+	enforcer, err := casbin.NewSyncedEnforcer(m, a)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// enforcer.EnableLog(true)
-	enforcer.StartAutoLoadPolicy(30 * time.Second)
-	return nil
-}
\ No newline at end of file
+	// the authorizer saves policy changes within its own transactions
+	enforcer.EnableAutoSave(false)
+	if autoLoad > 0 {
+		enforcer.StartAutoLoadPolicy(autoLoad)
+	}
+	return enforcer, nil
+}
@@ -1,68 +1,147 @@
 package authorizer
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2"
 	casbinerros "github.com/casbin/casbin/v2/errors"
 	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/authorizer/adapter"
+	"github.com/imtanmoy/authz/decision"
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/identifier"
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/outbox"
 	"github.com/imtanmoy/authz/permissions"
 	"github.com/imtanmoy/authz/users"
-	"github.com/imtanmoy/authz/utils"
 )
 
+// Service manages the policy lines, mutations are recorded in the audit log
+// as changes of ctx's caller and their events are added to the outbox in the
+// transaction saving them
 type Service interface {
-	AddPermissionsForGroup(id int32, permissions []*models.Permission) error
+	AddPermissionsForGroup(ctx context.Context, id int32, permissions []*models.Permission) error
 	GetPermissionsForGroup(id int32) ([]*models.Permission, error)
-	RemovePermissionsForGroup(id int32, permissions []*models.Permission) error
+	RemovePermissionsForGroup(ctx context.Context, id int32, permissions []*models.Permission) error
 
-	AddUsersForGroup(id int32, users []*models.User) error
+	AddUsersForGroup(ctx context.Context, id int32, users []*models.User) error
 	GetUsersForGroup(id int32) ([]*models.User, error)
-	RemoveUsersForGroup(id int32, users []*models.User) error
+	RemoveUsersForGroup(ctx context.Context, id int32, users []*models.User) error
+	// UpdateUsersForGroups adds and removes the users of several groups, keyed
+	// by group id, in one transaction
+	UpdateUsersForGroups(ctx context.Context, added map[int32][]*models.User, removed map[int32][]*models.User) error
+
+	// GetUsersForGroups returns the users of each group in one policy scan
+	GetUsersForGroups(ids []int32) (map[int32][]*models.User, error)
+	// GetPermissionsForGroups returns the permissions of each group in one policy scan
+	GetPermissionsForGroups(ids []int32) (map[int32][]*models.Permission, error)
 
-	DeleteGroup(id int32) error
+	// DeleteGroup removes the members and grants of the group, within runs
+	// in the same transaction, e.g. to mark the group deleted
+	DeleteGroup(ctx context.Context, id int32, within func(tx *pg.Tx) error) error
+	// RestoreGroup adds the users, service accounts and permissions of the
+	// group back, within runs first in the same transaction, e.g. to clear
+	// the deletion mark of the group
+	RestoreGroup(ctx context.Context, group *models.Group, within func(tx *pg.Tx) error) error
 
-	AddPermissionsForUser(id int32, permissions []*models.Permission) error
+	AddPermissionsForUser(ctx context.Context, id int32, permissions []*models.Permission) error
 	GetPermissionsForUser(id int32) ([]*models.Permission, error)
-	RemovePermissionsForUser(id int32, permissions []*models.Permission) error
+	RemovePermissionsForUser(ctx context.Context, id int32, permissions []*models.Permission) error
 
-	AddGroupsForUser(id int32, groups []*models.Group) error
+	AddGroupsForUser(ctx context.Context, id int32, groups []*models.Group) error
 	GetGroupsForUser(id int32) ([]*models.Group, error)
-	RemoveGroupsForUser(id int32, groups []*models.Group) error
+	// GetGroupIdsForUser returns the ids of the groups of every organization
+	// the user is a member of
+	GetGroupIdsForUser(id int32) ([]int32, error)
+	RemoveGroupsForUser(ctx context.Context, id int32, groups []*models.Group) error
+
+	// HasPermission reports whether the user was granted the permission, the
+	// decision is handed to the decision logger
+	HasPermission(id int32, permission *models.Permission) (bool, error)
+	// HasPermissionForSubject reports whether the user or service account was
+	// granted the permission, the decision is handed to the decision logger
+	HasPermissionForSubject(subject identifier.ID, permission *models.Permission) (bool, error)
+
+	AddGroupsForServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group) error
+	// GetGroupIdsForServiceAccount returns the ids of the groups the service account is a member of
+	GetGroupIdsForServiceAccount(id int32) ([]int32, error)
+	// GetServiceAccountIdsForGroup returns the ids of the service accounts which are members of the group
+	GetServiceAccountIdsForGroup(id int32) ([]int32, error)
+	RemoveGroupsForServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group) error
+
+	AddPermissionsForServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount, permissions []*models.Permission) error
+	// GetPermissionsForServiceAccount returns the permissions granted to the
+	// service account directly, not through its groups
+	GetPermissionsForServiceAccount(id int32) ([]*models.Permission, error)
+	RemovePermissionsForServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount, permissions []*models.Permission) error
+
+	// DeleteServiceAccount removes the group memberships and grants of the service account
+	DeleteServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount) error
 }
 
 type authorizerService struct {
 	db                   *pg.DB
+	enforcer             *casbin.SyncedEnforcer
 	userRepository       users.Repository
 	permissionRepository permissions.Repository
+	auditService         audit.Service
+	decisionLogger       decision.Logger
+	adapter              adapter.Transactional
+	outboxRepository     outbox.Repository
+
+	// mu serializes the transactions changing the policy
+	mu sync.Mutex
 }
 
 var _ Service = (*authorizerService)(nil)
 
-func NewAuthorizerService(db *pg.DB) Service {
+// NewAuthorizerService creates the authorizer service, the enforcer must be
+// created by NewEnforcer to save policy changes in transactions
+func NewAuthorizerService(db *pg.DB, enforcer *casbin.SyncedEnforcer, auditService audit.Service, decisionLogger decision.Logger) Service {
 	return &authorizerService{
 		db:                   db,
+		enforcer:             enforcer,
 		userRepository:       users.NewUserRepository(db),
 		permissionRepository: permissions.NewPermissionRepository(db),
+		auditService:         auditService,
+		decisionLogger:       decisionLogger,
+		adapter:              enforcer.GetAdapter().(adapter.Transactional),
+		outboxRepository:     outbox.NewOutboxRepository(db),
 	}
 }
 
-func (c *authorizerService) AddPermissionsForGroup(id int32, permissions []*models.Permission) error {
-	groupId := fmt.Sprintf("group::%d", id)
+func (c *authorizerService) AddPermissionsForGroup(ctx context.Context, id int32, permissions []*models.Permission) error {
+	return c.inTransaction(func(tx *pg.Tx) error {
+		return c.addPermissions(ctx, tx, id, permissions)
+	})
+}
+
+// addPermissions grants the permissions to the group within tx
+func (c *authorizerService) addPermissions(ctx context.Context, tx *pg.Tx, id int32, permissions []*models.Permission) error {
+	groupId := identifier.GroupID(id).String()
+	added := make([]int32, 0, len(permissions))
 	for _, permission := range permissions {
-		permissionID := fmt.Sprintf("permission::%d", permission.ID)
-		_, err := enforcer.AddPermissionForUser(groupId, permissionID, permission.Action)
+		permissionID := identifier.PermissionID(permission.ID).String()
+		ok, err := c.addPolicy(tx, "p", groupId, permissionID, permission.Action)
 		if err != nil {
 			return err
 		}
+		if ok {
+			added = append(added, permission.ID)
+		}
 	}
-	return nil
+	return c.recordGroupChange(ctx, tx, id, audit.GroupPermissionsAdded, events.GroupPermissionAdded, "permissions", added)
 }
 
 func (c *authorizerService) GetPermissionsForGroup(id int32) ([]*models.Permission, error) {
-	groupId := fmt.Sprintf("group::%d", id)
+	groupId := identifier.GroupID(id).String()
 
-	permissionList, err := enforcer.GetImplicitPermissionsForUser(groupId)
+	permissionList, err := c.enforcer.GetImplicitPermissionsForUser(groupId)
 	if errors.Is(err, casbinerros.ERR_NAME_NOT_FOUND) {
 		return make([]*models.Permission, 0), nil
 	}
@@ -72,39 +151,43 @@ func (c *authorizerService) GetPermissionsForGroup(id int32) ([]*models.Permissi
 
 	var pIds []int32
 	for _, p := range permissionList {
-		pIds = append(pIds, utils.GetIntID(p[1]))
+		pId, err := identifier.ParseKind(p[1], identifier.Permission)
+		if err != nil {
+			return nil, err
+		}
+		pIds = append(pIds, pId)
 	}
 	return c.permissionRepository.FindAllByIdIn(pIds), nil
 }
 
-func (c *authorizerService) RemovePermissionsForGroup(id int32, permissions []*models.Permission) error {
-	groupId := fmt.Sprintf("group::%d", id)
-	for _, permission := range permissions {
-		permissionID := fmt.Sprintf("permission::%d", permission.ID)
-		_, err := enforcer.DeletePermissionForUser(groupId, permissionID, permission.Action)
-		if err != nil {
-			return err
+func (c *authorizerService) RemovePermissionsForGroup(ctx context.Context, id int32, permissions []*models.Permission) error {
+	groupId := identifier.GroupID(id).String()
+	return c.inTransaction(func(tx *pg.Tx) error {
+		removed := make([]int32, 0, len(permissions))
+		for _, permission := range permissions {
+			permissionID := identifier.PermissionID(permission.ID).String()
+			ok, err := c.removePolicy(tx, "p", groupId, permissionID, permission.Action)
+			if err != nil {
+				return err
+			}
+			if ok {
+				removed = append(removed, permission.ID)
+			}
 		}
-	}
-	return nil
+		return c.recordGroupChange(ctx, tx, id, audit.GroupPermissionsRemoved, events.GroupPermissionRemoved, "permissions", removed)
+	})
 }
 
-func (c *authorizerService) AddUsersForGroup(id int32, users []*models.User) error {
-	groupId := fmt.Sprintf("group::%d", id)
-	for _, user := range users {
-		userID := fmt.Sprintf("user::%d", user.ID)
-		_, err := enforcer.AddRoleForUser(userID, groupId)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+func (c *authorizerService) AddUsersForGroup(ctx context.Context, id int32, users []*models.User) error {
+	return c.inTransaction(func(tx *pg.Tx) error {
+		return c.addUsers(ctx, tx, id, users)
+	})
 }
 
 func (c *authorizerService) GetUsersForGroup(id int32) ([]*models.User, error) {
-	groupId := fmt.Sprintf("group::%d", id)
+	groupId := identifier.GroupID(id).String()
 
-	userList, err := enforcer.GetUsersForRole(groupId)
+	userList, err := c.enforcer.GetUsersForRole(groupId)
 	if errors.Is(err, casbinerros.ERR_NAME_NOT_FOUND) {
 		return make([]*models.User, 0), nil
 	}
@@ -112,31 +195,247 @@ func (c *authorizerService) GetUsersForGroup(id int32) ([]*models.User, error) {
 		return nil, err
 	}
 	var uIds []int32
-	for _, user := range userList {
-		uIds = append(uIds, utils.GetIntID(user))
+	for _, member := range userList {
+		memberId, err := identifier.Parse(member)
+		if err != nil {
+			return nil, err
+		}
+		if memberId.Kind == identifier.User {
+			uIds = append(uIds, memberId.ID)
+		}
 	}
 	return c.userRepository.FindAllByIdIn(uIds), nil
 }
 
-func (c *authorizerService) RemoveUsersForGroup(id int32, users []*models.User) error {
-	groupId := fmt.Sprintf("group::%d", id)
+func (c *authorizerService) RemoveUsersForGroup(ctx context.Context, id int32, users []*models.User) error {
+	return c.inTransaction(func(tx *pg.Tx) error {
+		return c.removeUsers(ctx, tx, id, users)
+	})
+}
+
+func (c *authorizerService) UpdateUsersForGroups(ctx context.Context, added map[int32][]*models.User, removed map[int32][]*models.User) error {
+	return c.inTransaction(func(tx *pg.Tx) error {
+		for _, id := range groupIDs(added) {
+			if err := c.addUsers(ctx, tx, id, added[id]); err != nil {
+				return err
+			}
+		}
+		for _, id := range groupIDs(removed) {
+			if err := c.removeUsers(ctx, tx, id, removed[id]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addUsers adds the users to the group within tx
+func (c *authorizerService) addUsers(ctx context.Context, tx *pg.Tx, id int32, users []*models.User) error {
+	groupId := identifier.GroupID(id).String()
+	added := make([]int32, 0, len(users))
 	for _, user := range users {
-		userID := fmt.Sprintf("user::%d", user.ID)
-		_, err := enforcer.DeleteRoleForUser(userID, groupId)
+		userID := identifier.UserID(user.ID).String()
+		ok, err := c.addPolicy(tx, "g", userID, groupId)
 		if err != nil {
 			return err
 		}
+		if ok {
+			added = append(added, user.ID)
+		}
 	}
-	return nil
+	return c.recordGroupChange(ctx, tx, id, audit.GroupUsersAdded, events.GroupMemberAdded, "users", added)
 }
 
-func (c *authorizerService) DeleteGroup(id int32) error {
-	groupId := fmt.Sprintf("group::%d", id)
-	_, err := enforcer.DeleteRole(groupId)
-	return err
+// removeUsers removes the users from the group within tx
+func (c *authorizerService) removeUsers(ctx context.Context, tx *pg.Tx, id int32, users []*models.User) error {
+	groupId := identifier.GroupID(id).String()
+	removed := make([]int32, 0, len(users))
+	for _, user := range users {
+		userID := identifier.UserID(user.ID).String()
+		ok, err := c.removePolicy(tx, "g", userID, groupId)
+		if err != nil {
+			return err
+		}
+		if ok {
+			removed = append(removed, user.ID)
+		}
+	}
+	return c.recordGroupChange(ctx, tx, id, audit.GroupUsersRemoved, events.GroupMemberRemoved, "users", removed)
+}
+
+// groupIDs returns the sorted group ids of changes so they are applied in a
+// stable order
+func groupIDs(changes map[int32][]*models.User) []int32 {
+	ids := make([]int32, 0, len(changes))
+	for id := range changes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (c *authorizerService) GetUsersForGroups(ids []int32) (map[int32][]*models.User, error) {
+	groupIds := make(map[string]int32, len(ids))
+	for _, id := range ids {
+		groupIds[identifier.GroupID(id).String()] = id
+	}
+	links := make(map[int32][]int32, len(ids))
+	var uIds []int32
+	for _, rule := range c.enforcer.GetGroupingPolicy() {
+		groupId, ok := groupIds[rule[1]]
+		if !ok {
+			continue
+		}
+		memberId, err := identifier.Parse(rule[0])
+		if err != nil {
+			return nil, err
+		}
+		if memberId.Kind != identifier.User {
+			continue
+		}
+		links[groupId] = append(links[groupId], memberId.ID)
+		uIds = append(uIds, memberId.ID)
+	}
+
+	userMap := make(map[int32]*models.User)
+	if len(uIds) > 0 {
+		for _, user := range c.userRepository.FindAllByIdIn(uIds) {
+			userMap[user.ID] = user
+		}
+	}
+	result := make(map[int32][]*models.User, len(ids))
+	for _, id := range ids {
+		userList := make([]*models.User, 0)
+		for _, userId := range links[id] {
+			if user, ok := userMap[userId]; ok {
+				userList = append(userList, user)
+			}
+		}
+		result[id] = userList
+	}
+	return result, nil
+}
+
+func (c *authorizerService) GetPermissionsForGroups(ids []int32) (map[int32][]*models.Permission, error) {
+	groupIds := make(map[string]int32, len(ids))
+	for _, id := range ids {
+		groupIds[identifier.GroupID(id).String()] = id
+	}
+	links := make(map[int32][]int32, len(ids))
+	var pIds []int32
+	for _, rule := range c.enforcer.GetPolicy() {
+		groupId, ok := groupIds[rule[0]]
+		if !ok {
+			continue
+		}
+		permissionId, err := identifier.ParseKind(rule[1], identifier.Permission)
+		if err != nil {
+			return nil, err
+		}
+		links[groupId] = append(links[groupId], permissionId)
+		pIds = append(pIds, permissionId)
+	}
+
+	permissionMap := make(map[int32]*models.Permission)
+	if len(pIds) > 0 {
+		for _, permission := range c.permissionRepository.FindAllByIdIn(pIds) {
+			permissionMap[permission.ID] = permission
+		}
+	}
+	result := make(map[int32][]*models.Permission, len(ids))
+	for _, id := range ids {
+		permissionList := make([]*models.Permission, 0)
+		for _, permissionId := range links[id] {
+			if permission, ok := permissionMap[permissionId]; ok {
+				permissionList = append(permissionList, permission)
+			}
+		}
+		result[id] = permissionList
+	}
+	return result, nil
+}
+
+func (c *authorizerService) DeleteGroup(ctx context.Context, id int32, within func(tx *pg.Tx) error) error {
+	groupId := identifier.GroupID(id).String()
+	return c.inTransaction(func(tx *pg.Tx) error {
+		if within != nil {
+			if err := within(tx); err != nil {
+				return err
+			}
+		}
+		userList, err := c.enforcer.GetUsersForRole(groupId)
+		if err != nil && !errors.Is(err, casbinerros.ERR_NAME_NOT_FOUND) {
+			return err
+		}
+		permissionList := c.enforcer.GetPermissionsForUser(groupId)
+
+		// the members of the group and its grants
+		ok, err := c.removeFilteredPolicy(tx, "g", 1, groupId)
+		if err != nil {
+			return err
+		}
+		granted, err := c.removeFilteredPolicy(tx, "p", 0, groupId)
+		if err != nil || !(ok || granted) {
+			return err
+		}
+
+		before := map[string][]int32{"users": {}, "service_accounts": {}, "permissions": {}}
+		for _, member := range userList {
+			memberId, err := identifier.Parse(member)
+			if err != nil {
+				return err
+			}
+			switch memberId.Kind {
+			case identifier.User:
+				before["users"] = append(before["users"], memberId.ID)
+			case identifier.ServiceAccount:
+				before["service_accounts"] = append(before["service_accounts"], memberId.ID)
+			}
+		}
+		for _, p := range permissionList {
+			permissionId, err := identifier.ParseKind(p[1], identifier.Permission)
+			if err != nil {
+				return err
+			}
+			before["permissions"] = append(before["permissions"], permissionId)
+		}
+		organizationID, err := c.groupOrganization(id)
+		if err != nil {
+			return err
+		}
+		return c.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: organizationID,
+			Action:         audit.GroupPoliciesDeleted,
+			TargetType:     audit.TargetGroup,
+			TargetID:       id,
+			Before:         before,
+		})
+	})
+}
+
+func (c *authorizerService) RestoreGroup(ctx context.Context, group *models.Group, within func(tx *pg.Tx) error) error {
+	return c.inTransaction(func(tx *pg.Tx) error {
+		if within != nil {
+			if err := within(tx); err != nil {
+				return err
+			}
+		}
+		if err := c.addPermissions(ctx, tx, group.ID, group.Permissions); err != nil {
+			return err
+		}
+		if err := c.addUsers(ctx, tx, group.ID, group.Users); err != nil {
+			return err
+		}
+		for _, serviceAccount := range group.ServiceAccounts {
+			if err := c.addServiceAccountGroups(ctx, tx, serviceAccount, []*models.Group{group}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (c *authorizerService) AddPermissionsForUser(id int32, permissions []*models.Permission) error {
+func (c *authorizerService) AddPermissionsForUser(ctx context.Context, id int32, permissions []*models.Permission) error {
 	panic("implement me")
 }
 
@@ -144,11 +443,11 @@ func (c *authorizerService) GetPermissionsForUser(id int32) ([]*models.Permissio
 	panic("implement me")
 }
 
-func (c *authorizerService) RemovePermissionsForUser(id int32, permissions []*models.Permission) error {
+func (c *authorizerService) RemovePermissionsForUser(ctx context.Context, id int32, permissions []*models.Permission) error {
 	panic("implement me")
 }
 
-func (c *authorizerService) AddGroupsForUser(id int32, groups []*models.Group) error {
+func (c *authorizerService) AddGroupsForUser(ctx context.Context, id int32, groups []*models.Group) error {
 	panic("implement me")
 }
 
@@ -156,6 +455,385 @@ func (c *authorizerService) GetGroupsForUser(id int32) ([]*models.Group, error)
 	panic("implement me")
 }
 
-func (c *authorizerService) RemoveGroupsForUser(id int32, groups []*models.Group) error {
+func (c *authorizerService) GetGroupIdsForUser(id int32) ([]int32, error) {
+	return c.groupIds(identifier.UserID(id))
+}
+
+func (c *authorizerService) RemoveGroupsForUser(ctx context.Context, id int32, groups []*models.Group) error {
 	panic("implement me")
 }
+
+func (c *authorizerService) HasPermission(id int32, permission *models.Permission) (bool, error) {
+	return c.HasPermissionForSubject(identifier.UserID(id), permission)
+}
+
+func (c *authorizerService) HasPermissionForSubject(subject identifier.ID, permission *models.Permission) (bool, error) {
+	subjectID := subject.String()
+	permissionID := identifier.PermissionID(permission.ID).String()
+	start := time.Now()
+	allowed, err := c.enforcer.Enforce(subjectID, permissionID, permission.Action)
+	if err != nil {
+		return false, err
+	}
+	if c.decisionLogger.Wants(allowed) {
+		d := &decision.Decision{
+			Time:    start.UTC(),
+			Subject: subjectID,
+			Object:  permissionID,
+			Action:  permission.Action,
+			Allowed: allowed,
+			Latency: time.Since(start),
+		}
+		if allowed {
+			d.Policy = c.matchedPolicy(subjectID, permissionID, permission.Action)
+		}
+		c.decisionLogger.Log(d)
+	}
+	return allowed, nil
+}
+
+func (c *authorizerService) AddGroupsForServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group) error {
+	return c.inTransaction(func(tx *pg.Tx) error {
+		return c.addServiceAccountGroups(ctx, tx, serviceAccount, groups)
+	})
+}
+
+// addServiceAccountGroups adds the service account to the groups within tx
+func (c *authorizerService) addServiceAccountGroups(ctx context.Context, tx *pg.Tx, serviceAccount *models.ServiceAccount, groups []*models.Group) error {
+	serviceAccountId := identifier.ServiceAccountID(serviceAccount.ID).String()
+	added := make([]int32, 0, len(groups))
+	for _, group := range groups {
+		groupID := identifier.GroupID(group.ID).String()
+		ok, err := c.addPolicy(tx, "g", serviceAccountId, groupID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			added = append(added, group.ID)
+		}
+	}
+	if err := c.touchGroups(tx, added); err != nil {
+		return err
+	}
+	return c.recordServiceAccountChange(ctx, tx, serviceAccount, audit.ServiceAccountGroupsAdded, events.ServiceAccountGroupAdded, "groups", added)
+}
+
+func (c *authorizerService) GetGroupIdsForServiceAccount(id int32) ([]int32, error) {
+	return c.groupIds(identifier.ServiceAccountID(id))
+}
+
+func (c *authorizerService) GetServiceAccountIdsForGroup(id int32) ([]int32, error) {
+	groupId := identifier.GroupID(id).String()
+
+	memberList, err := c.enforcer.GetUsersForRole(groupId)
+	if errors.Is(err, casbinerros.ERR_NAME_NOT_FOUND) {
+		return make([]int32, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sIds := make([]int32, 0)
+	for _, member := range memberList {
+		memberId, err := identifier.Parse(member)
+		if err != nil {
+			return nil, err
+		}
+		if memberId.Kind == identifier.ServiceAccount {
+			sIds = append(sIds, memberId.ID)
+		}
+	}
+	return sIds, nil
+}
+
+// groupIds returns the ids of the groups the subject is a member of
+func (c *authorizerService) groupIds(subject identifier.ID) ([]int32, error) {
+	groupList, err := c.enforcer.GetRolesForUser(subject.String())
+	if errors.Is(err, casbinerros.ERR_NAME_NOT_FOUND) {
+		return make([]int32, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	gIds := make([]int32, 0, len(groupList))
+	for _, group := range groupList {
+		gId, err := identifier.ParseKind(group, identifier.Group)
+		if err != nil {
+			return nil, err
+		}
+		gIds = append(gIds, gId)
+	}
+	return gIds, nil
+}
+
+func (c *authorizerService) RemoveGroupsForServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group) error {
+	serviceAccountId := identifier.ServiceAccountID(serviceAccount.ID).String()
+	return c.inTransaction(func(tx *pg.Tx) error {
+		removed := make([]int32, 0, len(groups))
+		for _, group := range groups {
+			groupID := identifier.GroupID(group.ID).String()
+			ok, err := c.removePolicy(tx, "g", serviceAccountId, groupID)
+			if err != nil {
+				return err
+			}
+			if ok {
+				removed = append(removed, group.ID)
+			}
+		}
+		if err := c.touchGroups(tx, removed); err != nil {
+			return err
+		}
+		return c.recordServiceAccountChange(ctx, tx, serviceAccount, audit.ServiceAccountGroupsRemoved, events.ServiceAccountGroupRemoved, "groups", removed)
+	})
+}
+
+func (c *authorizerService) AddPermissionsForServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount, permissions []*models.Permission) error {
+	serviceAccountId := identifier.ServiceAccountID(serviceAccount.ID).String()
+	return c.inTransaction(func(tx *pg.Tx) error {
+		added := make([]int32, 0, len(permissions))
+		for _, permission := range permissions {
+			permissionID := identifier.PermissionID(permission.ID).String()
+			ok, err := c.addPolicy(tx, "p", serviceAccountId, permissionID, permission.Action)
+			if err != nil {
+				return err
+			}
+			if ok {
+				added = append(added, permission.ID)
+			}
+		}
+		return c.recordServiceAccountChange(ctx, tx, serviceAccount, audit.ServiceAccountPermissionsAdded, events.ServiceAccountPermissionAdded, "permissions", added)
+	})
+}
+
+func (c *authorizerService) GetPermissionsForServiceAccount(id int32) ([]*models.Permission, error) {
+	serviceAccountId := identifier.ServiceAccountID(id).String()
+
+	pIds := make([]int32, 0)
+	for _, p := range c.enforcer.GetPermissionsForUser(serviceAccountId) {
+		pId, err := identifier.ParseKind(p[1], identifier.Permission)
+		if err != nil {
+			return nil, err
+		}
+		pIds = append(pIds, pId)
+	}
+	if len(pIds) == 0 {
+		return make([]*models.Permission, 0), nil
+	}
+	return c.permissionRepository.FindAllByIdIn(pIds), nil
+}
+
+func (c *authorizerService) RemovePermissionsForServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount, permissions []*models.Permission) error {
+	serviceAccountId := identifier.ServiceAccountID(serviceAccount.ID).String()
+	return c.inTransaction(func(tx *pg.Tx) error {
+		removed := make([]int32, 0, len(permissions))
+		for _, permission := range permissions {
+			permissionID := identifier.PermissionID(permission.ID).String()
+			ok, err := c.removePolicy(tx, "p", serviceAccountId, permissionID, permission.Action)
+			if err != nil {
+				return err
+			}
+			if ok {
+				removed = append(removed, permission.ID)
+			}
+		}
+		return c.recordServiceAccountChange(ctx, tx, serviceAccount, audit.ServiceAccountPermissionsRemoved, events.ServiceAccountPermissionRemoved, "permissions", removed)
+	})
+}
+
+func (c *authorizerService) DeleteServiceAccount(ctx context.Context, serviceAccount *models.ServiceAccount) error {
+	serviceAccountId := identifier.ServiceAccountID(serviceAccount.ID).String()
+	return c.inTransaction(func(tx *pg.Tx) error {
+		groupList, err := c.enforcer.GetRolesForUser(serviceAccountId)
+		if err != nil && !errors.Is(err, casbinerros.ERR_NAME_NOT_FOUND) {
+			return err
+		}
+		permissionList := c.enforcer.GetPermissionsForUser(serviceAccountId)
+
+		// the memberships of the service account and its grants
+		ok, err := c.removeFilteredPolicy(tx, "g", 0, serviceAccountId)
+		if err != nil {
+			return err
+		}
+		granted, err := c.removeFilteredPolicy(tx, "p", 0, serviceAccountId)
+		if err != nil || !(ok || granted) {
+			return err
+		}
+
+		before := map[string][]int32{"groups": {}, "permissions": {}}
+		for _, group := range groupList {
+			groupId, err := identifier.ParseKind(group, identifier.Group)
+			if err != nil {
+				return err
+			}
+			before["groups"] = append(before["groups"], groupId)
+		}
+		for _, p := range permissionList {
+			permissionId, err := identifier.ParseKind(p[1], identifier.Permission)
+			if err != nil {
+				return err
+			}
+			before["permissions"] = append(before["permissions"], permissionId)
+		}
+		if err := c.touchGroups(tx, before["groups"]); err != nil {
+			return err
+		}
+		return c.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: serviceAccount.OrganizationID,
+			Action:         audit.ServiceAccountPoliciesDeleted,
+			TargetType:     audit.TargetServiceAccount,
+			TargetID:       serviceAccount.ID,
+			Before:         before,
+		})
+	})
+}
+
+// matchedPolicy returns the policy line granting the action on the object to
+// the subject directly or through one of its groups
+func (c *authorizerService) matchedPolicy(subject, object, action string) []string {
+	subjects := []string{subject}
+	if roles, err := c.enforcer.GetRolesForUser(subject); err == nil {
+		subjects = append(subjects, roles...)
+	}
+	for _, sub := range subjects {
+		if c.enforcer.HasPolicy(sub, object, action) {
+			return []string{"p", sub, object, action}
+		}
+	}
+	return nil
+}
+
+// recordGroupChange bumps the revision of the group, records the ids of the
+// changed links and adds an event of eventType to the outbox within tx, calls
+// which did not change any link are not recorded
+func (c *authorizerService) recordGroupChange(ctx context.Context, tx *pg.Tx, id int32, action string, eventType string, field string, ids []int32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := c.touchGroups(tx, []int32{id}); err != nil {
+		return err
+	}
+	organizationID, err := c.groupOrganization(id)
+	if err != nil {
+		return err
+	}
+	err = c.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: organizationID,
+		Action:         action,
+		TargetType:     audit.TargetGroup,
+		TargetID:       id,
+		After:          map[string][]int32{field: ids},
+	})
+	if err != nil {
+		return err
+	}
+	event, err := events.New(eventType, organizationID, map[string]interface{}{"group_id": id, field: ids})
+	if err != nil {
+		return err
+	}
+	return c.outboxRepository.Add(tx, event)
+}
+
+// touchGroups bumps the revision of the groups within tx, the etag of a
+// group changes with its memberships and grants
+func (c *authorizerService) touchGroups(tx *pg.Tx, ids []int32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := tx.Exec("UPDATE groups SET revision = revision + 1, updated_at = now() WHERE id IN (?) AND deleted_at IS NULL", pg.In(ids))
+	return err
+}
+
+// recordServiceAccountChange records the ids of the changed links of the
+// service account and adds an event of eventType to the outbox within tx,
+// calls which did not change any link are not recorded
+func (c *authorizerService) recordServiceAccountChange(ctx context.Context, tx *pg.Tx, serviceAccount *models.ServiceAccount, action string, eventType string, field string, ids []int32) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := c.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: serviceAccount.OrganizationID,
+		Action:         action,
+		TargetType:     audit.TargetServiceAccount,
+		TargetID:       serviceAccount.ID,
+		After:          map[string][]int32{field: ids},
+	})
+	if err != nil {
+		return err
+	}
+	event, err := events.New(eventType, serviceAccount.OrganizationID, map[string]interface{}{"service_account_id": serviceAccount.ID, field: ids})
+	if err != nil {
+		return err
+	}
+	return c.outboxRepository.Add(tx, event)
+}
+
+// inTransaction runs fn with the policy changes and outbox events saved in
+// tx, transactions run one at a time. The policy is reloaded when tx is
+// rolled back to drop the changes the enforcer kept in memory.
+func (c *authorizerService) inTransaction(fn func(tx *pg.Tx) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err := c.db.RunInTransaction(fn)
+	if err != nil {
+		_ = c.enforcer.LoadPolicy()
+	}
+	return err
+}
+
+// addPolicy adds the line of ptype to the enforcer and saves it within tx,
+// it reports whether the line was added
+func (c *authorizerService) addPolicy(tx *pg.Tx, ptype string, rule ...string) (bool, error) {
+	var ok bool
+	var err error
+	if ptype == "g" {
+		ok, err = c.enforcer.AddGroupingPolicy(rule)
+	} else {
+		ok, err = c.enforcer.AddPolicy(rule)
+	}
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, c.adapter.AddPolicyTx(tx, ptype, rule)
+}
+
+// removePolicy removes the line of ptype from the enforcer and within tx,
+// it reports whether the line was removed
+func (c *authorizerService) removePolicy(tx *pg.Tx, ptype string, rule ...string) (bool, error) {
+	var ok bool
+	var err error
+	if ptype == "g" {
+		ok, err = c.enforcer.RemoveGroupingPolicy(rule)
+	} else {
+		ok, err = c.enforcer.RemovePolicy(rule)
+	}
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, c.adapter.RemovePolicyTx(tx, ptype, rule)
+}
+
+// removeFilteredPolicy removes the lines of ptype with value at fieldIndex
+// from the enforcer and within tx, it reports whether any line was removed
+func (c *authorizerService) removeFilteredPolicy(tx *pg.Tx, ptype string, fieldIndex int, value string) (bool, error) {
+	var ok bool
+	var err error
+	if ptype == "g" {
+		ok, err = c.enforcer.RemoveFilteredGroupingPolicy(fieldIndex, value)
+	} else {
+		ok, err = c.enforcer.RemoveFilteredPolicy(fieldIndex, value)
+	}
+	if err != nil || !ok {
+		return ok, err
+	}
+	return true, c.adapter.RemoveFilteredPolicyTx(tx, ptype, fieldIndex, value)
+}
+
+// groupOrganization returns the organization of the group, deleted or not
+func (c *authorizerService) groupOrganization(id int32) (int32, error) {
+	var organizationID int32
+	_, err := c.db.QueryOne(pg.Scan(&organizationID), "SELECT organization_id FROM groups WHERE id = ?", id)
+	if errors.Is(err, pg.ErrNoRows) {
+		// the group is gone, the change is recorded without organization
+		return 0, nil
+	}
+	return organizationID, err
+}
@@ -0,0 +1,221 @@
+// Package authz wires the authz repositories, services, policy enforcer and
+// http api together so authz can be embedded in-process by other services.
+package authz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/apikeys"
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/checks"
+	"github.com/imtanmoy/authz/decision"
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/groups"
+	"github.com/imtanmoy/authz/guard"
+	"github.com/imtanmoy/authz/imports"
+	"github.com/imtanmoy/authz/logger"
+	"github.com/imtanmoy/authz/members"
+	"github.com/imtanmoy/authz/memberships"
+	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/outbox"
+	"github.com/imtanmoy/authz/permissions"
+	"github.com/imtanmoy/authz/scim"
+	"github.com/imtanmoy/authz/server"
+	"github.com/imtanmoy/authz/serviceaccounts"
+	"github.com/imtanmoy/authz/stream"
+	"github.com/imtanmoy/authz/users"
+	"github.com/imtanmoy/authz/webhooks"
+)
+
+// Options holds the explicit dependencies of an Authz instance
+type Options struct {
+	// DB is the database holding authz tables, it is not closed by Authz
+	DB *pg.DB
+	// PolicyReloadInterval is how often policies are reloaded from DB,
+	// zero disables periodic reloading
+	PolicyReloadInterval time.Duration
+	// Authenticators accepted by the http api in addition to api keys
+	Authenticators []auth.Authenticator
+	// DeletedGroupRetention is how long deleted groups can be restored before
+	// they are purged, zero keeps them forever
+	DeletedGroupRetention time.Duration
+	// Logger reports errors of background jobs, nil discards them
+	Logger logger.Logger
+	// Decisions configures the logging of authorization decisions
+	Decisions decision.Options
+	// Sinks receive the change events of the outbox after the event streams and
+	// webhooks, the outbox tracks sinks by position so new ones are appended
+	Sinks []events.Publisher
+	// EventHistory is the number of events kept for clients resuming event
+	// streams, zero keeps defaultEventHistory events
+	EventHistory int
+	// Webhooks configures the addresses webhooks may target
+	Webhooks webhooks.Options
+}
+
+// defaultEventHistory is the number of events kept for resuming event
+// streams unless Options.EventHistory is set
+const defaultEventHistory = 1000
+
+// Authz is a self contained authz instance, it exposes the typed services
+// for in-process use and an http.Handler serving the management api
+type Authz struct {
+	Organizations organizations.Service
+	Users         users.Service
+	Groups        groups.Service
+	Permissions   permissions.Service
+	Authorizer    authorizer.Service
+	APIKeys       apikeys.Service
+	Audit         audit.Service
+	// Webhooks queues the events published to it, Run delivers them and is
+	// not started by New
+	Webhooks    webhooks.Service
+	SCIM        scim.Service
+	Memberships memberships.Service
+	// Members manages the memberships of users in organizations other than
+	// their own
+	Members members.Service
+	// ServiceAccounts manages the service accounts of organizations, principals
+	// checked like users
+	ServiceAccounts serviceaccounts.Service
+	// Imports runs user imports, Close waits for the running import jobs
+	Imports imports.Service
+	// Outbox publishes change events to the event streams, the webhook
+	// queue and Options.Sinks, it is not started by New
+	Outbox outbox.Dispatcher
+	Events stream.Hub
+
+	enforcer  *casbin.SyncedEnforcer
+	decisions decision.Logger
+	handler   http.Handler
+	stop      chan struct{}
+	done      sync.WaitGroup
+}
+
+// New creates an Authz instance from opts
+func New(opts Options) (*Authz, error) {
+	if opts.DB == nil {
+		return nil, errors.New("authz: database is required")
+	}
+	db := opts.DB
+
+	enforcer, err := authorizer.NewEnforcer(db, opts.PolicyReloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	decisions, err := decision.NewDecisionLogger(opts.Decisions, opts.Logger)
+	if err != nil {
+		enforcer.StopAutoLoadPolicy()
+		return nil, err
+	}
+
+	a := &Authz{enforcer: enforcer, decisions: decisions, stop: make(chan struct{})}
+	a.Audit = audit.NewAuditService(db)
+	a.Webhooks, err = webhooks.NewWebhookService(db, opts.Webhooks, opts.Logger)
+	if err != nil {
+		enforcer.StopAutoLoadPolicy()
+		_ = decisions.Close()
+		return nil, err
+	}
+	a.Authorizer = authorizer.NewAuthorizerService(db, enforcer, a.Audit, decisions)
+	a.Permissions = permissions.NewPermissionService(db)
+	a.Organizations = organizations.NewOrganizationService(db, a.Permissions, a.Audit)
+	a.Users = users.NewUserService(db, a.Audit)
+	a.Groups = groups.NewGroupService(db, a.Authorizer, a.Audit)
+	a.APIKeys = apikeys.NewAPIKeyService(db)
+	a.SCIM = scim.NewScimService(db, a.Users, a.Groups, a.Authorizer)
+	a.Memberships = memberships.NewMembershipService(db, a.Authorizer)
+	a.Imports = imports.NewImportService(db, a.Users, a.Authorizer, opts.Logger)
+	a.ServiceAccounts = serviceaccounts.NewServiceAccountService(db, a.Authorizer, a.Audit)
+	a.Members = members.NewMemberService(db, a.Authorizer, a.Audit)
+	eventHistory := opts.EventHistory
+	if eventHistory == 0 {
+		eventHistory = defaultEventHistory
+	}
+	a.Events = stream.NewHub(eventHistory)
+	a.Outbox = outbox.NewDispatcher(db, opts.Logger, append([]events.Publisher{a.Events, a.Webhooks}, opts.Sinks...)...)
+
+	authenticators := append([]auth.Authenticator{apikeys.NewAuthenticator(a.APIKeys)}, opts.Authenticators...)
+	systemGuard := guard.NewGuard(a.Permissions, a.Authorizer)
+	a.handler, err = server.New(server.Handlers{
+		Organizations:   organizations.NewOrganizationHandler(db, a.Organizations),
+		Users:           users.NewUserHandler(db, a.Users, a.Organizations),
+		Groups:          groups.NewGroupHandler(db, a.Groups, a.Organizations, a.Users, a.Permissions, systemGuard),
+		APIKeys:         apikeys.NewAPIKeyHandler(db, a.APIKeys),
+		Audit:           audit.NewAuditHandler(db, a.Audit),
+		Webhooks:        webhooks.NewWebhookHandler(db, a.Webhooks),
+		Events:          stream.NewStreamHandler(a.Events),
+		SCIM:            scim.NewScimHandler(db, a.SCIM),
+		Memberships:     memberships.NewMembershipHandler(db, a.Memberships, a.Organizations),
+		Imports:         imports.NewImportHandler(db, a.Imports),
+		ServiceAccounts: serviceaccounts.NewServiceAccountHandler(db, a.ServiceAccounts, a.Organizations),
+		Checks:          checks.NewCheckHandler(db, a.Authorizer, a.Organizations, a.Users, a.ServiceAccounts),
+		Members:         members.NewMemberHandler(db, a.Members, a.Users),
+	}, systemGuard, authenticators...)
+	if err != nil {
+		enforcer.StopAutoLoadPolicy()
+		_ = decisions.Close()
+		return nil, err
+	}
+
+	if opts.DeletedGroupRetention > 0 {
+		a.done.Add(1)
+		go a.purgeDeletedGroups(opts.DeletedGroupRetention, opts.Logger)
+	}
+	return a, nil
+}
+
+// purgeDeletedGroups periodically removes the groups deleted longer than
+// retention ago until Close is called
+func (a *Authz) purgeDeletedGroups(retention time.Duration, log logger.Logger) {
+	defer a.done.Done()
+	interval := retention / 24
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := a.Groups.Purge(context.Background(), retention)
+		if log != nil {
+			if err != nil {
+				log.Errorf("deleted groups could not be purged : %s", err)
+			} else if n > 0 {
+				log.Infof("%d deleted groups purged", n)
+			}
+		}
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Handler returns the http.Handler serving the management api
+func (a *Authz) Handler() http.Handler {
+	return a.handler
+}
+
+// Close stops background policy reloading and jobs, waits for the running
+// import jobs and closes the decision log, the database is left open
+func (a *Authz) Close() error {
+	close(a.stop)
+	a.done.Wait()
+	a.Imports.Wait()
+	a.enforcer.StopAutoLoadPolicy()
+	return a.decisions.Close()
+}
@@ -0,0 +1,200 @@
+package authz
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/decision"
+	"github.com/imtanmoy/authz/logger"
+	"github.com/imtanmoy/authz/webhooks"
+)
+
+// emptyDB starts an in-process server speaking enough of the postgres
+// protocol to answer every query with an empty result, so instances can be
+// built without a database
+func emptyDB(t *testing.T) *pg.DB {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveEmpty(conn)
+		}
+	}()
+	db := pg.Connect(&pg.Options{Addr: listener.Addr().String(), User: "authz"})
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = listener.Close()
+	})
+	return db
+}
+
+func serveEmpty(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	// the startup message has no type
+	if _, err := readMessage(r, false); err != nil {
+		return
+	}
+	writeMessage(conn, 'R', []byte{0, 0, 0, 0})
+	writeMessage(conn, 'K', make([]byte, 8))
+	writeMessage(conn, 'Z', []byte{'I'})
+	for {
+		typ, err := readMessage(r, true)
+		if err != nil || typ == 'X' {
+			return
+		}
+		if typ != 'Q' {
+			continue
+		}
+		writeMessage(conn, 'T', []byte{0, 0})
+		writeMessage(conn, 'C', []byte("SELECT 0\x00"))
+		writeMessage(conn, 'Z', []byte{'I'})
+	}
+}
+
+func readMessage(r *bufio.Reader, typed bool) (byte, error) {
+	var typ byte
+	if typed {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		typ = b
+	}
+	var size int32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return 0, err
+	}
+	_, err := io.CopyN(ioutil.Discard, r, int64(size-4))
+	return typ, err
+}
+
+func writeMessage(w io.Writer, typ byte, body []byte) {
+	msg := make([]byte, 5, 5+len(body))
+	msg[0] = typ
+	binary.BigEndian.PutUint32(msg[1:], uint32(4+len(body)))
+	_, _ = w.Write(append(msg, body...))
+}
+
+// tokenAuthenticator accepts the bearer token as a superadmin
+type tokenAuthenticator string
+
+func (a tokenAuthenticator) Authenticate(r *http.Request) (*auth.Caller, error) {
+	token := r.Header.Get("Authorization")
+	if token == "" {
+		return nil, auth.ErrNoCredentials
+	}
+	if token != "Bearer "+string(a) {
+		return nil, errors.New("invalid token")
+	}
+	return &auth.Caller{Kind: auth.KindSystem, Subject: string(a), Scopes: []string{auth.ScopeAdmin}}, nil
+}
+
+// recordingLogger counts the messages logged through it
+type recordingLogger struct {
+	logger.Logger
+	infos int
+}
+
+func (l *recordingLogger) Info(args ...interface{}) { l.infos++ }
+
+func (l *recordingLogger) WithFields(keyValues logger.Fields) logger.Logger { return l }
+
+func TestInstancesDoNotShareState(t *testing.T) {
+	logA := &recordingLogger{}
+	a, err := New(Options{
+		DB:             emptyDB(t),
+		Authenticators: []auth.Authenticator{tokenAuthenticator("a")},
+		Logger:         logA,
+		Decisions:      decision.Options{Enabled: true, SampleRate: 1},
+		Webhooks:       webhooks.Options{AllowedNetworks: []string{"10.0.0.0/8"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(Options{
+		DB:             emptyDB(t),
+		Authenticators: []auth.Authenticator{tokenAuthenticator("b")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	target := &url.URL{Scheme: "http", Host: "10.1.2.3"}
+	if err := a.Webhooks.CheckURL(target); err != nil {
+		t.Errorf("a: webhook url of an allowed network rejected: %s", err)
+	}
+	if err := b.Webhooks.CheckURL(target); err == nil {
+		t.Error("b: webhook url of a private network accepted")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		handler http.Handler
+		token   string
+		want    int
+	}{
+		{"a with its token", a.Handler(), "a", http.StatusOK},
+		{"a with the token of b", a.Handler(), "b", http.StatusUnauthorized},
+		{"b with its token", b.Handler(), "b", http.StatusOK},
+		{"b with the token of a", b.Handler(), "a", http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/organizations", nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		rec := httptest.NewRecorder()
+		tc.handler.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, rec.Code, tc.want)
+		}
+	}
+
+	// policies live in the enforcer of their instance
+	if _, err := a.enforcer.AddPolicy("1", "1", "read"); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, _ := a.enforcer.Enforce("1", "1", "read"); !allowed {
+		t.Error("a: policy was not added")
+	}
+	if allowed, _ := b.enforcer.Enforce("1", "1", "read"); allowed {
+		t.Error("b: policy of a applies")
+	}
+
+	// decisions are logged by the instance configured to
+	if !a.decisions.Wants(true) || b.decisions.Wants(true) {
+		t.Error("decision logging is not configured per instance")
+	}
+	a.decisions.Log(&decision.Decision{Allowed: true})
+	if logA.infos != 1 {
+		t.Errorf("a: %d decisions logged, want 1", logA.infos)
+	}
+
+	// closing an instance leaves the other running
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	rec := httptest.NewRecorder()
+	b.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("b: ping status %d after a was closed", rec.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package checks
+
+import (
+	"net/http"
+	"net/url"
+
+	"gopkg.in/thedevsaddam/govalidator.v1"
+
+	"github.com/imtanmoy/authz/identifier"
+)
+
+type CheckPayload struct {
+	// Subject is the user or service account checked, e.g. "user::1" or "service::2"
+	Subject    string `json:"subject"`
+	Permission int32  `json:"permission"`
+}
+
+func (c *CheckPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+// validate validates the payload and returns the parsed subject
+func (c *CheckPayload) validate() (identifier.ID, url.Values) {
+	rules := govalidator.MapData{
+		"subject":    []string{"required"},
+		"permission": []string{"required"},
+	}
+	opts := govalidator.Options{
+		Data:  c,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	if c.Subject == "" {
+		return identifier.ID{}, e
+	}
+	subject, err := identifier.Parse(c.Subject)
+	if err != nil || (subject.Kind != identifier.User && subject.Kind != identifier.ServiceAccount) {
+		e.Add("subject", "The subject field must be a user::id or service::id identifier")
+	}
+	return subject, e
+}
+
+type CheckResponse struct {
+	Subject    string `json:"subject"`
+	Permission int32  `json:"permission"`
+	Action     string `json:"action"`
+	Allowed    bool   `json:"allowed"`
+}
+
+func (c *CheckResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
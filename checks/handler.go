@@ -0,0 +1,103 @@
+// Package checks answers whether a user or service account of an
+// organization was granted a permission.
+package checks
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/identifier"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/serviceaccounts"
+	"github.com/imtanmoy/authz/users"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// Handler handles checks http method
+type Handler interface {
+	Check(w http.ResponseWriter, r *http.Request)
+}
+
+type checkHandler struct {
+	db                    *pg.DB
+	authorizerService     authorizer.Service
+	organizationService   organizations.Service
+	userService           users.Service
+	serviceAccountService serviceaccounts.Service
+}
+
+var _ Handler = (*checkHandler)(nil)
+
+// NewCheckHandler construct check handler
+func NewCheckHandler(
+	db *pg.DB,
+	authorizerService authorizer.Service,
+	organizationService organizations.Service,
+	userService users.Service,
+	serviceAccountService serviceaccounts.Service,
+) Handler {
+	return &checkHandler{
+		db:                    db,
+		authorizerService:     authorizerService,
+		organizationService:   organizationService,
+		userService:           userService,
+		serviceAccountService: serviceAccountService,
+	}
+}
+
+// Check reports whether the subject was granted the permission directly or
+// through its groups, both must belong to the request organization
+func (c *checkHandler) Check(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	data := &CheckPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+	subject, validationErrors := data.validate()
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	var err error
+	switch subject.Kind {
+	case identifier.User:
+		_, err = c.userService.FindByIdAndOrganizationId(subject.ID, organization.ID)
+	case identifier.ServiceAccount:
+		_, err = c.serviceAccountService.FindByIdAndOrganizationId(subject.ID, organization.ID)
+	}
+	if err != nil {
+		validationErrors.Add("subject", "invalid subject")
+	}
+	permissionList, _ := c.organizationService.FindPermissionsByIds(organization, []int32{data.Permission})
+	if len(permissionList) != 1 {
+		validationErrors.Add("permission", "invalid permission")
+	}
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	permission := permissionList[0]
+	allowed, err := c.authorizerService.HasPermissionForSubject(subject, permission)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	_ = render.Render(w, r, &CheckResponse{
+		Subject:    subject.String(),
+		Permission: permission.ID,
+		Action:     permission.Action,
+		Allowed:    allowed,
+	})
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/imtanmoy/authz/apikeys"
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/config"
+	"github.com/imtanmoy/authz/db"
+	"github.com/imtanmoy/authz/logger"
+	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/permissions"
+)
+
+var apiKeyName string
+var apiKeyOrganization int32
+
+func init() {
+	apiKeyCreateCmd.Flags().StringVar(&apiKeyName, "name", "bootstrap", "name of the api key")
+	apiKeyCreateCmd.Flags().Int32Var(&apiKeyOrganization, "organization", 0, "organization id the key is restricted to, superadmin key when omitted")
+	apiKeyCmd.AddCommand(apiKeyCreateCmd)
+	rootCmd.AddCommand(apiKeyCmd)
+}
+
+var apiKeyCmd = &cobra.Command{
+	Use:   "apikey",
+	Short: "manage api keys",
+}
+
+var apiKeyCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "create an api key, the key is printed only once",
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.New(config.Conf)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Database Could not be initiated", err)
+		}
+		defer database.Close()
+
+		organizationService := organizations.NewOrganizationService(database, permissions.NewPermissionService(database), audit.NewAuditService(database))
+		if apiKeyOrganization != 0 && !organizationService.Exists(apiKeyOrganization) {
+			logger.Fatalf("organization %d does not exist", apiKeyOrganization)
+		}
+		apiKey, key, err := apikeys.NewAPIKeyService(database).Create(apiKeyName, apiKeyOrganization)
+		if err != nil {
+			logger.Fatalf("%s : %s", "API key could not be created", err)
+		}
+		logger.Infof("API key %d (%s) created", apiKey.ID, apiKey.Prefix)
+		fmt.Println(key)
+	},
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/config"
+	"github.com/imtanmoy/authz/db"
+	"github.com/imtanmoy/authz/logger"
+)
+
+var auditCheckpointFile string
+var auditPublicKeyFile string
+var auditSigningKeyFile string
+var auditOutFile string
+
+func init() {
+	auditVerifyCmd.Flags().StringVar(&auditCheckpointFile, "checkpoint", "", "signed checkpoint file the chains must still contain")
+	auditVerifyCmd.Flags().StringVar(&auditPublicKeyFile, "public-key", "", "PEM ed25519 public key verifying the checkpoint")
+	auditCheckpointCmd.Flags().StringVar(&auditSigningKeyFile, "key", "", "PEM PKCS #8 ed25519 private key signing the checkpoint")
+	auditCheckpointCmd.Flags().StringVar(&auditOutFile, "out", "", "file the checkpoint is written to, stdout when omitted")
+	_ = auditCheckpointCmd.MarkFlagRequired("key")
+	auditCmd.AddCommand(auditVerifyCmd)
+	auditCmd.AddCommand(auditCheckpointCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "verify and export the audit log hash chains",
+}
+
+var auditVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "walk the audit log hash chains and report the first broken link of each organization",
+	Run: func(cmd *cobra.Command, args []string) {
+		var checkpoints []*audit.Checkpoint
+		if auditCheckpointFile != "" {
+			if auditPublicKeyFile == "" {
+				logger.Fatal("--public-key is required to verify a checkpoint")
+			}
+			signed, err := readCheckpoint(auditCheckpointFile)
+			if err != nil {
+				logger.Fatalf("%s : %s", "Checkpoint could not be read", err)
+			}
+			key, err := audit.LoadVerifyingKey(auditPublicKeyFile)
+			if err != nil {
+				logger.Fatalf("%s : %s", "Public key could not be loaded", err)
+			}
+			if err := signed.Verify(key); err != nil {
+				logger.Fatalf("%s : %s", "Checkpoint could not be verified", err)
+			}
+			checkpoints = signed.Checkpoints
+		}
+
+		database, err := db.New(config.Conf)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Database Could not be initiated", err)
+		}
+		defer database.Close()
+
+		breaks, heads, err := audit.NewAuditService(database).Verify(checkpoints...)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Audit log could not be verified", err)
+		}
+		for _, head := range heads {
+			fmt.Printf("organization %d: %d entries verified up to entry %d\n", head.OrganizationID, head.Entries, head.EntryID)
+		}
+		for _, b := range breaks {
+			fmt.Printf("BROKEN %s\n", b)
+		}
+		if len(breaks) > 0 {
+			database.Close()
+			os.Exit(1)
+		}
+	},
+}
+
+var auditCheckpointCmd = &cobra.Command{
+	Use:   "checkpoint",
+	Short: "export the signed heads of the audit log hash chains for external archiving",
+	Run: func(cmd *cobra.Command, args []string) {
+		key, err := audit.LoadSigningKey(auditSigningKeyFile)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Signing key could not be loaded", err)
+		}
+
+		database, err := db.New(config.Conf)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Database Could not be initiated", err)
+		}
+		defer database.Close()
+
+		breaks, heads, err := audit.NewAuditService(database).Verify()
+		if err != nil {
+			logger.Fatalf("%s : %s", "Audit log could not be verified", err)
+		}
+		if len(breaks) > 0 {
+			// never sign a chain which was already tampered with
+			for _, b := range breaks {
+				fmt.Printf("BROKEN %s\n", b)
+			}
+			database.Close()
+			os.Exit(1)
+		}
+
+		signed, err := audit.Sign(heads, key)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Checkpoint could not be signed", err)
+		}
+		data, err := json.MarshalIndent(signed, "", "  ")
+		if err != nil {
+			logger.Fatalf("%s : %s", "Checkpoint could not be encoded", err)
+		}
+		if auditOutFile == "" {
+			fmt.Println(string(data))
+			return
+		}
+		if err := ioutil.WriteFile(auditOutFile, append(data, '\n'), 0644); err != nil {
+			logger.Fatalf("%s : %s", "Checkpoint could not be written", err)
+		}
+		logger.Infof("checkpoint of %d organizations written to %s", len(heads), auditOutFile)
+	},
+}
+
+func readCheckpoint(path string) (*audit.SignedCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var signed audit.SignedCheckpoint
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, err
+	}
+	return &signed, nil
+}
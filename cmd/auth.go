@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/auth/jwt"
+	"github.com/imtanmoy/authz/config"
+	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/users"
+)
+
+// idResolver resolves the external ids of token claims
+type idResolver struct {
+	organizationRepository organizations.Repository
+	userRepository         users.Repository
+}
+
+var _ jwt.Resolver = (*idResolver)(nil)
+
+func (i *idResolver) OrganizationID(externalID string) (int32, error) {
+	organization, err := i.organizationRepository.FindByExternalId(externalID)
+	if err != nil {
+		return 0, err
+	}
+	return organization.ID, nil
+}
+
+func (i *idResolver) UserID(organizationID int32, externalID string) (int32, error) {
+	user, err := i.userRepository.FindByExternalIdAndOrganizationId(externalID, organizationID)
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
+
+func (i *idResolver) CheckUserID(organizationID int32, id int32) error {
+	if organizationID == 0 {
+		_, err := i.userRepository.Find(id)
+		return err
+	}
+	_, err := i.userRepository.FindByIdAndOrganizationId(id, organizationID)
+	return err
+}
+
+// configuredAuthenticators builds the configured authenticators in addition to api keys
+func configuredAuthenticators(conf config.Config, db *pg.DB) ([]auth.Authenticator, error) {
+	jwtConf := conf.AUTH.JWT
+	var keys []jwt.Key
+	if jwtConf.SECRET != "" {
+		keys = append(keys, jwt.HMACKey(jwtConf.SECRET))
+	}
+	for _, path := range jwtConf.JWKSFILES {
+		jwks, err := jwt.LoadJWKSFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jwks...)
+	}
+	for _, path := range jwtConf.KEYFILES {
+		pemKeys, err := jwt.LoadPEMFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pemKeys...)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return []auth.Authenticator{jwt.NewAuthenticator(jwt.Options{
+		Keys:              keys,
+		Issuer:            jwtConf.ISSUER,
+		Audience:          jwtConf.AUDIENCE,
+		OrganizationClaim: jwtConf.ORGANIZATIONCLAIM,
+		ScopeClaim:        jwtConf.SCOPECLAIM,
+		MaxLifetime:       jwtConf.MAXLIFETIME,
+		Resolver: &idResolver{
+			organizationRepository: organizations.NewOrganizationRepository(db),
+			userRepository:         users.NewUserRepository(db),
+		},
+	})}, nil
+}
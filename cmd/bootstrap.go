@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/config"
+	"github.com/imtanmoy/authz/db"
+	"github.com/imtanmoy/authz/logger"
+	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/permissions"
+	"github.com/imtanmoy/authz/utils/pagination"
+)
+
+func init() {
+	rootCmd.AddCommand(bootstrapCmd)
+}
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "create the reserved system permissions of every organization",
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.New(config.Conf)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Database Could not be initiated", err)
+		}
+		defer database.Close()
+
+		permissionService := permissions.NewPermissionService(database)
+		organizationService := organizations.NewOrganizationService(database, permissionService, audit.NewAuditService(database))
+		params := pagination.NewParams()
+		params.Limit = pagination.MaxLimit
+		for {
+			organizationList, err := organizationService.List(params)
+			if err != nil {
+				logger.Fatalf("%s : %s", "Organizations could not be listed", err)
+			}
+			n, _ := params.Page(len(organizationList), func(i int) (int32, interface{}) {
+				return organizationList[i].ID, organizationList[i].ID
+			})
+			for _, organization := range organizationList[:n] {
+				if _, err := permissionService.Bootstrap(organization); err != nil {
+					logger.Fatalf("organization %d could not be bootstrapped : %s", organization.ID, err)
+				}
+				logger.Infof("organization %d bootstrapped", organization.ID)
+			}
+			if n == len(organizationList) {
+				break
+			}
+			params.Cursor = &pagination.Cursor{Sort: params.Sort, ID: organizationList[n-1].ID}
+		}
+	},
+}
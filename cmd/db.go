@@ -3,6 +3,7 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 
+	"github.com/imtanmoy/authz/config"
 	"github.com/imtanmoy/authz/db"
 	"github.com/imtanmoy/authz/logger"
 )
@@ -15,10 +16,11 @@ var dbCmd = &cobra.Command{
 	Use:   "db",
 	Short: "database command",
 	Run: func(cmd *cobra.Command, args []string) {
-		err := db.InitDB()
+		database, err := db.New(config.Conf)
 		if err != nil {
 			logger.Fatalf("%s : %s", "Database Could not be initiated", err)
 		}
+		defer database.Close()
 		logger.Info("Database Initiated...")
 	},
 }
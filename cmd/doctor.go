@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/imtanmoy/authz/config"
+	"github.com/imtanmoy/authz/db"
+	"github.com/imtanmoy/authz/doctor"
+	"github.com/imtanmoy/authz/logger"
+)
+
+var doctorFix bool
+
+func init() {
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "remove or repair the inconsistent policy lines")
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "check casbin_rules for lines inconsistent with users, groups and permissions",
+	Run: func(cmd *cobra.Command, args []string) {
+		database, err := db.New(config.Conf)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Database Could not be initiated", err)
+		}
+		defer database.Close()
+
+		d := doctor.NewDoctor(database)
+		if !doctorFix {
+			issues, err := d.Diagnose()
+			if err != nil {
+				logger.Fatalf("%s : %s", "Policy could not be checked", err)
+			}
+			for _, issue := range issues {
+				fmt.Printf("%-18s %s\n", issue.Kind, issue)
+			}
+			if len(issues) > 0 {
+				fmt.Printf("%d problems found, run with --fix to repair them\n", len(issues))
+				database.Close()
+				os.Exit(1)
+			}
+			fmt.Println("no problems found")
+			return
+		}
+
+		issues, err := d.Fix()
+		if err != nil {
+			logger.Fatalf("%s : %s", "Policy could not be repaired", err)
+		}
+		for _, issue := range issues {
+			fmt.Printf("%-18s %s => %s\n", issue.Kind, issue, issue.Repair())
+		}
+		// running servers pick the changes up on their next policy reload
+		fmt.Printf("%d problems fixed\n", len(issues))
+	},
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/imtanmoy/authz"
+	"github.com/imtanmoy/authz/config"
+	"github.com/imtanmoy/authz/db"
+	"github.com/imtanmoy/authz/ldapsync"
+	"github.com/imtanmoy/authz/logger"
+)
+
+var ldapOrganization int32
+var ldapDryRun bool
+
+func init() {
+	ldapSyncCmd.Flags().Int32Var(&ldapOrganization, "organization", 0, "organization id whose groups are synced")
+	ldapSyncCmd.Flags().BoolVar(&ldapDryRun, "dry-run", false, "only report the membership changes")
+	_ = ldapSyncCmd.MarkFlagRequired("organization")
+	ldapCmd.AddCommand(ldapSyncCmd)
+	rootCmd.AddCommand(ldapCmd)
+}
+
+var ldapCmd = &cobra.Command{
+	Use:   "ldap",
+	Short: "synchronize groups with an LDAP directory",
+}
+
+var ldapSyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "reconcile the members of the mapped groups with their directory groups and print a report",
+	Run: func(cmd *cobra.Command, args []string) {
+		directory, err := ldapsync.NewLDAPDirectory(ldapOptions(config.Conf))
+		if err != nil {
+			logger.Fatalf("%s : %s", "Directory could not be configured", err)
+		}
+
+		database, err := db.New(config.Conf)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Database Could not be initiated", err)
+		}
+		defer database.Close()
+
+		app, err := authz.New(authz.Options{DB: database, Logger: logger.Default()})
+		if err != nil {
+			logger.Fatalf("%s : %s", "Authorizer Could not be initiated", err)
+		}
+		defer app.Close()
+
+		organization, err := app.Organizations.Find(ldapOrganization)
+		if err != nil {
+			logger.Fatalf("organization %d could not be found : %s", ldapOrganization, err)
+		}
+		syncer, err := ldapsync.NewSyncer(database, directory, app.Groups, app.Authorizer, ldapMappings(config.Conf))
+		if err != nil {
+			logger.Fatalf("%s : %s", "Sync could not be configured", err)
+		}
+		report, err := syncer.Sync(context.Background(), organization, ldapDryRun)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Groups could not be synced", err)
+		}
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			logger.Fatalf("%s : %s", "Report could not be encoded", err)
+		}
+		fmt.Println(string(data))
+	},
+}
+
+// ldapOptions returns the directory options of the configuration
+func ldapOptions(conf config.Config) ldapsync.Options {
+	return ldapsync.Options{
+		URL:             conf.LDAP.URL,
+		BindDN:          conf.LDAP.BINDDN,
+		BindPassword:    conf.LDAP.BINDPASSWORD,
+		StartTLS:        conf.LDAP.STARTTLS,
+		BaseDN:          conf.LDAP.BASEDN,
+		GroupFilter:     conf.LDAP.GROUPFILTER,
+		NameAttribute:   conf.LDAP.NAMEATTRIBUTE,
+		MemberAttribute: conf.LDAP.MEMBERATTRIBUTE,
+		MailAttribute:   conf.LDAP.MAILATTRIBUTE,
+	}
+}
+
+// ldapMappings returns the group mappings of the configuration
+func ldapMappings(conf config.Config) []ldapsync.Mapping {
+	mappings := make([]ldapsync.Mapping, len(conf.LDAP.MAPPINGS))
+	for i, m := range conf.LDAP.MAPPINGS {
+		mappings[i] = ldapsync.Mapping{DN: m.DN, Pattern: m.PATTERN, Group: m.GROUP}
+	}
+	return mappings
+}
@@ -0,0 +1,27 @@
+package cmd
+
+import (
+	"github.com/nats-io/nats.go"
+
+	"github.com/imtanmoy/authz/config"
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/outbox"
+)
+
+// configuredSinks builds the configured event sinks in addition to webhooks
+// and returns a func closing their connections
+func configuredSinks(conf config.Config) ([]events.Publisher, func(), error) {
+	natsConf := conf.OUTBOX.NATS
+	if natsConf.URL == "" {
+		return nil, func() {}, nil
+	}
+	conn, err := nats.Connect(natsConf.URL, nats.Name("authz"))
+	if err != nil {
+		return nil, nil, err
+	}
+	subject := natsConf.SUBJECT
+	if subject == "" {
+		subject = "authz"
+	}
+	return []events.Publisher{outbox.NewNATSSink(conn, subject)}, conn.Close, nil
+}
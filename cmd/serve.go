@@ -4,14 +4,19 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 
-	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz"
+	"github.com/imtanmoy/authz/config"
 	"github.com/imtanmoy/authz/db"
+	"github.com/imtanmoy/authz/decision"
 	"github.com/imtanmoy/authz/logger"
 	"github.com/imtanmoy/authz/server"
+	"github.com/imtanmoy/authz/webhooks"
 )
 
 func init() {
@@ -23,21 +28,39 @@ var serveCmd = &cobra.Command{
 	Short: "start http server with configured api",
 	Run: func(cmd *cobra.Command, args []string) {
 		// initializing database
-		err := db.InitDB()
+		database, err := db.New(config.Conf)
 		if err != nil {
 			logger.Fatalf("%s : %s", "Database Could not be initiated", err)
 		}
 		logger.Info("Database Initiated...")
 
-		// initializing authorizer
-		err = authorizer.Init(db.DB)
+		// initializing authz
+		authenticators, err := configuredAuthenticators(config.Conf, database)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Authenticators Could not be initiated", err)
+		}
+		sinks, closeSinks, err := configuredSinks(config.Conf)
+		if err != nil {
+			logger.Fatalf("%s : %s", "Event sinks Could not be initiated", err)
+		}
+		app, err := authz.New(authz.Options{
+			DB:                    database,
+			PolicyReloadInterval:  30 * time.Second,
+			Authenticators:        authenticators,
+			DeletedGroupRetention: config.Conf.GROUPS.RETENTION,
+			Logger:                logger.Default(),
+			Decisions:             decisionOptions(config.Conf),
+			Sinks:                 sinks,
+			Webhooks:              webhooks.Options{AllowedNetworks: config.Conf.WEBHOOKS.ALLOWEDNETWORKS},
+		})
 		if err != nil {
 			logger.Fatalf("%s : %s", "Authorizer Could not be initiated", err)
 		}
 		logger.Info("Authorizer Initiated...")
 
 		// initializing server
-		server, err := server.NewServer()
+		addr := config.Conf.SERVER.HOST + ":" + strconv.Itoa(config.Conf.SERVER.PORT)
+		server, err := server.NewServer(addr, app.Handler(), logger.Default())
 		if err != nil {
 			logger.Fatalf("%s : %s", "Server could not be started", err)
 		}
@@ -52,9 +75,46 @@ var serveCmd = &cobra.Command{
 			cancel()
 		}()
 
+		// publishing change events
+		dispatched := make(chan struct{})
+		go func() {
+			defer close(dispatched)
+			app.Outbox.Run(ctx)
+		}()
+		// delivering webhooks
+		delivered := make(chan struct{})
+		go func() {
+			defer close(delivered)
+			app.Webhooks.Run(ctx)
+		}()
+
 		if err := server.Start(ctx); err != nil {
 			logger.Infof("failed to serve:+%v\n", err)
 		}
 		close(c)
+		cancel()
+		<-dispatched
+		<-delivered
+
+		_ = app.Close()
+		closeSinks()
+		if err := database.Close(); err != nil {
+			logger.Errorf("%s : %s", "Database shutdown failed", err)
+		}
 	},
 }
+
+func decisionOptions(conf config.Config) decision.Options {
+	return decision.Options{
+		Enabled:    conf.DECISIONS.ENABLED,
+		SampleRate: conf.DECISIONS.SAMPLERATE,
+		Filter:     conf.DECISIONS.FILTER,
+		Sink:       conf.DECISIONS.SINK,
+		File: decision.FileOptions{
+			Path:       conf.DECISIONS.FILE.PATH,
+			MaxSize:    conf.DECISIONS.FILE.MAXSIZE,
+			MaxBackups: conf.DECISIONS.FILE.MAXBACKUPS,
+			MaxAge:     conf.DECISIONS.FILE.MAXAGE,
+		},
+	}
+}
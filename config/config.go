@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,6 +15,12 @@ type Config struct {
 	DEBUG       bool   `mapstructure:"debug"`
 	SERVER      server
 	DB          db
+	AUTH        auth
+	GROUPS      groups
+	DECISIONS   decisions
+	OUTBOX      outbox
+	WEBHOOKS    webhooks
+	LDAP        ldap
 }
 
 type server struct {
@@ -21,6 +28,72 @@ type server struct {
 	PORT int    `mapstructure:"port"`
 }
 
+type auth struct {
+	JWT jwt `mapstructure:"jwt"`
+}
+
+type jwt struct {
+	ISSUER            string        `mapstructure:"issuer"`
+	AUDIENCE          string        `mapstructure:"audience"`
+	SECRET            string        `mapstructure:"secret"`
+	JWKSFILES         []string      `mapstructure:"jwks_files"`
+	KEYFILES          []string      `mapstructure:"key_files"`
+	ORGANIZATIONCLAIM string        `mapstructure:"organization_claim"`
+	SCOPECLAIM        string        `mapstructure:"scope_claim"`
+	MAXLIFETIME       time.Duration `mapstructure:"max_lifetime"`
+}
+
+type groups struct {
+	RETENTION time.Duration `mapstructure:"retention"`
+}
+
+type decisions struct {
+	ENABLED    bool          `mapstructure:"enabled"`
+	SAMPLERATE float64       `mapstructure:"sample_rate"`
+	FILTER     string        `mapstructure:"filter"`
+	SINK       string        `mapstructure:"sink"`
+	FILE       decisionsFile `mapstructure:"file"`
+}
+
+type decisionsFile struct {
+	PATH       string `mapstructure:"path"`
+	MAXSIZE    int    `mapstructure:"max_size"`
+	MAXBACKUPS int    `mapstructure:"max_backups"`
+	MAXAGE     int    `mapstructure:"max_age"`
+}
+
+type outbox struct {
+	NATS outboxNATS `mapstructure:"nats"`
+}
+
+type outboxNATS struct {
+	URL     string `mapstructure:"url"`
+	SUBJECT string `mapstructure:"subject"`
+}
+
+type webhooks struct {
+	ALLOWEDNETWORKS []string `mapstructure:"allowed_networks"`
+}
+
+type ldap struct {
+	URL             string        `mapstructure:"url"`
+	BINDDN          string        `mapstructure:"bind_dn"`
+	BINDPASSWORD    string        `mapstructure:"bind_password"`
+	STARTTLS        bool          `mapstructure:"start_tls"`
+	BASEDN          string        `mapstructure:"base_dn"`
+	GROUPFILTER     string        `mapstructure:"group_filter"`
+	NAMEATTRIBUTE   string        `mapstructure:"name_attribute"`
+	MEMBERATTRIBUTE string        `mapstructure:"member_attribute"`
+	MAILATTRIBUTE   string        `mapstructure:"mail_attribute"`
+	MAPPINGS        []ldapMapping `mapstructure:"mappings"`
+}
+
+type ldapMapping struct {
+	DN      string `mapstructure:"dn"`
+	PATTERN string `mapstructure:"pattern"`
+	GROUP   string `mapstructure:"group"`
+}
+
 type db struct {
 	HOST     string `mapstructure:"host"`
 	PORT     int    `mapstructure:"port"`
@@ -9,8 +9,6 @@ import (
 	"github.com/imtanmoy/authz/config"
 )
 
-var DB *pg.DB
-
 type dbLogger struct{}
 
 func (d dbLogger) BeforeQuery(c context.Context, q *pg.QueryEvent) (context.Context, error) {
@@ -22,24 +20,20 @@ func (d dbLogger) AfterQuery(c context.Context, q *pg.QueryEvent) error {
 	return nil
 }
 
-func InitDB() error {
+// New connects to the configured database and checks the connection
+func New(conf config.Config) (*pg.DB, error) {
 	db := pg.Connect(&pg.Options{
-		User:     config.Conf.DB.USERNAME,
-		Password: config.Conf.DB.PASSWORD,
-		Database: config.Conf.DB.DBNAME,
-		Addr:     config.Conf.DB.HOST + ":" + strconv.Itoa(config.Conf.DB.PORT),
+		User:     conf.DB.USERNAME,
+		Password: conf.DB.PASSWORD,
+		Database: conf.DB.DBNAME,
+		Addr:     conf.DB.HOST + ":" + strconv.Itoa(conf.DB.PORT),
 	})
 	var n int
 	_, err := db.QueryOne(pg.Scan(&n), "SELECT 1")
 	if err != nil {
-		return err
+		_ = db.Close()
+		return nil, err
 	}
 	db.AddQueryHook(dbLogger{})
-	DB = db
-	return nil
-}
-
-func Shutdown() error {
-	err := DB.Close()
-	return err
+	return db, nil
 }
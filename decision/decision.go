@@ -0,0 +1,208 @@
+// Package decision logs the authorization decisions made by the enforcer,
+// sampled and filtered by their result.
+package decision
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/imtanmoy/authz/logger"
+)
+
+// Decision filters
+const (
+	FilterAll   = "all"
+	FilterAllow = "allow"
+	FilterDeny  = "deny"
+)
+
+// Decision sinks
+const (
+	SinkLogger = "logger"
+	SinkFile   = "file"
+)
+
+// Decision is the result of one enforcement
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Object  string    `json:"object"`
+	Action  string    `json:"action"`
+	Allowed bool      `json:"allowed"`
+	// Policy is the policy line which allowed the request, empty for denials
+	Policy  []string      `json:"policy,omitempty"`
+	Latency time.Duration `json:"latency_ns"`
+}
+
+// Options configures the decision logger
+type Options struct {
+	// Enabled turns decision logging on
+	Enabled bool
+	// SampleRate is the fraction of matching decisions logged, from 0 to 1
+	SampleRate float64
+	// Filter logs all, only allow or only deny decisions
+	Filter string
+	// Sink writes decisions to the application logger or to File
+	Sink string
+	File FileOptions
+}
+
+// FileOptions configures the rotating file sink
+type FileOptions struct {
+	Path string
+	// MaxSize is the size in megabytes of the file before it is rotated
+	MaxSize int
+	// MaxBackups is the number of rotated files kept, zero keeps all
+	MaxBackups int
+	// MaxAge is the number of days rotated files are kept, zero keeps them forever
+	MaxAge int
+}
+
+type Logger interface {
+	// Wants reports whether a decision with the result is logged, callers
+	// skip building decisions which are filtered or sampled out
+	Wants(allowed bool) bool
+	// Log writes the decision to the sink
+	Log(decision *Decision)
+	// Close flushes and releases the sink
+	Close() error
+}
+
+// NewDecisionLogger creates the logger configured by opts, sink errors are
+// reported to log. A disabled logger wants no decision.
+func NewDecisionLogger(opts Options, log logger.Logger) (Logger, error) {
+	if !opts.Enabled {
+		return &nopLogger{}, nil
+	}
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		return nil, errors.New("decision: sample rate must be between 0 and 1")
+	}
+	d := &decisionLogger{sampleRate: opts.SampleRate, log: log}
+	switch opts.Filter {
+	case "", FilterAll:
+		d.allow, d.deny = true, true
+	case FilterAllow:
+		d.allow = true
+	case FilterDeny:
+		d.deny = true
+	default:
+		return nil, errors.New("decision: unknown filter " + opts.Filter)
+	}
+	switch opts.Sink {
+	case "", SinkLogger:
+		if log == nil {
+			return nil, errors.New("decision: logger sink requires a logger")
+		}
+		d.sink = &loggerSink{log: log}
+	case SinkFile:
+		if opts.File.Path == "" {
+			return nil, errors.New("decision: file sink requires a path")
+		}
+		d.sink = &fileSink{out: &lumberjack.Logger{
+			Filename:   opts.File.Path,
+			MaxSize:    opts.File.MaxSize,
+			MaxBackups: opts.File.MaxBackups,
+			MaxAge:     opts.File.MaxAge,
+		}}
+	default:
+		return nil, errors.New("decision: unknown sink " + opts.Sink)
+	}
+	return d, nil
+}
+
+type decisionLogger struct {
+	sampleRate float64
+	allow      bool
+	deny       bool
+	sink       sink
+	log        logger.Logger
+}
+
+var _ Logger = (*decisionLogger)(nil)
+
+func (d *decisionLogger) Wants(allowed bool) bool {
+	if allowed && !d.allow || !allowed && !d.deny {
+		return false
+	}
+	return d.sampleRate >= 1 || rand.Float64() < d.sampleRate
+}
+
+func (d *decisionLogger) Log(decision *Decision) {
+	if err := d.sink.write(decision); err != nil && d.log != nil {
+		d.log.Errorf("decision could not be logged : %s", err)
+	}
+}
+
+func (d *decisionLogger) Close() error {
+	return d.sink.close()
+}
+
+type nopLogger struct{}
+
+var _ Logger = (*nopLogger)(nil)
+
+func (n *nopLogger) Wants(allowed bool) bool {
+	return false
+}
+
+func (n *nopLogger) Log(decision *Decision) {}
+
+func (n *nopLogger) Close() error {
+	return nil
+}
+
+type sink interface {
+	write(decision *Decision) error
+	close() error
+}
+
+// loggerSink writes decisions as structured fields of the application logger
+type loggerSink struct {
+	log logger.Logger
+}
+
+func (l *loggerSink) write(decision *Decision) error {
+	l.log.WithFields(logger.Fields{
+		"time":       decision.Time.Format(time.RFC3339Nano),
+		"subject":    decision.Subject,
+		"object":     decision.Object,
+		"action":     decision.Action,
+		"allowed":    decision.Allowed,
+		"policy":     decision.Policy,
+		"latency_ns": decision.Latency.Nanoseconds(),
+	}).Info("authorization decision")
+	return nil
+}
+
+func (l *loggerSink) close() error {
+	return nil
+}
+
+// fileSink writes decisions as JSON lines to a rotating file
+type fileSink struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+}
+
+func (f *fileSink) write(decision *Decision) error {
+	line, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.out.Write(append(line, '\n'))
+	return err
+}
+
+func (f *fileSink) close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.out.Close()
+}
@@ -1,17 +1,33 @@
+-- ids may be supplied by callers or generated, external_id holds the id of
+-- the organization in the identity provider
 CREATE TABLE organizations
 (
-    id   BIGINT PRIMARY KEY NOT NULL,
-    name VARCHAR(255)       NOT NULL
+    id          BIGSERIAL PRIMARY KEY NOT NULL,
+    external_id VARCHAR(255)          NULL,
+    name        VARCHAR(255)          NOT NULL,
+    created_at  TIMESTAMP             NOT NULL DEFAULT NOW()
 );
 
 CREATE TABLE users
 (
-    id              BIGINT PRIMARY KEY NOT NULL,
-    email           VARCHAR(128)       NOT NULL,
-    organization_id BIGINT             NOT NULL
+    id              BIGSERIAL PRIMARY KEY NOT NULL,
+    external_id     VARCHAR(255)          NULL,
+    email           VARCHAR(128)          NOT NULL,
+    organization_id BIGINT                NOT NULL,
+    created_at      TIMESTAMP             NOT NULL DEFAULT NOW()
+);
+
+-- users belong to the organization of users.organization_id and may be
+-- invited as members of other organizations
+CREATE TABLE organization_members
+(
+    organization_id BIGINT    NOT NULL,
+    user_id         BIGINT    NOT NULL,
+    created_at      TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (organization_id, user_id)
 );
 
-create type permission_type as enum('feature', 'resource');
+create type permission_type as enum('feature', 'resource', 'system');
 
 CREATE TABLE permissions
 (
@@ -30,8 +46,10 @@ CREATE TABLE groups
     name            VARCHAR(128)          NOT NULL,
     organization_id BIGINT                NOT NULL,
     created_at      TIMESTAMP             NOT NULL DEFAULT NOW(),
-    updated_at      TIMESTAMP
---     deleted_at      TIMESTAMP             NULL     DEFAULT NOW()
+    updated_at      TIMESTAMP,
+    revision        INTEGER               NOT NULL DEFAULT 1,
+    deleted_at      TIMESTAMP             NULL,
+    snapshot        JSONB                 NULL
 );
 
 CREATE TABLE casbin_rules
@@ -45,20 +63,194 @@ CREATE TABLE casbin_rules
     v5              VARCHAR(256)
 );
 
+CREATE TABLE api_keys
+(
+    id              BIGSERIAL PRIMARY KEY NOT NULL,
+    name            VARCHAR(128)          NOT NULL,
+    prefix          VARCHAR(16)           NOT NULL,
+    key_hash        VARCHAR(64)           NOT NULL,
+    organization_id BIGINT                NULL,
+    created_at      TIMESTAMP             NOT NULL DEFAULT NOW(),
+    revoked_at      TIMESTAMP             NULL
+);
+
+CREATE TABLE webhooks
+(
+    id              BIGSERIAL PRIMARY KEY NOT NULL,
+    organization_id BIGINT                NOT NULL,
+    url             VARCHAR(2048)         NOT NULL,
+    secret          VARCHAR(128)          NOT NULL,
+    events          TEXT[]                NOT NULL DEFAULT '{}',
+    active          BOOLEAN               NOT NULL DEFAULT TRUE,
+    created_at      TIMESTAMP             NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMP
+);
+
+-- one row per delivery attempt
+CREATE TABLE webhook_deliveries
+(
+    id          BIGSERIAL PRIMARY KEY NOT NULL,
+    webhook_id  BIGINT                NOT NULL,
+    event_id    VARCHAR(64)           NOT NULL,
+    event_type  VARCHAR(64)           NOT NULL,
+    payload     JSONB                 NULL,
+    attempt     INTEGER               NOT NULL,
+    status_code INTEGER               NULL,
+    error       TEXT                  NULL,
+    duration_ms BIGINT                NOT NULL DEFAULT 0,
+    created_at  TIMESTAMP             NOT NULL DEFAULT NOW()
+);
+
+-- events waiting to be delivered to a webhook, removed once delivered or
+-- given up
+CREATE TABLE webhook_jobs
+(
+    id           BIGSERIAL PRIMARY KEY NOT NULL,
+    webhook_id   BIGINT                NOT NULL,
+    event_id     VARCHAR(64)           NOT NULL,
+    event_type   VARCHAR(64)           NOT NULL,
+    payload      JSONB                 NOT NULL,
+    attempts     INTEGER               NOT NULL DEFAULT 0,
+    available_at TIMESTAMP             NOT NULL DEFAULT NOW(),
+    created_at   TIMESTAMP             NOT NULL DEFAULT NOW()
+);
+
+-- outbox rows are written in the transaction of the change they describe
+CREATE TABLE outbox
+(
+    id              BIGSERIAL PRIMARY KEY NOT NULL,
+    event_id        VARCHAR(64)           NOT NULL,
+    event_type      VARCHAR(64)           NOT NULL,
+    organization_id BIGINT                NULL,
+    data            JSONB                 NULL,
+    attempts        INTEGER               NOT NULL DEFAULT 0,
+    last_error      TEXT                  NULL,
+    -- positions of the sinks which received the event
+    published_sinks SMALLINT[]            NOT NULL DEFAULT '{}',
+    available_at    TIMESTAMP             NOT NULL DEFAULT NOW(),
+    created_at      TIMESTAMP             NOT NULL DEFAULT NOW(),
+    published_at    TIMESTAMP             NULL
+);
+
+CREATE INDEX idx_outbox_pending ON outbox (available_at, id) WHERE published_at IS NULL;
+CREATE INDEX idx_outbox_published_at ON outbox (published_at) WHERE published_at IS NOT NULL;
+
+-- progress of the user imports run in the background
+CREATE TABLE import_jobs
+(
+    id              BIGSERIAL PRIMARY KEY NOT NULL,
+    organization_id BIGINT                NOT NULL,
+    status          VARCHAR(16)           NOT NULL,
+    total           INTEGER               NOT NULL DEFAULT 0,
+    imported        INTEGER               NOT NULL DEFAULT 0,
+    error           TEXT                  NULL,
+    created_at      TIMESTAMP             NOT NULL DEFAULT NOW(),
+    finished_at     TIMESTAMP             NULL
+);
+
+-- principals of batch jobs and services, named within their organization
+CREATE TABLE service_accounts
+(
+    id              BIGSERIAL PRIMARY KEY NOT NULL,
+    organization_id BIGINT                NOT NULL,
+    name            VARCHAR(64)           NOT NULL,
+    description     VARCHAR(255)          NULL,
+    created_at      TIMESTAMP             NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMP             NULL
+);
+
+-- audit_log has no foreign keys so the history outlives the audited entities
+CREATE TABLE audit_log
+(
+    id              BIGSERIAL PRIMARY KEY NOT NULL,
+    organization_id BIGINT                NULL,
+    actor           VARCHAR(256)          NOT NULL,
+    action          VARCHAR(64)           NOT NULL,
+    target_type     VARCHAR(32)           NOT NULL,
+    target_id       BIGINT                NOT NULL,
+    before          JSONB                 NULL,
+    after           JSONB                 NULL,
+    request_id      VARCHAR(128)          NULL,
+    created_at      TIMESTAMP             NOT NULL DEFAULT NOW(),
+    prev_hash       VARCHAR(64)           NULL,
+    hash            VARCHAR(64)           NOT NULL
+);
+
+CREATE INDEX idx_audit_log_organization ON audit_log (organization_id, id);
+CREATE INDEX idx_audit_log_target ON audit_log (target_type, target_id);
+
+CREATE FUNCTION audit_log_append_only() RETURNS TRIGGER AS
+$$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE OR TRUNCATE
+    ON audit_log
+    EXECUTE PROCEDURE audit_log_append_only();
+
+-- the id sequence is moved past ids supplied by callers, so generated ids do
+-- not collide with them
+CREATE FUNCTION advance_id_sequence() RETURNS TRIGGER AS
+$$
+DECLARE
+    seq REGCLASS := pg_get_serial_sequence(TG_TABLE_NAME, 'id')::REGCLASS;
+BEGIN
+    IF NEW.id > COALESCE(pg_sequence_last_value(seq), 0) THEN
+        -- concurrent inserts of supplied ids move the sequence one at a time
+        PERFORM pg_advisory_xact_lock(hashtext(seq::TEXT));
+        PERFORM setval(seq, GREATEST(NEW.id, COALESCE(pg_sequence_last_value(seq), 0)));
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER organizations_advance_id_sequence
+    AFTER INSERT
+    ON organizations
+    FOR EACH ROW
+    EXECUTE PROCEDURE advance_id_sequence();
+
+CREATE TRIGGER users_advance_id_sequence
+    AFTER INSERT
+    ON users
+    FOR EACH ROW
+    EXECUTE PROCEDURE advance_id_sequence();
+
 ALTER TABLE users
     ADD CONSTRAINT fk_users_organization
         FOREIGN KEY (organization_id)
             REFERENCES organizations (id) ON DELETE CASCADE;
 
+ALTER TABLE organization_members
+    ADD CONSTRAINT fk_organization_members_organization
+        FOREIGN KEY (organization_id)
+            REFERENCES organizations (id) ON DELETE CASCADE;
+
+ALTER TABLE organization_members
+    ADD CONSTRAINT fk_organization_members_user
+        FOREIGN KEY (user_id)
+            REFERENCES users (id) ON DELETE CASCADE;
+
+CREATE INDEX idx_organization_members_user ON organization_members (user_id);
+
 ALTER TABLE groups
     ADD CONSTRAINT fk_groups_organization
         FOREIGN KEY (organization_id)
             REFERENCES organizations (id) ON DELETE CASCADE;
 
-ALTER TABLE groups
-    ADD CONSTRAINT uk_groups_name_org UNIQUE (name, organization_id);
+-- names only have to be unique among groups which are not deleted
+CREATE UNIQUE INDEX uk_groups_name_org ON groups (name, organization_id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_groups_deleted_at ON groups (deleted_at) WHERE deleted_at IS NOT NULL;
 
 
+CREATE INDEX idx_users_organization ON users (organization_id, id);
+CREATE UNIQUE INDEX uk_organizations_external_id ON organizations (external_id);
+CREATE UNIQUE INDEX uk_users_external_id_org ON users (organization_id, external_id);
+CREATE INDEX idx_groups_organization ON groups (organization_id, id);
+
 ALTER TABLE permissions
     ADD CONSTRAINT fk_permissions_organization
         FOREIGN KEY (organization_id)
@@ -67,8 +259,46 @@ ALTER TABLE permissions
 ALTER TABLE permissions
     ADD CONSTRAINT uk_permissions_name_org UNIQUE (name, organization_id);
 
--- ALTER TABLE groups
---     ADD CONSTRAINT uk_groups_name_org_del UNIQUE (name, organization_id, deleted_at);
+ALTER TABLE api_keys
+    ADD CONSTRAINT fk_api_keys_organization
+        FOREIGN KEY (organization_id)
+            REFERENCES organizations (id) ON DELETE CASCADE;
+
+ALTER TABLE api_keys
+    ADD CONSTRAINT uk_api_keys_prefix UNIQUE (prefix);
+
+ALTER TABLE webhooks
+    ADD CONSTRAINT fk_webhooks_organization
+        FOREIGN KEY (organization_id)
+            REFERENCES organizations (id) ON DELETE CASCADE;
+
+ALTER TABLE webhook_deliveries
+    ADD CONSTRAINT fk_webhook_deliveries_webhook
+        FOREIGN KEY (webhook_id)
+            REFERENCES webhooks (id) ON DELETE CASCADE;
+
+CREATE INDEX idx_webhooks_organization ON webhooks (organization_id, id);
+CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+
+ALTER TABLE webhook_jobs
+    ADD CONSTRAINT fk_webhook_jobs_webhook
+        FOREIGN KEY (webhook_id)
+            REFERENCES webhooks (id) ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX uk_webhook_jobs_event ON webhook_jobs (webhook_id, event_id);
+CREATE INDEX idx_webhook_jobs_available_at ON webhook_jobs (available_at, id);
+
+ALTER TABLE import_jobs
+    ADD CONSTRAINT fk_import_jobs_organization
+        FOREIGN KEY (organization_id)
+            REFERENCES organizations (id) ON DELETE CASCADE;
+
+ALTER TABLE service_accounts
+    ADD CONSTRAINT fk_service_accounts_organization
+        FOREIGN KEY (organization_id)
+            REFERENCES organizations (id) ON DELETE CASCADE;
+
+CREATE UNIQUE INDEX uk_service_accounts_name_org ON service_accounts (organization_id, name);
 
 
 INSERT INTO organizations (id, name)
@@ -99,3 +329,20 @@ VALUES (4, 'PERMISSION_4', 'ALL', 1);
 INSERT INTO permissions (id, name, action, organization_id)
 VALUES (5, 'PERMISSION_5', 'ALL', 1);
 
+SELECT setval('organizations_id_seq', (SELECT MAX(id) FROM organizations));
+SELECT setval('users_id_seq', (SELECT MAX(id) FROM users));
+SELECT setval('groups_id_seq', (SELECT MAX(id) FROM groups));
+SELECT setval('permissions_id_seq', (SELECT MAX(id) FROM permissions));
+
+-- reserved system permissions guarding the management api
+INSERT INTO permissions (name, action, type, organization_id)
+SELECT p.name, 'ALL', 'system', o.id
+FROM organizations o
+         CROSS JOIN (VALUES ('authz.organizations.write'),
+                            ('authz.users.write'),
+                            ('authz.groups.write'),
+                            ('authz.api_keys.write'),
+                            ('authz.audit.read'),
+                            ('authz.webhooks.write'),
+                            ('authz.service_accounts.write')) AS p (name);
+
@@ -0,0 +1,396 @@
+// Package doctor finds and repairs policy lines in casbin_rules which are
+// inconsistent with the users, service accounts, groups and permissions tables.
+package doctor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+
+	"github.com/imtanmoy/authz/authorizer/adapter"
+	"github.com/imtanmoy/authz/identifier"
+)
+
+// Kind classifies the problem of a policy line
+type Kind string
+
+const (
+	// KindMalformed lines have an unknown type or subjects which can not be parsed
+	KindMalformed Kind = "malformed"
+	// KindDuplicate lines repeat an earlier line
+	KindDuplicate Kind = "duplicate"
+	// KindMissingUser lines reference a user which does not exist
+	KindMissingUser Kind = "missing_user"
+	// KindMissingServiceAccount lines reference a service account which does not exist
+	KindMissingServiceAccount Kind = "missing_service_account"
+	// KindMissingGroup lines reference a group which does not exist
+	KindMissingGroup Kind = "missing_group"
+	// KindDeletedGroup lines reference a group which was deleted
+	KindDeletedGroup Kind = "deleted_group"
+	// KindMissingPermission lines reference a permission which does not exist
+	KindMissingPermission Kind = "missing_permission"
+	// KindCrossOrganization lines link entities of different organizations
+	KindCrossOrganization Kind = "cross_organization"
+	// KindActionMismatch lines grant a permission with another action than its own
+	KindActionMismatch Kind = "action_mismatch"
+)
+
+// Issue is a problem found in a policy line
+type Issue struct {
+	Kind   Kind
+	Rule   adapter.CasbinRule
+	Reason string
+	// Action is the action a mismatching grant is repaired to,
+	// the line is removed when it is empty
+	Action string
+
+	ctid string
+}
+
+// String formats the issue as the policy line followed by its reason
+func (i *Issue) String() string {
+	return fmt.Sprintf("%s: %s", formatRule(&i.Rule), i.Reason)
+}
+
+// Repair describes how the issue is fixed
+func (i *Issue) Repair() string {
+	if i.Action != "" {
+		return fmt.Sprintf("set action to %s", i.Action)
+	}
+	return "remove line"
+}
+
+// Doctor checks the consistency of the policy lines
+type Doctor interface {
+	// Diagnose returns the problems of the policy lines
+	Diagnose() ([]*Issue, error)
+	// Fix repairs or removes the problematic policy lines in one transaction
+	// and returns the problems it fixed
+	Fix() ([]*Issue, error)
+}
+
+type doctor struct {
+	db *pg.DB
+}
+
+var _ Doctor = (*doctor)(nil)
+
+// NewDoctor creates a Doctor for the authz tables in db
+func NewDoctor(db *pg.DB) Doctor {
+	return &doctor{db: db}
+}
+
+func (d *doctor) Diagnose() ([]*Issue, error) {
+	return diagnose(d.db)
+}
+
+func (d *doctor) Fix() ([]*Issue, error) {
+	var issues []*Issue
+	err := d.db.RunInTransaction(func(tx *pg.Tx) error {
+		// keep the enforcer from writing lines while they are repaired
+		if _, err := tx.Exec("LOCK TABLE casbin_rules IN EXCLUSIVE MODE"); err != nil {
+			return err
+		}
+		var err error
+		issues, err = diagnose(tx)
+		if err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			if issue.Action != "" {
+				_, err = tx.Exec("UPDATE casbin_rules SET v2 = ? WHERE ctid = ?::tid", issue.Action, issue.ctid)
+			} else {
+				_, err = tx.Exec("DELETE FROM casbin_rules WHERE ctid = ?::tid", issue.ctid)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return issues, err
+}
+
+type line struct {
+	CTID  string `pg:"ctid"`
+	PType string `pg:"p_type"`
+	V0    string `pg:"v0"`
+	V1    string `pg:"v1"`
+	V2    string `pg:"v2"`
+	V3    string `pg:"v3"`
+	V4    string `pg:"v4"`
+	V5    string `pg:"v5"`
+}
+
+type entity struct {
+	ID             int32     `pg:"id"`
+	OrganizationID int32     `pg:"organization_id"`
+	Action         string    `pg:"action"`
+	DeletedAt      time.Time `pg:"deleted_at"`
+}
+
+type membership struct {
+	OrganizationID int32 `pg:"organization_id"`
+	UserID         int32 `pg:"user_id"`
+}
+
+// diagnose scans every policy line against the entities loaded from db
+func diagnose(db orm.DB) ([]*Issue, error) {
+	var lines []*line
+	if _, err := db.Query(&lines, `SELECT ctid::text AS ctid, * FROM casbin_rules ORDER BY ctid`); err != nil {
+		return nil, err
+	}
+	users, err := loadEntities(db, `SELECT id, organization_id FROM users`)
+	if err != nil {
+		return nil, err
+	}
+	var memberships []*membership
+	if _, err := db.Query(&memberships, `SELECT organization_id, user_id FROM organization_members`); err != nil {
+		return nil, err
+	}
+	members := make(map[int32]map[int32]bool)
+	for _, m := range memberships {
+		if members[m.UserID] == nil {
+			members[m.UserID] = make(map[int32]bool)
+		}
+		members[m.UserID][m.OrganizationID] = true
+	}
+	serviceAccounts, err := loadEntities(db, `SELECT id, organization_id FROM service_accounts`)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := loadEntities(db, `SELECT id, organization_id, deleted_at FROM groups`)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := loadEntities(db, `SELECT id, organization_id, action FROM permissions`)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checker{
+		users:           users,
+		members:         members,
+		serviceAccounts: serviceAccounts,
+		groups:          groups,
+		permissions:     permissions,
+		seen:            make(map[string]bool, len(lines)),
+	}
+	issues := make([]*Issue, 0)
+	for _, l := range lines {
+		if issue := c.check(l); issue != nil {
+			issues = append(issues, issue)
+		}
+	}
+	return issues, nil
+}
+
+func loadEntities(db orm.DB, query string) (map[int32]*entity, error) {
+	var list []*entity
+	if _, err := db.Query(&list, query); err != nil {
+		return nil, err
+	}
+	entities := make(map[int32]*entity, len(list))
+	for _, e := range list {
+		entities[e.ID] = e
+	}
+	return entities, nil
+}
+
+type checker struct {
+	users map[int32]*entity
+	// members holds the organizations users were invited to by user id
+	members         map[int32]map[int32]bool
+	serviceAccounts map[int32]*entity
+	groups          map[int32]*entity
+	permissions     map[int32]*entity
+	seen            map[string]bool
+}
+
+// check returns the issue of l, or nil if l is consistent
+func (c *checker) check(l *line) *Issue {
+	rule := adapter.CasbinRule{PType: l.PType, V0: l.V0, V1: l.V1, V2: l.V2, V3: l.V3, V4: l.V4, V5: l.V5}
+	issue := &Issue{Rule: rule, ctid: l.CTID}
+
+	switch l.PType {
+	case "p":
+		kind, reason, action := c.checkPolicy(l)
+		if kind != "" {
+			issue.Kind, issue.Reason = kind, reason
+			return issue
+		}
+		if action != "" {
+			// the repaired line may repeat a line granting the right action
+			repaired := *l
+			repaired.V2 = action
+			if c.duplicate(&repaired) {
+				issue.Kind, issue.Reason = KindDuplicate, "line repeats an earlier line once its action is repaired"
+				return issue
+			}
+			issue.Kind, issue.Reason, issue.Action = KindActionMismatch, reason, action
+			return issue
+		}
+	case "g":
+		kind, reason := c.checkGrouping(l)
+		if kind != "" {
+			issue.Kind, issue.Reason = kind, reason
+			return issue
+		}
+	default:
+		issue.Kind, issue.Reason = KindMalformed, fmt.Sprintf("unknown policy type %q", l.PType)
+		return issue
+	}
+
+	if c.duplicate(l) {
+		issue.Kind, issue.Reason = KindDuplicate, "line repeats an earlier line"
+		return issue
+	}
+	return nil
+}
+
+// checkPolicy checks a `p, group::id, permission::id, action` line, or a
+// direct grant to a user or service account, a mismatching action is
+// returned for repair
+func (c *checker) checkPolicy(l *line) (Kind, string, string) {
+	if l.V3 != "" || l.V4 != "" || l.V5 != "" {
+		return KindMalformed, "policy line has extra fields", ""
+	}
+	sub, err := identifier.Parse(l.V0)
+	if err != nil {
+		return KindMalformed, err.Error(), ""
+	}
+	id := sub.ID
+	var subject *entity
+	switch sub.Kind {
+	case identifier.Group:
+		group, ok := c.groups[id]
+		if !ok {
+			return KindMissingGroup, fmt.Sprintf("group %d does not exist", id), ""
+		}
+		if !group.DeletedAt.IsZero() {
+			return KindDeletedGroup, fmt.Sprintf("group %d was deleted", id), ""
+		}
+		subject = group
+	case identifier.User, identifier.ServiceAccount:
+		var kind Kind
+		subject, kind = c.member(sub)
+		if kind != "" {
+			return kind, fmt.Sprintf("%s %d does not exist", memberName(sub.Kind), id), ""
+		}
+	default:
+		return KindMalformed, fmt.Sprintf("policy subject %q is not a user, service account or group", l.V0), ""
+	}
+
+	id, err = identifier.ParseKind(l.V1, identifier.Permission)
+	if err != nil {
+		return KindMalformed, err.Error(), ""
+	}
+	permission, ok := c.permissions[id]
+	if !ok {
+		return KindMissingPermission, fmt.Sprintf("permission %d does not exist", id), ""
+	}
+	if !c.inOrganization(sub, subject, permission.OrganizationID) {
+		return KindCrossOrganization, fmt.Sprintf(
+			"%s belongs to organization %d but permission %d to organization %d",
+			l.V0, subject.OrganizationID, id, permission.OrganizationID,
+		), ""
+	}
+	if l.V2 != permission.Action {
+		return "", fmt.Sprintf("permission %d has action %s", id, permission.Action), permission.Action
+	}
+	return "", "", ""
+}
+
+// checkGrouping checks a `g, user::id, group::id` or a
+// `g, service::id, group::id` line
+func (c *checker) checkGrouping(l *line) (Kind, string) {
+	if l.V2 != "" || l.V3 != "" || l.V4 != "" || l.V5 != "" {
+		return KindMalformed, "grouping line has extra fields"
+	}
+	sub, err := identifier.Parse(l.V0)
+	if err != nil {
+		return KindMalformed, err.Error()
+	}
+	if sub.Kind != identifier.User && sub.Kind != identifier.ServiceAccount {
+		return KindMalformed, fmt.Sprintf("group member %q is not a user or service account", l.V0)
+	}
+	groupID, err := identifier.ParseKind(l.V1, identifier.Group)
+	if err != nil {
+		return KindMalformed, err.Error()
+	}
+
+	member, kind := c.member(sub)
+	if kind != "" {
+		return kind, fmt.Sprintf("%s %d does not exist", memberName(sub.Kind), sub.ID)
+	}
+	group, ok := c.groups[groupID]
+	if !ok {
+		return KindMissingGroup, fmt.Sprintf("group %d does not exist", groupID)
+	}
+	if !group.DeletedAt.IsZero() {
+		return KindDeletedGroup, fmt.Sprintf("group %d was deleted", groupID)
+	}
+	if !c.inOrganization(sub, member, group.OrganizationID) {
+		return KindCrossOrganization, fmt.Sprintf(
+			"%s %d belongs to organization %d but group %d to organization %d",
+			memberName(sub.Kind), sub.ID, member.OrganizationID, groupID, group.OrganizationID,
+		)
+	}
+	return "", ""
+}
+
+// member returns the user or service account identified by sub, or the kind
+// of issue if it does not exist
+func (c *checker) member(sub identifier.ID) (*entity, Kind) {
+	if sub.Kind == identifier.ServiceAccount {
+		serviceAccount, ok := c.serviceAccounts[sub.ID]
+		if !ok {
+			return nil, KindMissingServiceAccount
+		}
+		return serviceAccount, ""
+	}
+	user, ok := c.users[sub.ID]
+	if !ok {
+		return nil, KindMissingUser
+	}
+	return user, ""
+}
+
+// inOrganization reports whether the subject sub loaded as e belongs to the
+// organization, users also when they are members of it
+func (c *checker) inOrganization(sub identifier.ID, e *entity, organizationID int32) bool {
+	if e.OrganizationID == organizationID {
+		return true
+	}
+	return sub.Kind == identifier.User && c.members[sub.ID][organizationID]
+}
+
+// memberName names the kind of a user or service account in reasons
+func memberName(kind identifier.Kind) string {
+	if kind == identifier.ServiceAccount {
+		return "service account"
+	}
+	return "user"
+}
+
+// duplicate reports whether l was seen before and remembers it otherwise
+func (c *checker) duplicate(l *line) bool {
+	key := strings.Join([]string{l.PType, l.V0, l.V1, l.V2, l.V3, l.V4, l.V5}, ",")
+	if c.seen[key] {
+		return true
+	}
+	c.seen[key] = true
+	return false
+}
+
+func formatRule(rule *adapter.CasbinRule) string {
+	values := []string{rule.PType, rule.V0, rule.V1, rule.V2, rule.V3, rule.V4, rule.V5}
+	n := len(values)
+	for n > 1 && values[n-1] == "" {
+		n--
+	}
+	return strings.Join(values[:n], ", ")
+}
@@ -0,0 +1,108 @@
+// Package events describes the change events downstream services receive
+// to invalidate the permissions they cache.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Event types
+const (
+	GroupCreated  = "group.created"
+	GroupUpdated  = "group.updated"
+	GroupDeleted  = "group.deleted"
+	GroupRestored = "group.restored"
+
+	GroupMemberAdded       = "group.member_added"
+	GroupMemberRemoved     = "group.member_removed"
+	GroupPermissionAdded   = "group.permission_added"
+	GroupPermissionRemoved = "group.permission_removed"
+
+	UserDeleted = "user.deleted"
+
+	OrganizationMemberAdded   = "organization.member_added"
+	OrganizationMemberRemoved = "organization.member_removed"
+
+	ServiceAccountDeleted           = "service_account.deleted"
+	ServiceAccountGroupAdded        = "service_account.group_added"
+	ServiceAccountGroupRemoved      = "service_account.group_removed"
+	ServiceAccountPermissionAdded   = "service_account.permission_added"
+	ServiceAccountPermissionRemoved = "service_account.permission_removed"
+)
+
+// Types lists every event type
+var Types = []string{
+	GroupCreated,
+	GroupUpdated,
+	GroupDeleted,
+	GroupRestored,
+	GroupMemberAdded,
+	GroupMemberRemoved,
+	GroupPermissionAdded,
+	GroupPermissionRemoved,
+	UserDeleted,
+	OrganizationMemberAdded,
+	OrganizationMemberRemoved,
+	ServiceAccountDeleted,
+	ServiceAccountGroupAdded,
+	ServiceAccountGroupRemoved,
+	ServiceAccountPermissionAdded,
+	ServiceAccountPermissionRemoved,
+}
+
+// IsType reports whether name is a known event type
+func IsType(name string) bool {
+	for _, t := range Types {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Event is a change of an organization's access control
+type Event struct {
+	ID             string          `json:"id"`
+	Type           string          `json:"type"`
+	OrganizationID int32           `json:"organization_id"`
+	OccurredAt     time.Time       `json:"occurred_at"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// New creates an event of the organization with a random id, data is
+// stored as JSON
+func New(eventType string, organizationID int32, data interface{}) (*Event, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		ID:             hex.EncodeToString(id),
+		Type:           eventType,
+		OrganizationID: organizationID,
+		OccurredAt:     time.Now().UTC(),
+		Data:           raw,
+	}, nil
+}
+
+// Publisher delivers events to their subscribers
+type Publisher interface {
+	Publish(ctx context.Context, event *Event) error
+}
+
+// Discard is a Publisher dropping every event
+var Discard Publisher = discard{}
+
+type discard struct{}
+
+func (discard) Publish(ctx context.Context, event *Event) error {
+	return nil
+}
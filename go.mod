@@ -4,12 +4,16 @@ go 1.13
 
 require (
 	github.com/casbin/casbin/v2 v2.1.2
+	github.com/go-asn1-ber/asn1-ber v1.5.1
 	github.com/go-chi/chi v4.0.2+incompatible
 	github.com/go-chi/render v1.0.1
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/go-pg/pg/v9 v9.0.0-beta.15
+	github.com/nats-io/nats.go v1.9.1
 	github.com/oceanicdev/chi-param v1.1.0
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/viper v1.4.0
 	go.uber.org/zap v1.11.0
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/thedevsaddam/govalidator.v1 v1.9.8
 )
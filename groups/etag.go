@@ -0,0 +1,44 @@
+package groups
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// ETag returns the entity tag of the group's current revision
+func ETag(group *models.Group) string {
+	return fmt.Sprintf(`"%d-%d"`, group.ID, group.Revision)
+}
+
+// setETag writes the entity tag of group to the response headers
+func setETag(w http.ResponseWriter, group *models.Group) {
+	w.Header().Set("ETag", ETag(group))
+}
+
+// checkIfMatch compares the If-Match header with the group's entity tag,
+// returning 428 when the header is missing and 412 when no tag matches
+func checkIfMatch(r *http.Request, group *models.Group) int {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return http.StatusPreconditionRequired
+	}
+	etag := ETag(group)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return 0
+		}
+	}
+	return http.StatusPreconditionFailed
+}
+
+func newPreconditionError(status int) *httputil.ErrResponse {
+	if status == http.StatusPreconditionRequired {
+		return httputil.NewAPIError(status, "If-Match header is required")
+	}
+	return httputil.NewAPIError(status, "group has been modified, fetch it again and retry")
+}
@@ -33,6 +33,50 @@ func (g *GroupPayload) validate() url.Values {
 	return e
 }
 
+type GroupUsersPayload struct {
+	Users []int32 `json:"users"`
+}
+
+func (g *GroupUsersPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (g *GroupUsersPayload) validate() url.Values {
+	rules := govalidator.MapData{
+		"users": []string{"required"},
+	}
+	opts := govalidator.Options{
+		Data:  g,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	return e
+}
+
+type GroupPermissionsPayload struct {
+	Permissions []int32 `json:"permissions"`
+}
+
+func (g *GroupPermissionsPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (g *GroupPermissionsPayload) validate() url.Values {
+	rules := govalidator.MapData{
+		"permissions": []string{"required"},
+	}
+	opts := govalidator.Options{
+		Data:  g,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	return e
+}
+
 type userResponse struct {
 	ID    int32  `json:"id"`
 	Email string `json:"email"`
@@ -78,6 +122,8 @@ type GroupResponse struct {
 	Name         string                `json:"name"`
 	CreatedAt    *time.Time            `json:"created_at"`
 	UpdatedAt    *time.Time            `json:"updated_at"`
+	Revision     int32                 `json:"revision"`
+	DeletedAt    *time.Time            `json:"deleted_at,omitempty"`
 	Organization *organizationResponse `json:"organization"`
 	Users        []*userResponse       `json:"users"`
 	Permissions  []*permissionResponse `json:"permissions"`
@@ -97,11 +143,18 @@ func NewGroupResponse(group *models.Group) *GroupResponse {
 		permissions = append(permissions, NewPermissionResponse(permission))
 	}
 
+	var deletedAt *time.Time
+	if !group.DeletedAt.IsZero() {
+		deletedAt = &group.DeletedAt
+	}
+
 	return &GroupResponse{
 		ID:          group.ID,
 		Name:        group.Name,
 		CreatedAt:   &group.CreatedAt,
 		UpdatedAt:   &group.UpdatedAt,
+		Revision:    group.Revision,
+		DeletedAt:   deletedAt,
 		Users:       users,
 		Permissions: permissions,
 		Organization: &organizationResponse{
@@ -117,3 +170,14 @@ func NewGroupListResponse(groups []*models.Group) []render.Renderer {
 	}
 	return list
 }
+
+// groupSortValue returns the value of the sort field of group
+func groupSortValue(group *models.Group, sort string) interface{} {
+	switch sort {
+	case "name":
+		return group.Name
+	case "created_at":
+		return group.CreatedAt
+	}
+	return group.ID
+}
@@ -2,26 +2,37 @@ package groups
 
 import (
 	"context"
+	"errors"
 	"github.com/go-chi/render"
 	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/guard"
 	"github.com/imtanmoy/authz/models"
 	"github.com/imtanmoy/authz/organizations"
 	"github.com/imtanmoy/authz/permissions"
 	"github.com/imtanmoy/authz/users"
+	"github.com/imtanmoy/authz/utils"
 	"github.com/imtanmoy/authz/utils/httputil"
+	"github.com/imtanmoy/authz/utils/pagination"
 	param "github.com/oceanicdev/chi-param"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
 // Handler handles groups http method
 type Handler interface {
-	OrganizationCtx(next http.Handler) http.Handler
 	GroupCtx(next http.Handler) http.Handler
 	List(w http.ResponseWriter, r *http.Request)
 	Create(w http.ResponseWriter, r *http.Request)
 	Get(w http.ResponseWriter, r *http.Request)
 	Delete(w http.ResponseWriter, r *http.Request)
 	Update(w http.ResponseWriter, r *http.Request)
+	Restore(w http.ResponseWriter, r *http.Request)
+
+	AddUsers(w http.ResponseWriter, r *http.Request)
+	RemoveUsers(w http.ResponseWriter, r *http.Request)
+	AddPermissions(w http.ResponseWriter, r *http.Request)
+	RemovePermissions(w http.ResponseWriter, r *http.Request)
 }
 
 type groupHandler struct {
@@ -29,39 +40,31 @@ type groupHandler struct {
 	organizationService organizations.Service
 	userService         users.Service
 	permissionService   permissions.Service
+	guard               guard.Guard
 	db                  *pg.DB
 }
 
 var _ Handler = (*groupHandler)(nil)
 
 // NewGroupHandler construct group handler
-func NewGroupHandler(db *pg.DB) Handler {
+func NewGroupHandler(
+	db *pg.DB,
+	service Service,
+	organizationService organizations.Service,
+	userService users.Service,
+	permissionService permissions.Service,
+	guard guard.Guard,
+) Handler {
 	return &groupHandler{
-		service:             NewGroupService(db),
-		organizationService: organizations.NewOrganizationService(db),
-		userService:         users.NewUserService(db),
-		permissionService:   permissions.NewPermissionService(db),
+		service:             service,
+		organizationService: organizationService,
+		userService:         userService,
+		permissionService:   permissionService,
+		guard:               guard,
 		db:                  db,
 	}
 }
 
-func (g *groupHandler) OrganizationCtx(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		oid, err := param.Int32(r, "oid")
-		if err != nil {
-			_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
-			return
-		}
-		organization, err := g.organizationService.Find(oid)
-		if err != nil {
-			_ = render.Render(w, r, httputil.NewAPIError(404, "organization not found", err))
-			return
-		}
-		ctx := context.WithValue(r.Context(), "organization", organization)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
-}
-
 func (g *groupHandler) GroupCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		id, err := param.Int32(r, "id")
@@ -92,12 +95,33 @@ func (g *groupHandler) List(w http.ResponseWriter, r *http.Request) {
 		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
 		return
 	}
-	groups, err := g.service.List(organization)
+	params, validationErrors := pagination.ParseParams(r, "name", "created_at")
+	deleted := false
+	if value := r.URL.Query().Get("deleted"); value != "" {
+		var err error
+		if deleted, err = strconv.ParseBool(value); err != nil {
+			validationErrors.Add("deleted", "The deleted field must be a boolean")
+		}
+	}
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+	var groups []*models.Group
+	var err error
+	if deleted {
+		groups, err = g.service.ListDeleted(organization, params)
+	} else {
+		groups, err = g.service.List(organization, params)
+	}
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
-	if err := render.RenderList(w, r, NewGroupListResponse(groups)); err != nil {
+	n, page := params.Page(len(groups), func(i int) (int32, interface{}) {
+		return groups[i].ID, groupSortValue(groups[i], params.Sort)
+	})
+	if err := render.Render(w, r, pagination.NewListResponse(NewGroupListResponse(groups[:n]), page)); err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
@@ -118,22 +142,17 @@ func (g *groupHandler) Create(w http.ResponseWriter, r *http.Request) {
 
 	// request validation
 	validationErrors := data.validate()
-	// check if users belongs to the organization
-	userList, _ := g.organizationService.FindUsersByIds(organization, data.Users)
-	if len(userList) != len(data.Users) {
-		validationErrors.Add("users", "invalid user list")
-	}
-	// check if permissions belongs to the organization
-	permissionList, _ := g.organizationService.FindPermissionsByIds(organization, data.Permissions)
-	if len(permissionList) != len(data.Permissions) {
-		validationErrors.Add("permissions", "invalid permission list")
-	}
+	userList, permissionList := g.findMembers(organization, data.Users, data.Permissions, validationErrors)
 
 	if len(validationErrors) > 0 {
 		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
 		return
 	}
 
+	if !g.grantable(w, r, organization, permissionList) {
+		return
+	}
+
 	// check if group with same name already exist
 	existGroup, err := g.service.FindByName(organization, data.Name)
 	if err == nil && existGroup.Name == data.Name {
@@ -144,13 +163,14 @@ func (g *groupHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newGroup, err := g.service.Create(data, organization, userList, permissionList)
+	newGroup, err := g.service.Create(ctx, data, organization, userList, permissionList)
 
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
 
+	setETag(w, newGroup)
 	render.Status(r, http.StatusCreated)
 	_ = render.Render(w, r, NewGroupResponse(newGroup))
 	return
@@ -163,6 +183,7 @@ func (g *groupHandler) Get(w http.ResponseWriter, r *http.Request) {
 		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
 		return
 	}
+	setETag(w, group)
 	if err := render.Render(w, r, NewGroupResponse(group)); err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
@@ -176,7 +197,15 @@ func (g *groupHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
 		return
 	}
-	err := g.service.Delete(group)
+	if status := checkIfMatch(r, group); status != 0 {
+		_ = render.Render(w, r, newPreconditionError(status))
+		return
+	}
+	err := g.service.Delete(ctx, group)
+	if errors.Is(err, ErrRevisionMismatch) {
+		_ = render.Render(w, r, newPreconditionError(http.StatusPreconditionFailed))
+		return
+	}
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
@@ -196,6 +225,10 @@ func (g *groupHandler) Update(w http.ResponseWriter, r *http.Request) {
 		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
 		return
 	}
+	if status := checkIfMatch(r, group); status != 0 {
+		_ = render.Render(w, r, newPreconditionError(status))
+		return
+	}
 
 	data := &GroupPayload{}
 	if err := render.Bind(r, data); err != nil {
@@ -205,21 +238,15 @@ func (g *groupHandler) Update(w http.ResponseWriter, r *http.Request) {
 
 	// request validation
 	validationErrors := data.validate()
-	// check if users belongs to the organization
-	userList, _ := g.organizationService.FindUsersByIds(organization, data.Users)
-	if len(userList) != len(data.Users) {
-		validationErrors.Add("users", "invalid user list")
-	}
-	// check if permissions belongs to the organization
-	permissionList, _ := g.organizationService.FindPermissionsByIds(organization, data.Permissions)
-	if len(permissionList) != len(data.Permissions) {
-		validationErrors.Add("permissions", "invalid permission list")
-	}
+	userList, permissionList := g.findMembers(organization, data.Users, data.Permissions, validationErrors)
 
 	if len(validationErrors) > 0 {
 		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
 		return
 	}
+	if !g.grantable(w, r, organization, changedPermissions(group.Permissions, permissionList)) {
+		return
+	}
 	// check if group with same name already exist
 	existGroup, err := g.service.FindByName(organization, data.Name)
 	if err == nil && existGroup.Name == data.Name && group.Name != data.Name {
@@ -233,8 +260,11 @@ func (g *groupHandler) Update(w http.ResponseWriter, r *http.Request) {
 	//update group data
 	group.Name = data.Name
 
-	err = g.service.Update(group, userList, permissionList)
-
+	err = g.service.Update(ctx, group, userList, permissionList)
+	if errors.Is(err, ErrRevisionMismatch) {
+		_ = render.Render(w, r, newPreconditionError(http.StatusPreconditionFailed))
+		return
+	}
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
@@ -242,7 +272,205 @@ func (g *groupHandler) Update(w http.ResponseWriter, r *http.Request) {
 	//group.Users = userList
 	//group.Permissions = permissionList
 
+	setETag(w, group)
 	render.Status(r, http.StatusCreated)
 	_ = render.Render(w, r, NewGroupResponse(group))
 	return
 }
+
+func (g *groupHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := param.Int32(r, "id")
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+		return
+	}
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	group, err := g.service.FindDeletedByIdAndOrganizationId(id, organization.ID)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(404, "deleted group not found", err))
+		return
+	}
+
+	// check if group with same name was created in the meantime
+	existGroup, err := g.service.FindByName(organization, group.Name)
+	if err == nil && existGroup.Name == group.Name {
+		_ = render.Render(w, r, httputil.NewAPIError(409, "Group with same name already exits"))
+		return
+	}
+
+	err = g.service.Restore(ctx, group)
+	if errors.Is(err, ErrRevisionMismatch) {
+		_ = render.Render(w, r, newPreconditionError(http.StatusPreconditionFailed))
+		return
+	}
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	setETag(w, group)
+	_ = render.Render(w, r, NewGroupResponse(group))
+}
+
+func (g *groupHandler) AddUsers(w http.ResponseWriter, r *http.Request) {
+	g.changeUsers(w, r, g.service.AddUsers)
+}
+
+func (g *groupHandler) RemoveUsers(w http.ResponseWriter, r *http.Request) {
+	g.changeUsers(w, r, g.service.RemoveUsers)
+}
+
+func (g *groupHandler) AddPermissions(w http.ResponseWriter, r *http.Request) {
+	g.changePermissions(w, r, g.service.AddPermissions)
+}
+
+func (g *groupHandler) RemovePermissions(w http.ResponseWriter, r *http.Request) {
+	g.changePermissions(w, r, g.service.RemovePermissions)
+}
+
+// changeUsers applies change to the group with the users listed in the request
+func (g *groupHandler) changeUsers(w http.ResponseWriter, r *http.Request, change func(context.Context, *models.Group, []*models.User) error) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	group, ok := ctx.Value("group").(*models.Group)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+
+	data := &GroupUsersPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+
+	validationErrors := data.validate()
+	userList, _ := g.findMembers(organization, data.Users, nil, validationErrors)
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	if err := change(ctx, group, userList); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	setETag(w, group)
+	_ = render.Render(w, r, NewGroupResponse(group))
+}
+
+// changePermissions applies change to the group with the permissions listed in the request
+func (g *groupHandler) changePermissions(w http.ResponseWriter, r *http.Request, change func(context.Context, *models.Group, []*models.Permission) error) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	group, ok := ctx.Value("group").(*models.Group)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+
+	data := &GroupPermissionsPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+
+	validationErrors := data.validate()
+	_, permissionList := g.findMembers(organization, nil, data.Permissions, validationErrors)
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+	if !g.grantable(w, r, organization, permissionList) {
+		return
+	}
+
+	if err := change(ctx, group, permissionList); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	setETag(w, group)
+	_ = render.Render(w, r, NewGroupResponse(group))
+}
+
+// findMembers loads the users and permissions by id, reporting ids which do
+// not belong to the organization in validationErrors
+func (g *groupHandler) findMembers(
+	organization *models.Organization,
+	userIds []int32,
+	permissionIds []int32,
+	validationErrors url.Values,
+) ([]*models.User, []*models.Permission) {
+	userList := make([]*models.User, 0)
+	if userIds = utils.Unique(userIds); len(userIds) > 0 {
+		// check if users belongs to the organization
+		userList, _ = g.organizationService.FindUsersByIds(organization, userIds)
+		if len(userList) != len(userIds) {
+			validationErrors.Add("users", "invalid user list")
+		}
+	}
+
+	permissionList := make([]*models.Permission, 0)
+	if permissionIds = utils.Unique(permissionIds); len(permissionIds) > 0 {
+		// check if permissions belongs to the organization
+		permissionList, _ = g.organizationService.FindPermissionsByIds(organization, permissionIds)
+		if len(permissionList) != len(permissionIds) {
+			validationErrors.Add("permissions", "invalid permission list")
+		}
+	}
+	return userList, permissionList
+}
+
+// grantable renders a 403 and returns false unless the caller passes the
+// guard of every system permission among permissionList, so callers can not
+// grant or revoke more than they were granted
+func (g *groupHandler) grantable(w http.ResponseWriter, r *http.Request, organization *models.Organization, permissionList []*models.Permission) bool {
+	for _, permission := range permissionList {
+		if permission.Type != permissions.SystemType {
+			continue
+		}
+		allowed, err := g.guard.Granted(r.Context(), organization, permission.Name)
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(err))
+			return false
+		}
+		if !allowed {
+			_ = render.Render(w, r, httputil.NewAPIError(403, "Missing required permission "+permission.Name))
+			return false
+		}
+	}
+	return true
+}
+
+// changedPermissions returns the permissions in only one of before and after
+func changedPermissions(before []*models.Permission, after []*models.Permission) []*models.Permission {
+	count := make(map[int32]int, len(before)+len(after))
+	for _, permission := range before {
+		count[permission.ID]++
+	}
+	for _, permission := range after {
+		count[permission.ID]--
+	}
+	changed := make([]*models.Permission, 0)
+	for _, list := range [][]*models.Permission{before, after} {
+		for _, permission := range list {
+			if count[permission.ID] != 0 {
+				changed = append(changed, permission)
+				count[permission.ID] = 0
+			}
+		}
+	}
+	return changed
+}
@@ -2,19 +2,44 @@ package groups
 
 import (
 	"errors"
+	"time"
+
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/pagination"
 )
 
+// ErrRevisionMismatch is returned when a group was changed since it was read
+var ErrRevisionMismatch = errors.New("group has been modified")
+
+// sortColumns maps the sortable fields of groups to their columns
+var sortColumns = map[string]string{
+	"id":         `"group".id`,
+	"name":       `"group".name`,
+	"created_at": `"group".created_at`,
+}
+
 type Repository interface {
-	List(organizationId int32) ([]*models.Group, error)
+	List(organizationId int32, params *pagination.Params) ([]*models.Group, error)
+	ListDeleted(organizationId int32, params *pagination.Params) ([]*models.Group, error)
 	Create(tx *pg.Tx, group *models.Group) (*models.Group, error)
 	FindByName(organization *models.Organization, name string) (*models.Group, error)
 	Find(ID int32) (*models.Group, error)
 	Exists(ID int32) bool
 	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.Group, error)
-	Delete(group *models.Group) error
+	FindDeletedByIdAndOrganizationId(Id int32, Oid int32) (*models.Group, error)
+	// Delete marks the group deleted with its snapshot if it is still at group.Revision
+	Delete(tx *pg.Tx, group *models.Group) error
+	// Restore clears the deletion mark of the group if it is still at group.Revision
+	Restore(tx *pg.Tx, group *models.Group) error
+	// Purge removes the groups deleted before t for good within tx and returns them
+	Purge(tx *pg.Tx, t time.Time) ([]*models.Group, error)
+	// Update saves the group if it is still at group.Revision and bumps the revision
 	Update(tx *pg.Tx, group *models.Group) error
+	// Refresh reads the current revision of the group, which is bumped by the
+	// authorizer when its memberships change
+	Refresh(group *models.Group) error
 	FindAllByIdIn(ids []int32) []*models.Group
 }
 
@@ -30,10 +55,27 @@ func NewGroupRepository(db *pg.DB) Repository {
 	}
 }
 
-func (g *groupRepository) List(organizationId int32) ([]*models.Group, error) {
+func (g *groupRepository) List(organizationId int32, params *pagination.Params) ([]*models.Group, error) {
 	var groups []*models.Group
-	err := g.db.Model(&groups).Where("organization_id = ?", organizationId).Relation("Organization").Select()
-	return groups, err
+	q := g.db.Model(&groups).Where(`"group".organization_id = ?`, organizationId).Relation("Organization")
+	return groups, g.list(q, params)
+}
+
+func (g *groupRepository) ListDeleted(organizationId int32, params *pagination.Params) ([]*models.Group, error) {
+	var groups []*models.Group
+	q := g.db.Model(&groups).Where(`"group".organization_id = ?`, organizationId).Relation("Organization").Deleted()
+	return groups, g.list(q, params)
+}
+
+// list applies the filters, sorting and paging of params to q and selects it
+func (g *groupRepository) list(q *orm.Query, params *pagination.Params) error {
+	if params.Name != "" {
+		q = q.Where(`"group".name ILIKE ?`, pagination.Prefix(params.Name))
+	}
+	if !params.CreatedAfter.IsZero() {
+		q = q.Where(`"group".created_at > ?`, params.CreatedAfter)
+	}
+	return params.Apply(q, sortColumns[params.Sort], sortColumns["id"]).Select()
 }
 
 func (g *groupRepository) Create(tx *pg.Tx, group *models.Group) (*models.Group, error) {
@@ -61,7 +103,7 @@ func (g *groupRepository) Find(ID int32) (*models.Group, error) {
 
 func (g *groupRepository) Exists(ID int32) bool {
 	var num int32
-	_, err := g.db.Query(pg.Scan(&num), "SELECT id from groups where id = ?", ID)
+	_, err := g.db.Query(pg.Scan(&num), "SELECT id from groups where id = ? and deleted_at is null", ID)
 	if err != nil {
 		panic(err)
 	}
@@ -77,16 +119,89 @@ func (g *groupRepository) FindByIdAndOrganizationId(Id int32, Oid int32) (*model
 	return &group, err
 }
 
+func (g *groupRepository) FindDeletedByIdAndOrganizationId(Id int32, Oid int32) (*models.Group, error) {
+	var group models.Group
+	err := g.db.Model(&group).
+		Where("\"group\".id = ?", Id).
+		Where("\"group\".organization_id = ?", Oid).
+		Relation("Organization").
+		Deleted().
+		Select()
+	return &group, err
+}
+
 func (g *groupRepository) Update(tx *pg.Tx, group *models.Group) error {
-	err := tx.Update(group)
-	return err
+	res, err := tx.Model(group).
+		Set("name = ?name").
+		Set("updated_at = ?updated_at").
+		Set("revision = revision + 1").
+		Where("\"group\".id = ?id").
+		Where("\"group\".revision = ?revision").
+		Returning("revision").
+		Update()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrRevisionMismatch
+	}
+	return nil
 }
 
-func (g *groupRepository) Delete(group *models.Group) error {
-	err := g.db.Delete(group)
+func (g *groupRepository) Refresh(group *models.Group) error {
+	_, err := g.db.QueryOne(
+		pg.Scan(&group.Revision, &group.UpdatedAt),
+		"SELECT revision, updated_at FROM groups WHERE id = ?",
+		group.ID,
+	)
 	return err
 }
 
+func (g *groupRepository) Delete(tx *pg.Tx, group *models.Group) error {
+	res, err := tx.Model(group).
+		Set("deleted_at = now()").
+		Set("snapshot = ?snapshot").
+		Set("revision = revision + 1").
+		Where("\"group\".id = ?id").
+		Where("\"group\".revision = ?revision").
+		Returning("revision, deleted_at").
+		Update()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrRevisionMismatch
+	}
+	return nil
+}
+
+func (g *groupRepository) Restore(tx *pg.Tx, group *models.Group) error {
+	res, err := tx.Model(group).
+		Set("deleted_at = NULL").
+		Set("snapshot = NULL").
+		Set("revision = revision + 1").
+		Where("\"group\".id = ?id").
+		Where("\"group\".revision = ?revision").
+		Returning("revision").
+		Deleted().
+		Update()
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrRevisionMismatch
+	}
+	group.DeletedAt = time.Time{}
+	group.Snapshot = nil
+	return nil
+}
+
+func (g *groupRepository) Purge(tx *pg.Tx, t time.Time) ([]*models.Group, error) {
+	var groups []*models.Group
+	_, err := tx.Query(&groups, "DELETE FROM groups WHERE deleted_at < ? RETURNING *", t)
+	return groups, err
+}
+
 func (g *groupRepository) FindAllByIdIn(ids []int32) []*models.Group {
 	var groups []*models.Group
 	_ = g.db.Model(&groups). // TODO err handling
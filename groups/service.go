@@ -1,69 +1,111 @@
 package groups
 
 import (
+	"context"
+	"time"
+
 	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/audit"
 	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/events"
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/outbox"
 	"github.com/imtanmoy/authz/permissions"
+	"github.com/imtanmoy/authz/serviceaccounts"
 	"github.com/imtanmoy/authz/users"
 	"github.com/imtanmoy/authz/utils"
+	"github.com/imtanmoy/authz/utils/pagination"
 )
 
 type Service interface {
-	List(organization *models.Organization) ([]*models.Group, error)
-	Create(groupPayload *GroupPayload, organization *models.Organization, users []*models.User, permissions []*models.Permission) (*models.Group, error)
+	// List returns a page of groups, fetching one lookahead group past params.Limit
+	List(organization *models.Organization, params *pagination.Params) ([]*models.Group, error)
+	// ListDeleted returns a page of deleted groups with the memberships they had
+	ListDeleted(organization *models.Organization, params *pagination.Params) ([]*models.Group, error)
+	Create(ctx context.Context, groupPayload *GroupPayload, organization *models.Organization, users []*models.User, permissions []*models.Permission) (*models.Group, error)
 	Find(ID int32) (*models.Group, error)
-	Update(group *models.Group, users []*models.User, permissions []*models.Permission) error
-	Delete(group *models.Group) error
+	// Update fails with ErrRevisionMismatch if the group changed since it was read
+	Update(ctx context.Context, group *models.Group, users []*models.User, permissions []*models.Permission) error
+	// Delete marks the group deleted and snapshots its memberships before
+	// revoking them, it fails with ErrRevisionMismatch if the group changed
+	// since it was read
+	Delete(ctx context.Context, group *models.Group) error
+	// Restore brings back a deleted group with the memberships of its snapshot
+	Restore(ctx context.Context, group *models.Group) error
+	// Purge removes the groups deleted longer than retention ago for good
+	Purge(ctx context.Context, retention time.Duration) (int, error)
+	AddUsers(ctx context.Context, group *models.Group, users []*models.User) error
+	RemoveUsers(ctx context.Context, group *models.Group, users []*models.User) error
+	AddPermissions(ctx context.Context, group *models.Group, permissions []*models.Permission) error
+	RemovePermissions(ctx context.Context, group *models.Group, permissions []*models.Permission) error
 	Exists(ID int32) bool
 	FindByName(organization *models.Organization, name string) (*models.Group, error)
 	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.Group, error)
+	FindDeletedByIdAndOrganizationId(Id int32, Oid int32) (*models.Group, error)
 }
 
 type groupService struct {
-	db                   *pg.DB
-	repository           Repository
-	userRepository       users.Repository
-	permissionRepository permissions.Repository
-	authorizerService    authorizer.Service
+	db                       *pg.DB
+	repository               Repository
+	userRepository           users.Repository
+	permissionRepository     permissions.Repository
+	serviceAccountRepository serviceaccounts.Repository
+	authorizerService        authorizer.Service
+	auditService             audit.Service
+	outboxRepository         outbox.Repository
 }
 
 var _ Service = (*groupService)(nil)
 
-func NewGroupService(db *pg.DB) Service {
+func NewGroupService(db *pg.DB, authorizerService authorizer.Service, auditService audit.Service) Service {
 	return &groupService{
-		db:                   db,
-		repository:           NewGroupRepository(db),
-		userRepository:       users.NewUserRepository(db),
-		permissionRepository: permissions.NewPermissionRepository(db),
-		authorizerService:    authorizer.NewAuthorizerService(db),
+		db:                       db,
+		repository:               NewGroupRepository(db),
+		userRepository:           users.NewUserRepository(db),
+		permissionRepository:     permissions.NewPermissionRepository(db),
+		serviceAccountRepository: serviceaccounts.NewServiceAccountRepository(db),
+		authorizerService:        authorizerService,
+		auditService:             auditService,
+		outboxRepository:         outbox.NewOutboxRepository(db),
 	}
 }
 
-func (g *groupService) List(organization *models.Organization) ([]*models.Group, error) {
-	groups, err := g.repository.List(organization.ID)
+func (g *groupService) List(organization *models.Organization, params *pagination.Params) ([]*models.Group, error) {
+	groups, err := g.repository.List(organization.ID, params)
 	if err != nil {
 		return nil, err
 	}
+	ids := make([]int32, 0, len(groups))
 	for _, group := range groups {
-		userList, err := g.authorizerService.GetUsersForGroup(group.ID)
-		if err != nil {
-			return nil, err
-		}
-		group.Users = userList
+		ids = append(ids, group.ID)
 	}
 
+	userMap, err := g.authorizerService.GetUsersForGroups(ids)
+	if err != nil {
+		return nil, err
+	}
+	permissionMap, err := g.authorizerService.GetPermissionsForGroups(ids)
+	if err != nil {
+		return nil, err
+	}
 	for _, group := range groups {
-		permissionList, err := g.authorizerService.GetPermissionsForGroup(group.ID)
-		if err != nil {
-			return nil, err
-		}
-		group.Permissions = permissionList
+		group.Users = userMap[group.ID]
+		group.Permissions = permissionMap[group.ID]
+	}
+	return groups, nil
+}
+
+func (g *groupService) ListDeleted(organization *models.Organization, params *pagination.Params) ([]*models.Group, error) {
+	groups, err := g.repository.ListDeleted(organization.ID, params)
+	if err != nil {
+		return nil, err
 	}
+	g.loadSnapshots(groups...)
 	return groups, nil
 }
 
 func (g *groupService) Create(
+	ctx context.Context,
 	groupPayload *GroupPayload,
 	organization *models.Organization,
 	users []*models.User,
@@ -85,15 +127,35 @@ func (g *groupService) Create(
 		_ = tx.Rollback()
 		return nil, err
 	}
+	err = g.publish(tx, events.GroupCreated, newGroup)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = g.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: organization.ID,
+		Action:         audit.GroupCreated,
+		TargetType:     audit.TargetGroup,
+		TargetID:       newGroup.ID,
+		After:          auditGroup(newGroup),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
 	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
 	// add permissions for group
-	err = g.authorizerService.AddPermissionsForGroup(group.ID, permissions)
+	err = g.authorizerService.AddPermissionsForGroup(ctx, group.ID, permissions)
 	if err != nil {
 		return nil, err
 	}
 
 	// add users for group
-	err = g.authorizerService.AddUsersForGroup(group.ID, users)
+	err = g.authorizerService.AddUsersForGroup(ctx, group.ID, users)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +185,12 @@ func (g *groupService) Find(ID int32) (*models.Group, error) {
 	return group, nil
 }
 
-func (g *groupService) Update(group *models.Group, users []*models.User, permissions []*models.Permission) error {
+func (g *groupService) Update(ctx context.Context, group *models.Group, users []*models.User, permissions []*models.Permission) error {
+	before, err := g.repository.FindByIdAndOrganizationId(group.ID, group.OrganizationID)
+	if err != nil {
+		return err
+	}
+
 	tx, err := g.db.Begin()
 	if err != nil {
 		return err
@@ -134,6 +201,25 @@ func (g *groupService) Update(group *models.Group, users []*models.User, permiss
 		_ = tx.Rollback()
 		return err
 	}
+	if before.Name != group.Name {
+		err = g.publish(tx, events.GroupUpdated, group)
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		err = g.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: group.OrganizationID,
+			Action:         audit.GroupUpdated,
+			TargetType:     audit.TargetGroup,
+			TargetID:       group.ID,
+			Before:         auditGroup(before),
+			After:          auditGroup(group),
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
 	err = tx.Commit()
 	if err != nil {
 		return err
@@ -159,7 +245,7 @@ func (g *groupService) Update(group *models.Group, users []*models.User, permiss
 	willBeAddedPermissions := utils.Minus(newPermissions, oldPermissions)
 	//willBeAddedPermissionModels := g.permissionRepository.FindAllByIdIn(willBeAddedPermissions)
 	willBeAddedPermissionModels := getPermissionModels(willBeAddedPermissions, permissions)
-	err = g.authorizerService.AddPermissionsForGroup(group.ID, willBeAddedPermissionModels)
+	err = g.authorizerService.AddPermissionsForGroup(ctx, group.ID, willBeAddedPermissionModels)
 	if err != nil {
 		return err
 	}
@@ -167,7 +253,7 @@ func (g *groupService) Update(group *models.Group, users []*models.User, permiss
 	//delete permissions with deletePermissions
 	//deletePermissionModels := g.permissionRepository.FindAllByIdIn(deletePermissions)
 	deletePermissionModels := getPermissionModels(deletePermissions, permissionList)
-	err = g.authorizerService.RemovePermissionsForGroup(group.ID, deletePermissionModels)
+	err = g.authorizerService.RemovePermissionsForGroup(ctx, group.ID, deletePermissionModels)
 	if err != nil {
 		return nil
 	}
@@ -194,7 +280,7 @@ func (g *groupService) Update(group *models.Group, users []*models.User, permiss
 	willBeAddedUsers := utils.Minus(newUsers, oldUsers)
 	//willBeAddedUserModels := g.userRepository.FindAllByIdIn(willBeAddedUsers)
 	willBeAddedUserModels := getUserModels(willBeAddedUsers, users)
-	err = g.authorizerService.AddUsersForGroup(group.ID, willBeAddedUserModels)
+	err = g.authorizerService.AddUsersForGroup(ctx, group.ID, willBeAddedUserModels)
 	if err != nil {
 		return err
 	}
@@ -202,22 +288,149 @@ func (g *groupService) Update(group *models.Group, users []*models.User, permiss
 	//delete users from group
 	//deleteUsersModels := g.userRepository.FindAllByIdIn(deleteUsers)
 	deleteUsersModels := getUserModels(deleteUsers, userList)
-	err = g.authorizerService.RemoveUsersForGroup(group.ID, deleteUsersModels)
+	err = g.authorizerService.RemoveUsersForGroup(ctx, group.ID, deleteUsersModels)
 	if err != nil {
 		return err
 	}
 
 	group.Users = users
 
-	return nil
+	return g.repository.Refresh(group)
 }
 
-func (g *groupService) Delete(group *models.Group) error {
-	err := g.authorizerService.DeleteGroup(group.ID) // it will delete all permissions and users
+func (g *groupService) Delete(ctx context.Context, group *models.Group) error {
+	serviceAccountIds, err := g.authorizerService.GetServiceAccountIdsForGroup(group.ID)
 	if err != nil {
 		return err
 	}
-	return g.repository.Delete(group)
+	snapshot := &models.GroupSnapshot{
+		Users:           make([]int32, 0, len(group.Users)),
+		ServiceAccounts: serviceAccountIds,
+		Permissions:     make([]int32, 0, len(group.Permissions)),
+	}
+	for _, user := range group.Users {
+		snapshot.Users = append(snapshot.Users, user.ID)
+	}
+	for _, permission := range group.Permissions {
+		snapshot.Permissions = append(snapshot.Permissions, permission.ID)
+	}
+	group.Snapshot = snapshot
+
+	// the members and grants are removed in the transaction marking the
+	// group deleted, so a deleted group never keeps granting its permissions
+	return g.authorizerService.DeleteGroup(ctx, group.ID, func(tx *pg.Tx) error {
+		if err := g.repository.Delete(tx, group); err != nil {
+			return err
+		}
+		if err := g.publish(tx, events.GroupDeleted, group); err != nil {
+			return err
+		}
+		return g.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: group.OrganizationID,
+			Action:         audit.GroupDeleted,
+			TargetType:     audit.TargetGroup,
+			TargetID:       group.ID,
+			Before:         auditGroup(group),
+		})
+	})
+}
+
+func (g *groupService) Restore(ctx context.Context, group *models.Group) error {
+	g.loadSnapshots(group)
+	err := g.authorizerService.RestoreGroup(ctx, group, func(tx *pg.Tx) error {
+		if err := g.repository.Restore(tx, group); err != nil {
+			return err
+		}
+		if err := g.publish(tx, events.GroupRestored, group); err != nil {
+			return err
+		}
+		return g.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: group.OrganizationID,
+			Action:         audit.GroupRestored,
+			TargetType:     audit.TargetGroup,
+			TargetID:       group.ID,
+			After:          auditGroup(group),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return g.repository.Refresh(group)
+}
+
+func (g *groupService) Purge(ctx context.Context, retention time.Duration) (int, error) {
+	var groups []*models.Group
+	err := g.db.RunInTransaction(func(tx *pg.Tx) error {
+		var err error
+		if groups, err = g.repository.Purge(tx, time.Now().Add(-retention)); err != nil {
+			return err
+		}
+		for _, group := range groups {
+			err = g.auditService.Record(ctx, tx, &audit.Change{
+				OrganizationID: group.OrganizationID,
+				Action:         audit.GroupPurged,
+				TargetType:     audit.TargetGroup,
+				TargetID:       group.ID,
+				Before:         auditGroup(group),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(groups), nil
+}
+
+func (g *groupService) AddUsers(ctx context.Context, group *models.Group, users []*models.User) error {
+	err := g.authorizerService.AddUsersForGroup(ctx, group.ID, users)
+	if err != nil {
+		return err
+	}
+	group.Users, err = g.authorizerService.GetUsersForGroup(group.ID)
+	if err != nil {
+		return err
+	}
+	return g.repository.Refresh(group)
+}
+
+func (g *groupService) RemoveUsers(ctx context.Context, group *models.Group, users []*models.User) error {
+	err := g.authorizerService.RemoveUsersForGroup(ctx, group.ID, users)
+	if err != nil {
+		return err
+	}
+	group.Users, err = g.authorizerService.GetUsersForGroup(group.ID)
+	if err != nil {
+		return err
+	}
+	return g.repository.Refresh(group)
+}
+
+func (g *groupService) AddPermissions(ctx context.Context, group *models.Group, permissions []*models.Permission) error {
+	err := g.authorizerService.AddPermissionsForGroup(ctx, group.ID, permissions)
+	if err != nil {
+		return err
+	}
+	group.Permissions, err = g.authorizerService.GetPermissionsForGroup(group.ID)
+	if err != nil {
+		return err
+	}
+	return g.repository.Refresh(group)
+}
+
+func (g *groupService) RemovePermissions(ctx context.Context, group *models.Group, permissions []*models.Permission) error {
+	err := g.authorizerService.RemovePermissionsForGroup(ctx, group.ID, permissions)
+	if err != nil {
+		return err
+	}
+	group.Permissions, err = g.authorizerService.GetPermissionsForGroup(group.ID)
+	if err != nil {
+		return err
+	}
+	return g.repository.Refresh(group)
 }
 
 func (g *groupService) Exists(ID int32) bool {
@@ -250,6 +463,72 @@ func (g *groupService) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.G
 	return group, nil
 }
 
+func (g *groupService) FindDeletedByIdAndOrganizationId(Id int32, Oid int32) (*models.Group, error) {
+	group, err := g.repository.FindDeletedByIdAndOrganizationId(Id, Oid)
+	if err != nil {
+		return nil, err
+	}
+	g.loadSnapshots(group)
+	return group, nil
+}
+
+// loadSnapshots sets the users and permissions of deleted groups from their
+// snapshots, skipping the ones which do not exist anymore
+func (g *groupService) loadSnapshots(groups ...*models.Group) {
+	var pIds []int32
+	for _, group := range groups {
+		if group.Snapshot != nil {
+			pIds = append(pIds, group.Snapshot.Permissions...)
+		}
+	}
+	permissionList := make([]*models.Permission, 0)
+	if pIds = utils.Unique(pIds); len(pIds) > 0 {
+		permissionList = g.permissionRepository.FindAllByIdIn(pIds)
+	}
+
+	for _, group := range groups {
+		group.Users = make([]*models.User, 0)
+		group.ServiceAccounts = make([]*models.ServiceAccount, 0)
+		group.Permissions = make([]*models.Permission, 0)
+		if group.Snapshot == nil {
+			continue
+		}
+		// users which left the organization since are not restored
+		if users, err := g.userRepository.FindAllByIdInOrganization(group.Snapshot.Users, group.OrganizationID); err == nil {
+			group.Users = users
+		}
+		// nor are deleted service accounts
+		if serviceAccounts, err := g.serviceAccountRepository.FindAllByIdInOrganization(group.Snapshot.ServiceAccounts, group.OrganizationID); err == nil {
+			group.ServiceAccounts = serviceAccounts
+		}
+		for _, permission := range permissionList {
+			if permission.OrganizationID == group.OrganizationID && utils.Exists(group.Snapshot.Permissions, permission.ID) {
+				group.Permissions = append(group.Permissions, permission)
+			}
+		}
+	}
+}
+
+// publish adds an event of the group to the outbox within tx, membership
+// events are added by the authorizer service
+func (g *groupService) publish(tx *pg.Tx, eventType string, group *models.Group) error {
+	event, err := events.New(eventType, group.OrganizationID, map[string]interface{}{"group_id": group.ID, "name": group.Name})
+	if err != nil {
+		return err
+	}
+	return g.outboxRepository.Add(tx, event)
+}
+
+// auditGroup returns the audited state of the group, memberships are audited
+// by the authorizer service
+func auditGroup(group *models.Group) map[string]interface{} {
+	state := map[string]interface{}{"name": group.Name}
+	if group.Snapshot != nil {
+		state["snapshot"] = group.Snapshot
+	}
+	return state
+}
+
 func getPermissionModels(ids []int32, permissions []*models.Permission) []*models.Permission {
 	list := make([]*models.Permission, 0)
 	for _, permission := range permissions {
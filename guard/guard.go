@@ -0,0 +1,89 @@
+// Package guard protects the management api with authz's own system
+// permissions, so organization admins are managed through groups.
+package guard
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/permissions"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// Guard checks the calling user against system permissions
+type Guard interface {
+	// Require rejects user callers which were not granted the system
+	// permission name within the request organization. Api keys and
+	// superadmins are organization level credentials and always pass, other
+	// callers are rejected.
+	Require(name string) func(next http.Handler) http.Handler
+	// Granted reports whether the caller of ctx passes Require(name) within
+	// the organization
+	Granted(ctx context.Context, organization *models.Organization, name string) (bool, error)
+}
+
+type guard struct {
+	permissionService permissions.Service
+	authorizerService authorizer.Service
+}
+
+var _ Guard = (*guard)(nil)
+
+// NewGuard construct guard
+func NewGuard(permissionService permissions.Service, authorizerService authorizer.Service) Guard {
+	return &guard{
+		permissionService: permissionService,
+		authorizerService: authorizerService,
+	}
+}
+
+func (g *guard) Require(name string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			caller, ok := auth.FromContext(ctx)
+			if !ok {
+				_ = render.Render(w, r, httputil.NewAPIError(403, "Forbidden"))
+				return
+			}
+			organization, ok := ctx.Value("organization").(*models.Organization)
+			if !ok {
+				organization = &models.Organization{ID: caller.OrganizationID}
+			}
+			allowed, err := g.Granted(ctx, organization, name)
+			if err != nil {
+				_ = render.Render(w, r, httputil.NewAPIError(err))
+				return
+			}
+			if !allowed {
+				_ = render.Render(w, r, httputil.NewAPIError(403, "Missing required permission "+name))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func (g *guard) Granted(ctx context.Context, organization *models.Organization, name string) (bool, error) {
+	caller, ok := auth.FromContext(ctx)
+	if !ok {
+		return false, nil
+	}
+	if caller.Kind == auth.KindAPIKey || caller.IsSuperAdmin() {
+		return true, nil
+	}
+	if caller.Kind != auth.KindUser || caller.UserID == 0 {
+		return false, nil
+	}
+	permission, err := g.permissionService.FindByName(organization, name)
+	if err != nil {
+		// organizations without the system permission grant it to nobody
+		return false, nil
+	}
+	return g.authorizerService.HasPermission(caller.UserID, permission)
+}
@@ -0,0 +1,138 @@
+// Package identifier formats and parses the `kind::id` identifiers of the
+// subjects and objects of policy lines and callers, such as `user::1` or
+// `permission::3`.
+package identifier
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// separator separates the kind of an identifier from its id
+const separator = "::"
+
+// Kind is the kind of entity an identifier refers to
+type Kind string
+
+// Identifier kinds
+const (
+	User           Kind = "user"
+	Group          Kind = "group"
+	Permission     Kind = "permission"
+	ServiceAccount Kind = "service"
+	APIKey         Kind = "api_key"
+)
+
+// kinds holds the known kinds, identifiers of other kinds are rejected
+var kinds = map[Kind]bool{
+	User:           true,
+	Group:          true,
+	Permission:     true,
+	ServiceAccount: true,
+	APIKey:         true,
+}
+
+var (
+	ErrMalformed   = errors.New("identifier is not of the form kind::id")
+	ErrUnknownKind = errors.New("identifier has an unknown kind")
+	ErrInvalidID   = errors.New("identifier has an invalid id")
+	ErrKind        = errors.New("identifier has an unexpected kind")
+)
+
+// Prefix returns the kind:: prefix of the identifiers of the kind
+func (k Kind) Prefix() string {
+	return string(k) + separator
+}
+
+// ID identifies an entity of a kind by its internal id
+type ID struct {
+	Kind Kind
+	ID   int32
+}
+
+// New returns the identifier of the entity of kind with id
+func New(kind Kind, id int32) ID {
+	return ID{Kind: kind, ID: id}
+}
+
+// UserID returns the identifier of the user
+func UserID(id int32) ID {
+	return New(User, id)
+}
+
+// GroupID returns the identifier of the group
+func GroupID(id int32) ID {
+	return New(Group, id)
+}
+
+// PermissionID returns the identifier of the permission
+func PermissionID(id int32) ID {
+	return New(Permission, id)
+}
+
+// ServiceAccountID returns the identifier of the service account
+func ServiceAccountID(id int32) ID {
+	return New(ServiceAccount, id)
+}
+
+// APIKeyID returns the identifier of the api key
+func APIKeyID(id int32) ID {
+	return New(APIKey, id)
+}
+
+// String formats the identifier as kind::id
+func (i ID) String() string {
+	return i.Kind.Prefix() + strconv.FormatInt(int64(i.ID), 10)
+}
+
+// Validate checks that the identifier has a known kind and a positive id
+func (i ID) Validate() error {
+	if !kinds[i.Kind] {
+		return fmt.Errorf("%w : %q", ErrUnknownKind, i.Kind)
+	}
+	if i.ID <= 0 {
+		return fmt.Errorf("%w : %d", ErrInvalidID, i.ID)
+	}
+	return nil
+}
+
+// Format formats the identifier of the entity of kind with id, it fails
+// for unknown kinds and ids which are not positive
+func Format(kind Kind, id int32) (string, error) {
+	i := New(kind, id)
+	if err := i.Validate(); err != nil {
+		return "", err
+	}
+	return i.String(), nil
+}
+
+// Parse parses a kind::id identifier of a known kind with a positive id
+func Parse(s string) (ID, error) {
+	parts := strings.SplitN(s, separator, 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ID{}, fmt.Errorf("%w : %q", ErrMalformed, s)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 32)
+	if err != nil {
+		return ID{}, fmt.Errorf("%w : %q", ErrInvalidID, s)
+	}
+	i := New(Kind(parts[0]), int32(id))
+	if err := i.Validate(); err != nil {
+		return ID{}, fmt.Errorf("%q : %w", s, err)
+	}
+	return i, nil
+}
+
+// ParseKind parses an identifier which must be of kind and returns its id
+func ParseKind(s string, kind Kind) (int32, error) {
+	i, err := Parse(s)
+	if err != nil {
+		return 0, err
+	}
+	if i.Kind != kind {
+		return 0, fmt.Errorf("%w : %q is not a %s", ErrKind, s, kind)
+	}
+	return i.ID, nil
+}
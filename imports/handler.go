@@ -0,0 +1,133 @@
+package imports
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9"
+	param "github.com/oceanicdev/chi-param"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/users"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// maxFileSize bounds the size of an import request in bytes
+const maxFileSize = 10 << 20
+
+// Handler handles imports http method
+type Handler interface {
+	Import(w http.ResponseWriter, r *http.Request)
+	Job(w http.ResponseWriter, r *http.Request)
+}
+
+type importHandler struct {
+	db      *pg.DB
+	service Service
+}
+
+var _ Handler = (*importHandler)(nil)
+
+// NewImportHandler construct import handler
+func NewImportHandler(db *pg.DB, service Service) Handler {
+	return &importHandler{
+		db:      db,
+		service: service,
+	}
+}
+
+// Import creates the users of the CSV file of the multipart file field. No
+// user is created when a row is invalid, the errors of every row are
+// reported. With async=true the import runs as a job whose status is served
+// at the Location of the 202 response.
+func (i *importHandler) Import(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	async := false
+	if value := r.URL.Query().Get("async"); value != "" {
+		var err error
+		if async, err = strconv.ParseBool(value); err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", url.Values{
+			"file": {fmt.Sprintf("A CSV file of at most %d bytes is required : %s", maxFileSize, err)},
+		}))
+		return
+	}
+	defer file.Close()
+
+	rows, rowErrors, err := users.ParseImport(file)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", url.Values{"file": {err.Error()}}))
+		return
+	}
+	if len(rowErrors) == 0 {
+		rowErrors, err = i.service.Validate(organization, rows)
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(err))
+			return
+		}
+	}
+	if len(rowErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", url.Values(rowErrors)))
+		return
+	}
+
+	if async {
+		job, err := i.service.Start(ctx, organization, rows)
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(err))
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("%s/%d", strings.TrimSuffix(r.URL.Path, "/"), job.ID))
+		render.Status(r, http.StatusAccepted)
+		_ = render.Render(w, r, NewJobResponse(job))
+		return
+	}
+
+	imported, err := i.service.Import(ctx, organization, rows)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	_ = render.Render(w, r, &ImportResponse{Total: len(rows), Imported: imported})
+}
+
+// Job returns the status of the import job identified by the id url parameter
+func (i *importHandler) Job(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	id, err := param.Int32(r, "id")
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+		return
+	}
+	job, err := i.service.FindJobByIdAndOrganizationId(id, organization.ID)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(404, "import job not found", err))
+		return
+	}
+	if err := render.Render(w, r, NewJobResponse(job)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
@@ -0,0 +1,46 @@
+package imports
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+type ImportResponse struct {
+	Total    int `json:"total"`
+	Imported int `json:"imported"`
+}
+
+func (i *ImportResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+type JobResponse struct {
+	ID         int32      `json:"id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Imported   int        `json:"imported"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+}
+
+func (j *JobResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewJobResponse(job *models.ImportJob) *JobResponse {
+	resp := &JobResponse{
+		ID:        job.ID,
+		Status:    job.Status,
+		Total:     job.Total,
+		Imported:  job.Imported,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+	}
+	if !job.FinishedAt.IsZero() {
+		resp.FinishedAt = &job.FinishedAt
+	}
+	return resp
+}
@@ -0,0 +1,56 @@
+package imports
+
+import (
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+type Repository interface {
+	Create(job *models.ImportJob) (*models.ImportJob, error)
+	Update(job *models.ImportJob) error
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.ImportJob, error)
+	// GroupIds returns the ids of the groups of the organization which are
+	// not deleted
+	GroupIds(organizationId int32) ([]int32, error)
+}
+
+type importRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*importRepository)(nil)
+
+func NewImportRepository(db *pg.DB) Repository {
+	return &importRepository{
+		db,
+	}
+}
+
+func (i *importRepository) Create(job *models.ImportJob) (*models.ImportJob, error) {
+	_, err := i.db.Model(job).Returning("*").Insert()
+	return job, err
+}
+
+func (i *importRepository) Update(job *models.ImportJob) error {
+	_, err := i.db.Model(job).WherePK().Update()
+	return err
+}
+
+func (i *importRepository) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.ImportJob, error) {
+	var job models.ImportJob
+	err := i.db.Model(&job).
+		Where("import_job.id = ?", Id).
+		Where("import_job.organization_id = ?", Oid).
+		Select()
+	return &job, err
+}
+
+func (i *importRepository) GroupIds(organizationId int32) ([]int32, error) {
+	ids := make([]int32, 0)
+	err := i.db.Model((*models.Group)(nil)).
+		Column("group.id").
+		Where(`"group".organization_id = ?`, organizationId).
+		Select(&ids)
+	return ids, err
+}
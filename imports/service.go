@@ -0,0 +1,196 @@
+// Package imports creates the users of an organization and their group
+// memberships from CSV files, in the request or as a background job.
+package imports
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/logger"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/users"
+	"github.com/imtanmoy/authz/utils"
+)
+
+// Job statuses
+const (
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// batchSize is the number of users created in one transaction
+const batchSize = 500
+
+type Service interface {
+	// Validate reports the rows whose id is used by an existing user or
+	// whose groups are not groups of the organization
+	Validate(organization *models.Organization, rows []*users.ImportRow) (users.RowErrors, error)
+	// Import creates the users of the rows in batches and adds each batch to
+	// its groups, it returns the number of users created before an error
+	Import(ctx context.Context, organization *models.Organization, rows []*users.ImportRow) (int, error)
+	// Start runs Import in the background and returns its job, the job is
+	// updated after every batch. Changes are recorded as changes of ctx's
+	// caller.
+	Start(ctx context.Context, organization *models.Organization, rows []*users.ImportRow) (*models.ImportJob, error)
+	FindJobByIdAndOrganizationId(Id int32, Oid int32) (*models.ImportJob, error)
+	// Wait blocks until the started jobs are finished
+	Wait()
+}
+
+type importService struct {
+	db                *pg.DB
+	repository        Repository
+	userService       users.Service
+	authorizerService authorizer.Service
+	log               logger.Logger
+	running           sync.WaitGroup
+}
+
+var _ Service = (*importService)(nil)
+
+// NewImportService creates the import service, log reports the jobs which
+// could not be updated and may be nil
+func NewImportService(db *pg.DB, userService users.Service, authorizerService authorizer.Service, log logger.Logger) Service {
+	return &importService{
+		db:                db,
+		repository:        NewImportRepository(db),
+		userService:       userService,
+		authorizerService: authorizerService,
+		log:               log,
+	}
+}
+
+func (i *importService) Validate(organization *models.Organization, rows []*users.ImportRow) (users.RowErrors, error) {
+	rowErrors := make(users.RowErrors)
+	ids := make([]int32, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+	existing, err := i.userService.ExistingIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	groupIds, err := i.repository.GroupIds(organization.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if utils.Exists(existing, row.ID) {
+			rowErrors.Add(row.Row, "User with same id already exits")
+		}
+		for _, id := range row.Groups {
+			if !utils.Exists(groupIds, id) {
+				rowErrors.Add(row.Row, fmt.Sprintf("Group %d does not exist", id))
+			}
+		}
+	}
+	return rowErrors, nil
+}
+
+func (i *importService) Import(ctx context.Context, organization *models.Organization, rows []*users.ImportRow) (int, error) {
+	return i.run(ctx, organization, rows, func(imported int) {})
+}
+
+// run imports the rows and calls progress with the number of users created
+// after every batch
+func (i *importService) run(ctx context.Context, organization *models.Organization, rows []*users.ImportRow, progress func(imported int)) (int, error) {
+	imported := 0
+	for start := 0; start < len(rows); start += batchSize {
+		end := start + batchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := make([]*models.User, 0, end-start)
+		memberships := make(map[int32][]*models.User)
+		for _, row := range rows[start:end] {
+			user := &models.User{
+				ID:             row.ID,
+				Email:          row.Email,
+				OrganizationID: organization.ID,
+				Organization:   organization,
+			}
+			batch = append(batch, user)
+			for _, groupId := range utils.Unique(row.Groups) {
+				memberships[groupId] = append(memberships[groupId], user)
+			}
+		}
+		if err := i.userService.CreateAll(ctx, batch); err != nil {
+			return imported, err
+		}
+		imported += len(batch)
+		if len(memberships) > 0 {
+			if err := i.authorizerService.UpdateUsersForGroups(ctx, memberships, nil); err != nil {
+				return imported, err
+			}
+		}
+		progress(imported)
+	}
+	return imported, nil
+}
+
+func (i *importService) Start(ctx context.Context, organization *models.Organization, rows []*users.ImportRow) (*models.ImportJob, error) {
+	job, err := i.repository.Create(&models.ImportJob{
+		OrganizationID: organization.ID,
+		Status:         StatusRunning,
+		Total:          len(rows),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx = detach(ctx)
+	state := *job
+	i.running.Add(1)
+	go func() {
+		defer i.running.Done()
+		imported, err := i.run(ctx, organization, rows, func(imported int) {
+			state.Imported = imported
+			i.update(&state)
+		})
+		state.Imported = imported
+		state.Status = StatusSucceeded
+		if err != nil {
+			state.Status = StatusFailed
+			state.Error = err.Error()
+		}
+		state.FinishedAt = time.Now()
+		i.update(&state)
+	}()
+	return job, nil
+}
+
+// update saves the progress of the job, a failed update only delays the
+// progress seen by clients so it is logged
+func (i *importService) update(job *models.ImportJob) {
+	if err := i.repository.Update(job); err != nil && i.log != nil {
+		i.log.Errorf("import job %d could not be updated : %s", job.ID, err)
+	}
+}
+
+func (i *importService) FindJobByIdAndOrganizationId(Id int32, Oid int32) (*models.ImportJob, error) {
+	return i.repository.FindByIdAndOrganizationId(Id, Oid)
+}
+
+func (i *importService) Wait() {
+	i.running.Wait()
+}
+
+// detach returns a context keeping the caller and request id of ctx which is
+// not canceled with ctx
+func detach(ctx context.Context) context.Context {
+	detached := context.Background()
+	if caller, ok := auth.FromContext(ctx); ok {
+		detached = auth.NewContext(detached, caller)
+	}
+	if id := middleware.GetReqID(ctx); id != "" {
+		detached = context.WithValue(detached, middleware.RequestIDKey, id)
+	}
+	return detached
+}
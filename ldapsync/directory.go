@@ -0,0 +1,172 @@
+// Package ldapsync drives the membership of authz groups from the groups of
+// an LDAP or Active Directory server.
+package ldapsync
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Entry is a group of the directory
+type Entry struct {
+	DN   string
+	Name string
+	// Members are the emails of the members of the group, members without
+	// an email such as nested groups are left out
+	Members []string
+}
+
+// Directory lists the groups of a directory
+type Directory interface {
+	Groups(ctx context.Context) ([]*Entry, error)
+}
+
+// Options configures the connection to the LDAP server and the attributes
+// read from its entries
+type Options struct {
+	// URL of the server, e.g. ldaps://ldap.example.com:636
+	URL          string
+	BindDN       string
+	BindPassword string
+	// StartTLS upgrades ldap:// connections to TLS
+	StartTLS bool
+	// BaseDN is where groups are searched
+	BaseDN string
+	// GroupFilter selects the groups, defaults to (objectClass=groupOfNames)
+	GroupFilter string
+	// NameAttribute holds the name of groups, defaults to cn
+	NameAttribute string
+	// MemberAttribute holds the DNs of the members of groups, defaults to member
+	MemberAttribute string
+	// MailAttribute holds the email of members, defaults to mail
+	MailAttribute string
+}
+
+// defaults of Options
+const (
+	defaultGroupFilter     = "(objectClass=groupOfNames)"
+	defaultNameAttribute   = "cn"
+	defaultMemberAttribute = "member"
+	defaultMailAttribute   = "mail"
+)
+
+type ldapDirectory struct {
+	opts Options
+}
+
+var _ Directory = (*ldapDirectory)(nil)
+
+// NewLDAPDirectory creates a directory reading the groups of the LDAP server,
+// every call of Groups opens its own connection
+func NewLDAPDirectory(opts Options) (Directory, error) {
+	if opts.URL == "" {
+		return nil, errors.New("ldap url is required")
+	}
+	if opts.BaseDN == "" {
+		return nil, errors.New("ldap base dn is required")
+	}
+	if opts.GroupFilter == "" {
+		opts.GroupFilter = defaultGroupFilter
+	}
+	if opts.NameAttribute == "" {
+		opts.NameAttribute = defaultNameAttribute
+	}
+	if opts.MemberAttribute == "" {
+		opts.MemberAttribute = defaultMemberAttribute
+	}
+	if opts.MailAttribute == "" {
+		opts.MailAttribute = defaultMailAttribute
+	}
+	return &ldapDirectory{opts: opts}, nil
+}
+
+func (d *ldapDirectory) Groups(ctx context.Context) ([]*Entry, error) {
+	conn, err := d.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+		d.opts.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		d.opts.GroupFilter, []string{d.opts.NameAttribute, d.opts.MemberAttribute}, nil,
+	), 500)
+	if err != nil {
+		return nil, fmt.Errorf("searching groups: %w", err)
+	}
+
+	// members are usually shared by several groups, their emails are only
+	// looked up once
+	emails := make(map[string]string)
+	entries := make([]*Entry, 0, len(result.Entries))
+	for _, group := range result.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entry := &Entry{DN: group.DN, Name: group.GetAttributeValue(d.opts.NameAttribute)}
+		for _, dn := range group.GetAttributeValues(d.opts.MemberAttribute) {
+			key := strings.ToLower(dn)
+			email, ok := emails[key]
+			if !ok {
+				email, err = d.email(conn, dn)
+				if err != nil {
+					return nil, err
+				}
+				emails[key] = email
+			}
+			if email != "" {
+				entry.Members = append(entry.Members, email)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (d *ldapDirectory) connect() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", d.opts.URL, err)
+	}
+	if d.opts.StartTLS {
+		host := d.opts.URL[strings.Index(d.opts.URL, "://")+3:]
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starting tls: %w", err)
+		}
+	}
+	if d.opts.BindDN != "" {
+		if err := conn.Bind(d.opts.BindDN, d.opts.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("binding as %s: %w", d.opts.BindDN, err)
+		}
+	}
+	return conn, nil
+}
+
+// email returns the email of the entry with the dn, an empty string when the
+// entry has no email or does not exist
+func (d *ldapDirectory) email(conn *ldap.Conn, dn string) (string, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, 0, false,
+		"(objectClass=*)", []string{d.opts.MailAttribute}, nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading member %s: %w", dn, err)
+	}
+	if len(result.Entries) == 0 {
+		return "", nil
+	}
+	return result.Entries[0].GetAttributeValue(d.opts.MailAttribute), nil
+}
@@ -0,0 +1,43 @@
+package ldapsync
+
+import (
+	"strings"
+
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+type Repository interface {
+	// FindUsersByEmails returns the users of the organization with the
+	// emails, ignoring their case
+	FindUsersByEmails(organizationId int32, emails []string) ([]*models.User, error)
+}
+
+type ldapsyncRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*ldapsyncRepository)(nil)
+
+func NewLdapsyncRepository(db *pg.DB) Repository {
+	return &ldapsyncRepository{
+		db,
+	}
+}
+
+func (l *ldapsyncRepository) FindUsersByEmails(organizationId int32, emails []string) ([]*models.User, error) {
+	var users []*models.User
+	if len(emails) == 0 {
+		return users, nil
+	}
+	lower := make([]string, len(emails))
+	for i, email := range emails {
+		lower[i] = strings.ToLower(email)
+	}
+	err := l.db.Model(&users).
+		Where(`"user".organization_id = ?`, organizationId).
+		Where(`lower("user".email) IN (?)`, pg.In(lower)).
+		Select()
+	return users, err
+}
@@ -0,0 +1,199 @@
+package ldapsync
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// testServer is an in-process LDAP server answering the binds and searches
+// of ldapDirectory from entries held in memory
+type testServer struct {
+	listener net.Listener
+	bindDN   string
+	password string
+
+	mu      sync.Mutex
+	entries map[string]map[string][]string
+}
+
+// newTestServer starts a server accepting the bind dn with password, it is
+// stopped when the test ends
+func newTestServer(t *testing.T, bindDN, password string) *testServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{
+		listener: listener,
+		bindDN:   bindDN,
+		password: password,
+		entries:  make(map[string]map[string][]string),
+	}
+	go s.serve()
+	t.Cleanup(func() { _ = listener.Close() })
+	return s
+}
+
+// URL returns the ldap:// url of the server
+func (s *testServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Put adds or replaces the entry with dn
+func (s *testServer) Put(dn string, attributes map[string][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[strings.ToLower(dn)] = attributes
+}
+
+// Remove deletes the entry with dn
+func (s *testServer) Remove(dn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, strings.ToLower(dn))
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+		var responses []*ber.Packet
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			responses = []*ber.Packet{s.bind(request)}
+		case ldap.ApplicationSearchRequest:
+			responses = s.search(request)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			continue
+		}
+		for _, response := range responses {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+			envelope.AppendChild(response)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *testServer) bind(request *ber.Packet) *ber.Packet {
+	dn := request.Children[1].Data.String()
+	password := request.Children[2].Data.String()
+	if dn != s.bindDN || password != s.password {
+		return result(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials)
+	}
+	return result(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess)
+}
+
+// search supports base and subtree searches with an equality or presence
+// filter, which is all ldapDirectory sends
+func (s *testServer) search(request *ber.Packet) []*ber.Packet {
+	base := strings.ToLower(request.Children[0].Data.String())
+	scope, _ := request.Children[1].Value.(int64)
+	filter, err := ldap.DecompileFilter(request.Children[6])
+	if err != nil {
+		return []*ber.Packet{result(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError)}
+	}
+	attribute, value := parseFilter(filter)
+	var requested []string
+	for _, child := range request.Children[7].Children {
+		requested = append(requested, child.Data.String())
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if scope == ldap.ScopeBaseObject {
+		if _, ok := s.entries[base]; !ok {
+			return []*ber.Packet{result(ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject)}
+		}
+	}
+	var responses []*ber.Packet
+	for dn, attributes := range s.entries {
+		if scope == ldap.ScopeBaseObject && dn != base || !strings.HasSuffix(dn, base) {
+			continue
+		}
+		if !matches(attributes, attribute, value) {
+			continue
+		}
+		responses = append(responses, entry(dn, attributes, requested))
+	}
+	return append(responses, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+// parseFilter splits "(attribute=value)"
+func parseFilter(filter string) (string, string) {
+	filter = strings.TrimSuffix(strings.TrimPrefix(filter, "("), ")")
+	i := strings.Index(filter, "=")
+	return filter[:i], filter[i+1:]
+}
+
+func matches(attributes map[string][]string, attribute, value string) bool {
+	if strings.EqualFold(attribute, "objectClass") && value == "*" {
+		return true
+	}
+	for name, values := range attributes {
+		if !strings.EqualFold(name, attribute) {
+			continue
+		}
+		for _, v := range values {
+			if value == "*" || strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func entry(dn string, attributes map[string][]string, requested []string) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, name := range requested {
+		values, ok := attributes[name]
+		if !ok {
+			continue
+		}
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		list.AppendChild(attribute)
+	}
+	response.AppendChild(list)
+	return response
+}
+
+func result(tag ber.Tag, code uint16) *ber.Packet {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return response
+}
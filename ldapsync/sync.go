@@ -0,0 +1,271 @@
+package ldapsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/groups"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils"
+)
+
+// Actor is the subject recorded in the audit log for changes of a sync
+// which is not run on behalf of a caller
+const Actor = "ldap_sync"
+
+// Mapping maps directory groups to an authz group, either the directory group
+// with DN or the directory groups whose name matches Pattern
+type Mapping struct {
+	DN      string
+	Pattern string
+	// Group is the name of the authz group, it may reference the submatches
+	// of Pattern such as $1 and defaults to the name of the directory group
+	Group string
+}
+
+// mapping is a Mapping with its pattern compiled
+type mapping struct {
+	Mapping
+	pattern *regexp.Regexp
+}
+
+// group returns the name of the authz group entry is mapped to, false when
+// entry does not match the mapping
+func (m *mapping) group(entry *Entry) (string, bool) {
+	if m.DN != "" {
+		if !strings.EqualFold(strings.TrimSpace(m.DN), entry.DN) {
+			return "", false
+		}
+		if m.Group == "" {
+			return entry.Name, true
+		}
+		return m.Group, true
+	}
+	match := m.pattern.FindStringSubmatchIndex(entry.Name)
+	if match == nil {
+		return "", false
+	}
+	if m.Group == "" {
+		return entry.Name, true
+	}
+	return string(m.pattern.ExpandString(nil, m.Group, entry.Name, match)), true
+}
+
+// Report lists the membership changes of a sync
+type Report struct {
+	DryRun bool           `json:"dry_run"`
+	Groups []*GroupReport `json:"groups"`
+	// Unmapped are the DNs of the directory groups no mapping matched
+	Unmapped []string `json:"unmapped,omitempty"`
+}
+
+// GroupReport lists the membership changes of an authz group by email
+type GroupReport struct {
+	Group string `json:"group"`
+	// Sources are the DNs of the directory groups mapped to the group
+	Sources []string `json:"sources"`
+	// Missing reports that the group does not exist in the organization, it
+	// is not created and left out of the sync
+	Missing   bool     `json:"missing,omitempty"`
+	Added     []string `json:"added"`
+	Removed   []string `json:"removed"`
+	Unchanged int      `json:"unchanged"`
+	// UnknownMembers are the emails of directory members who are not users
+	// of the organization, users are not created by the sync
+	UnknownMembers []string `json:"unknown_members,omitempty"`
+}
+
+// Syncer reconciles the members of the mapped authz groups with the members
+// of their directory groups
+type Syncer interface {
+	// Sync makes the members of every mapped group of the organization the
+	// users of its directory groups, users added by other means are removed.
+	// When dryRun the changes are only reported. Changes are recorded in the
+	// audit log as changes of ctx's caller, Actor when ctx has none.
+	Sync(ctx context.Context, organization *models.Organization, dryRun bool) (*Report, error)
+}
+
+type syncer struct {
+	repository        Repository
+	directory         Directory
+	groupService      groups.Service
+	authorizerService authorizer.Service
+	mappings          []*mapping
+}
+
+var _ Syncer = (*syncer)(nil)
+
+// NewSyncer creates a syncer mapping the groups of the directory with the
+// mappings, the first matching mapping of a directory group applies
+func NewSyncer(db *pg.DB, directory Directory, groupService groups.Service, authorizerService authorizer.Service, mappings []Mapping) (Syncer, error) {
+	if len(mappings) == 0 {
+		return nil, errors.New("at least one group mapping is required")
+	}
+	compiled := make([]*mapping, len(mappings))
+	for i, m := range mappings {
+		compiled[i] = &mapping{Mapping: m}
+		switch {
+		case m.DN != "" && m.Pattern != "":
+			return nil, fmt.Errorf("mapping %d has both a dn and a pattern", i)
+		case m.DN != "":
+		case m.Pattern != "":
+			pattern, err := regexp.Compile(m.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("mapping %d has an invalid pattern: %w", i, err)
+			}
+			compiled[i].pattern = pattern
+		default:
+			return nil, fmt.Errorf("mapping %d has neither a dn nor a pattern", i)
+		}
+	}
+	return &syncer{
+		repository:        NewLdapsyncRepository(db),
+		directory:         directory,
+		groupService:      groupService,
+		authorizerService: authorizerService,
+		mappings:          compiled,
+	}, nil
+}
+
+func (s *syncer) Sync(ctx context.Context, organization *models.Organization, dryRun bool) (*Report, error) {
+	if _, ok := auth.FromContext(ctx); !ok {
+		ctx = auth.NewContext(ctx, &auth.Caller{Kind: auth.KindSystem, Subject: Actor, OrganizationID: organization.ID})
+	}
+
+	entries, err := s.directory.Groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{DryRun: dryRun, Groups: []*GroupReport{}}
+	// several directory groups may be mapped to one group, its members are
+	// the union of their members
+	byGroup := make(map[string]*GroupReport)
+	members := make(map[string][]string)
+	for _, entry := range entries {
+		name, ok := s.group(entry)
+		if !ok {
+			report.Unmapped = append(report.Unmapped, entry.DN)
+			continue
+		}
+		groupReport, ok := byGroup[name]
+		if !ok {
+			groupReport = &GroupReport{Group: name, Added: []string{}, Removed: []string{}}
+			byGroup[name] = groupReport
+			report.Groups = append(report.Groups, groupReport)
+		}
+		groupReport.Sources = append(groupReport.Sources, entry.DN)
+		members[name] = append(members[name], entry.Members...)
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return report.Groups[i].Group < report.Groups[j].Group
+	})
+
+	for _, groupReport := range report.Groups {
+		if err := s.reconcile(ctx, organization, groupReport, members[groupReport.Group], dryRun); err != nil {
+			return nil, fmt.Errorf("syncing group %s: %w", groupReport.Group, err)
+		}
+	}
+	return report, nil
+}
+
+// group returns the name of the authz group entry is mapped to
+func (s *syncer) group(entry *Entry) (string, bool) {
+	for _, m := range s.mappings {
+		if name, ok := m.group(entry); ok {
+			return name, name != ""
+		}
+	}
+	return "", false
+}
+
+// reconcile computes the membership changes of the group and applies them
+// unless dryRun
+func (s *syncer) reconcile(ctx context.Context, organization *models.Organization, report *GroupReport, emails []string, dryRun bool) error {
+	wanted, err := s.repository.FindUsersByEmails(organization.ID, emails)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(wanted))
+	for _, user := range wanted {
+		known[strings.ToLower(user.Email)] = true
+	}
+	seen := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		key := strings.ToLower(email)
+		if !known[key] && !seen[key] {
+			report.UnknownMembers = append(report.UnknownMembers, email)
+		}
+		seen[key] = true
+	}
+
+	group, err := s.groupService.FindByName(organization, report.Group)
+	if errors.Is(err, pg.ErrNoRows) {
+		report.Missing = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current, err := s.authorizerService.GetUsersForGroup(group.ID)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[int32]*models.User, len(wanted)+len(current))
+	wantedIDs := make([]int32, 0, len(wanted))
+	for _, user := range wanted {
+		users[user.ID] = user
+		wantedIDs = append(wantedIDs, user.ID)
+	}
+	currentIDs := make([]int32, 0, len(current))
+	for _, user := range current {
+		users[user.ID] = user
+		currentIDs = append(currentIDs, user.ID)
+	}
+
+	added := lookup(users, utils.Minus(wantedIDs, currentIDs))
+	removed := lookup(users, utils.Minus(currentIDs, wantedIDs))
+	report.Unchanged = len(utils.Intersection(utils.Unique(wantedIDs), currentIDs))
+	for _, user := range added {
+		report.Added = append(report.Added, user.Email)
+	}
+	for _, user := range removed {
+		report.Removed = append(report.Removed, user.Email)
+	}
+	sort.Strings(report.Added)
+	sort.Strings(report.Removed)
+	sort.Strings(report.UnknownMembers)
+
+	if dryRun {
+		return nil
+	}
+	if len(added) > 0 {
+		if err := s.authorizerService.AddUsersForGroup(ctx, group.ID, added); err != nil {
+			return err
+		}
+	}
+	if len(removed) > 0 {
+		if err := s.authorizerService.RemoveUsersForGroup(ctx, group.ID, removed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookup returns the users with the ids
+func lookup(users map[int32]*models.User, ids []int32) []*models.User {
+	result := make([]*models.User, 0, len(ids))
+	for _, id := range ids {
+		result = append(result, users[id])
+	}
+	return result
+}
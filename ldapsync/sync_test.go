@@ -0,0 +1,342 @@
+package ldapsync
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/groups"
+	"github.com/imtanmoy/authz/models"
+)
+
+const (
+	testBindDN   = "cn=admin,dc=example,dc=com"
+	testPassword = "secret"
+	testBaseDN   = "dc=example,dc=com"
+)
+
+// testRepository finds the users of the organization by email
+type testRepository struct {
+	users []*models.User
+}
+
+func (r *testRepository) FindUsersByEmails(organizationId int32, emails []string) ([]*models.User, error) {
+	var users []*models.User
+	for _, user := range r.users {
+		for _, email := range emails {
+			if user.OrganizationID == organizationId && strings.EqualFold(user.Email, email) {
+				users = append(users, user)
+				break
+			}
+		}
+	}
+	return users, nil
+}
+
+// testGroups finds the groups by name, other methods are not used by the sync
+type testGroups struct {
+	groups.Service
+	groups []*models.Group
+}
+
+func (g *testGroups) FindByName(organization *models.Organization, name string) (*models.Group, error) {
+	for _, group := range g.groups {
+		if group.OrganizationID == organization.ID && group.Name == name {
+			return group, nil
+		}
+	}
+	return nil, pg.ErrNoRows
+}
+
+// testAuthorizer keeps the members of groups in memory, other methods are
+// not used by the sync
+type testAuthorizer struct {
+	authorizer.Service
+	members map[int32][]*models.User
+	calls   int
+}
+
+func (a *testAuthorizer) GetUsersForGroup(id int32) ([]*models.User, error) {
+	return append([]*models.User(nil), a.members[id]...), nil
+}
+
+func (a *testAuthorizer) AddUsersForGroup(ctx context.Context, id int32, users []*models.User) error {
+	a.calls++
+	a.members[id] = append(a.members[id], users...)
+	return nil
+}
+
+func (a *testAuthorizer) RemoveUsersForGroup(ctx context.Context, id int32, users []*models.User) error {
+	a.calls++
+	kept := a.members[id][:0]
+	for _, member := range a.members[id] {
+		removed := false
+		for _, user := range users {
+			removed = removed || user.ID == member.ID
+		}
+		if !removed {
+			kept = append(kept, member)
+		}
+	}
+	a.members[id] = kept
+	return nil
+}
+
+// emails returns the sorted emails of the members of the group
+func (a *testAuthorizer) emails(id int32) []string {
+	emails := []string{}
+	for _, user := range a.members[id] {
+		emails = append(emails, user.Email)
+	}
+	sort.Strings(emails)
+	return emails
+}
+
+// fixture is a directory server with people and the syncer of organization
+type fixture struct {
+	server       *testServer
+	authorizer   *testAuthorizer
+	syncer       Syncer
+	organization *models.Organization
+}
+
+func newFixture(t *testing.T, mappings ...Mapping) *fixture {
+	t.Helper()
+	server := newTestServer(t, testBindDN, testPassword)
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		server.Put("uid="+name+",ou=people,"+testBaseDN, map[string][]string{
+			"objectClass": {"person"},
+			"mail":        {name + "@example.com"},
+		})
+	}
+	directory, err := NewLDAPDirectory(Options{
+		URL:          server.URL(),
+		BindDN:       testBindDN,
+		BindPassword: testPassword,
+		BaseDN:       testBaseDN,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	organization := &models.Organization{ID: 1}
+	repository := &testRepository{}
+	for i, name := range []string{"alice", "bob", "carol", "dave"} {
+		repository.users = append(repository.users, &models.User{ID: int32(i + 1), Email: name + "@example.com", OrganizationID: organization.ID})
+	}
+	// a user of another organization with the email of a directory member
+	repository.users = append(repository.users, &models.User{ID: 10, Email: "dave@example.com", OrganizationID: 2})
+
+	s, err := NewSyncer(nil, directory, &testGroups{groups: []*models.Group{
+		{ID: 1, Name: "engineering", OrganizationID: organization.ID},
+		{ID: 2, Name: "team-ops", OrganizationID: organization.ID},
+	}}, nil, mappings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizerService := &testAuthorizer{members: make(map[int32][]*models.User)}
+	s.(*syncer).repository = repository
+	s.(*syncer).authorizerService = authorizerService
+	return &fixture{server: server, authorizer: authorizerService, syncer: s, organization: organization}
+}
+
+// putGroup adds or replaces the directory group with the members
+func (f *fixture) putGroup(cn string, members ...string) {
+	dns := make([]string, len(members))
+	for i, member := range members {
+		dns[i] = "uid=" + member + ",ou=people," + testBaseDN
+	}
+	f.server.Put("cn="+cn+",ou=groups,"+testBaseDN, map[string][]string{
+		"objectClass": {"groupOfNames"},
+		"cn":          {cn},
+		"member":      dns,
+	})
+}
+
+func (f *fixture) sync(t *testing.T, dryRun bool) *Report {
+	t.Helper()
+	report, err := f.syncer.Sync(context.Background(), f.organization, dryRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func groupReport(t *testing.T, report *Report, name string) *GroupReport {
+	t.Helper()
+	for _, groupReport := range report.Groups {
+		if groupReport.Group == name {
+			return groupReport
+		}
+	}
+	t.Fatalf("group %s is not reported", name)
+	return nil
+}
+
+func TestSyncCreatesMemberships(t *testing.T) {
+	f := newFixture(t, Mapping{DN: "cn=developers,ou=groups," + testBaseDN, Group: "engineering"})
+	f.putGroup("developers", "alice", "bob")
+
+	report := f.sync(t, false)
+
+	got := groupReport(t, report, "engineering")
+	if want := []string{"alice@example.com", "bob@example.com"}; !reflect.DeepEqual(got.Added, want) {
+		t.Errorf("added = %v, want %v", got.Added, want)
+	}
+	if len(got.Removed) != 0 || got.Unchanged != 0 {
+		t.Errorf("removed = %v, unchanged = %d, want none", got.Removed, got.Unchanged)
+	}
+	if want := []string{"alice@example.com", "bob@example.com"}; !reflect.DeepEqual(f.authorizer.emails(1), want) {
+		t.Errorf("members = %v, want %v", f.authorizer.emails(1), want)
+	}
+}
+
+func TestSyncUpdatesMemberships(t *testing.T) {
+	f := newFixture(t, Mapping{DN: "cn=developers,ou=groups," + testBaseDN, Group: "engineering"})
+	f.putGroup("developers", "alice", "bob")
+	f.sync(t, false)
+
+	f.putGroup("developers", "bob", "carol")
+	report := f.sync(t, false)
+
+	got := groupReport(t, report, "engineering")
+	if want := []string{"carol@example.com"}; !reflect.DeepEqual(got.Added, want) {
+		t.Errorf("added = %v, want %v", got.Added, want)
+	}
+	if want := []string{"alice@example.com"}; !reflect.DeepEqual(got.Removed, want) {
+		t.Errorf("removed = %v, want %v", got.Removed, want)
+	}
+	if got.Unchanged != 1 {
+		t.Errorf("unchanged = %d, want 1", got.Unchanged)
+	}
+	if want := []string{"bob@example.com", "carol@example.com"}; !reflect.DeepEqual(f.authorizer.emails(1), want) {
+		t.Errorf("members = %v, want %v", f.authorizer.emails(1), want)
+	}
+}
+
+func TestSyncUnchangedMembershipsMakeNoChanges(t *testing.T) {
+	f := newFixture(t, Mapping{DN: "cn=developers,ou=groups," + testBaseDN, Group: "engineering"})
+	f.putGroup("developers", "alice", "bob")
+	f.sync(t, false)
+	calls := f.authorizer.calls
+
+	report := f.sync(t, false)
+
+	got := groupReport(t, report, "engineering")
+	if len(got.Added) != 0 || len(got.Removed) != 0 || got.Unchanged != 2 {
+		t.Errorf("added = %v, removed = %v, unchanged = %d, want 2 unchanged", got.Added, got.Removed, got.Unchanged)
+	}
+	if f.authorizer.calls != calls {
+		t.Errorf("authorizer called %d times, want no calls", f.authorizer.calls-calls)
+	}
+}
+
+func TestSyncRemovesMembers(t *testing.T) {
+	f := newFixture(t, Mapping{DN: "cn=developers,ou=groups," + testBaseDN, Group: "engineering"})
+	f.putGroup("developers", "alice", "bob")
+	f.sync(t, false)
+	// members added by other means are removed as well
+	f.authorizer.members[1] = append(f.authorizer.members[1], &models.User{ID: 3, Email: "carol@example.com"})
+
+	f.putGroup("developers")
+	report := f.sync(t, false)
+
+	got := groupReport(t, report, "engineering")
+	if want := []string{"alice@example.com", "bob@example.com", "carol@example.com"}; !reflect.DeepEqual(got.Removed, want) {
+		t.Errorf("removed = %v, want %v", got.Removed, want)
+	}
+	if members := f.authorizer.emails(1); len(members) != 0 {
+		t.Errorf("members = %v, want none", members)
+	}
+}
+
+func TestSyncRemovedDirectoryMembersAreLeftOut(t *testing.T) {
+	f := newFixture(t, Mapping{DN: "cn=developers,ou=groups," + testBaseDN, Group: "engineering"})
+	f.putGroup("developers", "alice", "bob")
+	f.sync(t, false)
+
+	// the member dn no longer resolves to a person
+	f.server.Remove("uid=bob,ou=people," + testBaseDN)
+	report := f.sync(t, false)
+
+	got := groupReport(t, report, "engineering")
+	if want := []string{"bob@example.com"}; !reflect.DeepEqual(got.Removed, want) {
+		t.Errorf("removed = %v, want %v", got.Removed, want)
+	}
+	if want := []string{"alice@example.com"}; !reflect.DeepEqual(f.authorizer.emails(1), want) {
+		t.Errorf("members = %v, want %v", f.authorizer.emails(1), want)
+	}
+}
+
+func TestSyncDryRunReportsWithoutChanges(t *testing.T) {
+	f := newFixture(t, Mapping{DN: "cn=developers,ou=groups," + testBaseDN, Group: "engineering"})
+	f.putGroup("developers", "alice", "bob")
+
+	report := f.sync(t, true)
+
+	if !report.DryRun {
+		t.Error("report is not a dry run")
+	}
+	if got := groupReport(t, report, "engineering"); len(got.Added) != 2 {
+		t.Errorf("added = %v, want 2 members", got.Added)
+	}
+	if f.authorizer.calls != 0 || len(f.authorizer.members[1]) != 0 {
+		t.Errorf("dry run changed the members to %v", f.authorizer.emails(1))
+	}
+}
+
+func TestSyncMapsGroupsByPattern(t *testing.T) {
+	f := newFixture(t, Mapping{Pattern: `^ops-(\w+)$`, Group: "team-$1"})
+	f.putGroup("ops-ops", "alice")
+	f.putGroup("ops-infra", "carol")
+	f.putGroup("sales", "dave", "erin")
+
+	report := f.sync(t, false)
+
+	if got := groupReport(t, report, "team-ops"); !reflect.DeepEqual(got.Added, []string{"alice@example.com"}) {
+		t.Errorf("team-ops added = %v, want alice", got.Added)
+	}
+	if got := groupReport(t, report, "team-infra"); !got.Missing || len(got.Added) != 0 {
+		t.Errorf("team-infra missing = %v, added = %v, want missing without changes", got.Missing, got.Added)
+	}
+	if want := []string{"cn=sales,ou=groups," + testBaseDN}; !reflect.DeepEqual(report.Unmapped, want) {
+		t.Errorf("unmapped = %v, want %v", report.Unmapped, want)
+	}
+}
+
+func TestSyncReportsUnknownMembers(t *testing.T) {
+	f := newFixture(t, Mapping{DN: "cn=developers,ou=groups," + testBaseDN, Group: "engineering"})
+	f.server.Put("uid=erin,ou=people,"+testBaseDN, map[string][]string{"mail": {"erin@example.com"}})
+	f.putGroup("developers", "alice", "erin")
+
+	report := f.sync(t, false)
+
+	got := groupReport(t, report, "engineering")
+	if want := []string{"erin@example.com"}; !reflect.DeepEqual(got.UnknownMembers, want) {
+		t.Errorf("unknown members = %v, want %v", got.UnknownMembers, want)
+	}
+	if want := []string{"alice@example.com"}; !reflect.DeepEqual(f.authorizer.emails(1), want) {
+		t.Errorf("members = %v, want %v", f.authorizer.emails(1), want)
+	}
+}
+
+func TestLDAPDirectoryRejectsInvalidCredentials(t *testing.T) {
+	server := newTestServer(t, testBindDN, testPassword)
+	directory, err := NewLDAPDirectory(Options{
+		URL:          server.URL(),
+		BindDN:       testBindDN,
+		BindPassword: "wrong",
+		BaseDN:       testBaseDN,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := directory.Groups(context.Background()); err == nil {
+		t.Error("groups were listed with invalid credentials")
+	}
+}
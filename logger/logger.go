@@ -39,6 +39,11 @@ func InitLogger() error {
 	return nil
 }
 
+// Default returns the package level logger initialized by InitLogger
+func Default() Logger {
+	return log
+}
+
 func Debugf(format string, args ...interface{}) {
 	log.Debugf(format, args...)
 }
@@ -0,0 +1,111 @@
+package members
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9"
+	param "github.com/oceanicdev/chi-param"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/users"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// Handler handles members http method
+type Handler interface {
+	Invite(w http.ResponseWriter, r *http.Request)
+	Remove(w http.ResponseWriter, r *http.Request)
+}
+
+type memberHandler struct {
+	db          *pg.DB
+	service     Service
+	userService users.Service
+}
+
+var _ Handler = (*memberHandler)(nil)
+
+// NewMemberHandler construct member handler
+func NewMemberHandler(db *pg.DB, service Service, userService users.Service) Handler {
+	return &memberHandler{
+		db:          db,
+		service:     service,
+		userService: userService,
+	}
+}
+
+// Invite makes a user of another organization a member of the request
+// organization, it is then listed with its users and can join its groups
+func (m *memberHandler) Invite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	data := &MemberPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+	validationErrors := data.validate()
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	user, err := m.userService.Find(data.User)
+	if err != nil {
+		validationErrors.Add("user", "invalid user")
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+	if user.OrganizationID == organization.ID {
+		_ = render.Render(w, r, httputil.NewAPIError(409, "user belongs to the organization"))
+		return
+	}
+	if _, err := m.service.FindByUserIdAndOrganizationId(user.ID, organization.ID); !errors.Is(err, pg.ErrNoRows) {
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(err))
+			return
+		}
+		_ = render.Render(w, r, httputil.NewAPIError(409, "user is already a member of the organization"))
+		return
+	}
+
+	member, err := m.service.Invite(ctx, organization, user)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	_ = render.Render(w, r, NewMemberResponse(member))
+}
+
+// Remove ends the membership of the user in the request organization, users
+// belonging to the organization are deleted instead
+func (m *memberHandler) Remove(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	id, err := param.Int32(r, "id")
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+		return
+	}
+	member, err := m.service.FindByUserIdAndOrganizationId(id, organization.ID)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(404, "member not found", err))
+		return
+	}
+	if err := m.service.Remove(ctx, member); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	render.NoContent(w, r)
+}
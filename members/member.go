@@ -0,0 +1,58 @@
+package members
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"gopkg.in/thedevsaddam/govalidator.v1"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+type MemberPayload struct {
+	// User is the id of the invited user
+	User int32 `json:"user"`
+}
+
+func (m *MemberPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (m *MemberPayload) validate() url.Values {
+	rules := govalidator.MapData{
+		"user": []string{"required"},
+	}
+	opts := govalidator.Options{
+		Data:  m,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	return e
+}
+
+type MemberResponse struct {
+	UserID int32  `json:"user_id"`
+	Email  string `json:"email"`
+	// UserOrganizationID is the organization the user belongs to
+	UserOrganizationID int32     `json:"user_organization_id"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+func (m *MemberResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewMemberResponse(member *models.OrganizationMember) *MemberResponse {
+	resp := &MemberResponse{
+		UserID:    member.UserID,
+		CreatedAt: member.CreatedAt,
+	}
+	if member.User != nil {
+		resp.Email = member.User.Email
+		resp.UserOrganizationID = member.User.OrganizationID
+	}
+	return resp
+}
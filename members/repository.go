@@ -0,0 +1,44 @@
+package members
+
+import (
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/models"
+)
+
+type Repository interface {
+	Create(tx *pg.Tx, member *models.OrganizationMember) (*models.OrganizationMember, error)
+	FindByUserIdAndOrganizationId(userId int32, Oid int32) (*models.OrganizationMember, error)
+	Delete(tx *pg.Tx, member *models.OrganizationMember) error
+}
+
+type memberRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*memberRepository)(nil)
+
+func NewMemberRepository(db *pg.DB) Repository {
+	return &memberRepository{
+		db,
+	}
+}
+
+func (m *memberRepository) Create(tx *pg.Tx, member *models.OrganizationMember) (*models.OrganizationMember, error) {
+	_, err := tx.Model(member).Returning("*").Insert()
+	return member, err
+}
+
+func (m *memberRepository) FindByUserIdAndOrganizationId(userId int32, Oid int32) (*models.OrganizationMember, error) {
+	var member models.OrganizationMember
+	err := m.db.Model(&member).
+		Where("organization_member.user_id = ?", userId).
+		Where("organization_member.organization_id = ?", Oid).
+		Relation("User").
+		First()
+	return &member, err
+}
+
+func (m *memberRepository) Delete(tx *pg.Tx, member *models.OrganizationMember) error {
+	_, err := tx.Model(member).WherePK().Delete()
+	return err
+}
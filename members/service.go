@@ -0,0 +1,135 @@
+// Package members manages the memberships of users in organizations other
+// than their own, so one user can access several organizations. Group
+// memberships and checks stay within each organization.
+package members
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/groups"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/outbox"
+)
+
+type Service interface {
+	FindByUserIdAndOrganizationId(userId int32, Oid int32) (*models.OrganizationMember, error)
+	// Invite makes the user of another organization a member of the organization
+	Invite(ctx context.Context, organization *models.Organization, user *models.User) (*models.OrganizationMember, error)
+	// Remove removes the user from the groups of the organization and ends
+	// its membership
+	Remove(ctx context.Context, member *models.OrganizationMember) error
+}
+
+type memberService struct {
+	db                *pg.DB
+	repository        Repository
+	groupRepository   groups.Repository
+	authorizerService authorizer.Service
+	auditService      audit.Service
+	outboxRepository  outbox.Repository
+}
+
+var _ Service = (*memberService)(nil)
+
+func NewMemberService(db *pg.DB, authorizerService authorizer.Service, auditService audit.Service) Service {
+	return &memberService{
+		db:                db,
+		repository:        NewMemberRepository(db),
+		groupRepository:   groups.NewGroupRepository(db),
+		authorizerService: authorizerService,
+		auditService:      auditService,
+		outboxRepository:  outbox.NewOutboxRepository(db),
+	}
+}
+
+func (m *memberService) FindByUserIdAndOrganizationId(userId int32, Oid int32) (*models.OrganizationMember, error) {
+	return m.repository.FindByUserIdAndOrganizationId(userId, Oid)
+}
+
+func (m *memberService) Invite(ctx context.Context, organization *models.Organization, user *models.User) (*models.OrganizationMember, error) {
+	member := &models.OrganizationMember{
+		OrganizationID: organization.ID,
+		UserID:         user.ID,
+		User:           user,
+	}
+	err := m.db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := m.repository.Create(tx, member); err != nil {
+			return err
+		}
+		if err := m.publish(tx, events.OrganizationMemberAdded, member); err != nil {
+			return err
+		}
+		return m.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: organization.ID,
+			Action:         audit.OrganizationMemberAdded,
+			TargetType:     audit.TargetUser,
+			TargetID:       user.ID,
+			After:          auditMember(member),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (m *memberService) Remove(ctx context.Context, member *models.OrganizationMember) error {
+	// the groups are left first so the user never keeps access to the
+	// organization after its membership ended
+	groupIds, err := m.authorizerService.GetGroupIdsForUser(member.UserID)
+	if err != nil {
+		return err
+	}
+	removed := make(map[int32][]*models.User)
+	if len(groupIds) > 0 {
+		for _, group := range m.groupRepository.FindAllByIdIn(groupIds) {
+			if group.OrganizationID == member.OrganizationID {
+				removed[group.ID] = []*models.User{member.User}
+			}
+		}
+	}
+	if len(removed) > 0 {
+		if err := m.authorizerService.UpdateUsersForGroups(ctx, nil, removed); err != nil {
+			return err
+		}
+	}
+
+	return m.db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := m.repository.Delete(tx, member); err != nil {
+			return err
+		}
+		if err := m.publish(tx, events.OrganizationMemberRemoved, member); err != nil {
+			return err
+		}
+		return m.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: member.OrganizationID,
+			Action:         audit.OrganizationMemberRemoved,
+			TargetType:     audit.TargetUser,
+			TargetID:       member.UserID,
+			Before:         auditMember(member),
+		})
+	})
+}
+
+// publish adds an event of the membership to the outbox within tx
+func (m *memberService) publish(tx *pg.Tx, eventType string, member *models.OrganizationMember) error {
+	event, err := events.New(eventType, member.OrganizationID, map[string]interface{}{"user_id": member.UserID})
+	if err != nil {
+		return err
+	}
+	return m.outboxRepository.Add(tx, event)
+}
+
+// auditMember returns the audited state of the membership
+func auditMember(member *models.OrganizationMember) map[string]interface{} {
+	state := map[string]interface{}{"user_id": member.UserID}
+	if member.User != nil {
+		state["email"] = member.User.Email
+		state["user_organization_id"] = member.User.OrganizationID
+	}
+	return state
+}
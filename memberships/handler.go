@@ -0,0 +1,100 @@
+package memberships
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/utils"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// Handler handles memberships http method
+type Handler interface {
+	Reconcile(w http.ResponseWriter, r *http.Request)
+}
+
+type membershipHandler struct {
+	db                  *pg.DB
+	service             Service
+	organizationService organizations.Service
+}
+
+var _ Handler = (*membershipHandler)(nil)
+
+// NewMembershipHandler construct membership handler
+func NewMembershipHandler(db *pg.DB, service Service, organizationService organizations.Service) Handler {
+	return &membershipHandler{
+		db:                  db,
+		service:             service,
+		organizationService: organizationService,
+	}
+}
+
+// Reconcile replaces the group memberships of the organization with the
+// requested mapping and responds with the users added to and removed from
+// each group
+func (m *membershipHandler) Reconcile(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+
+	data := &MembershipsPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	validationErrors := data.validate()
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	userIds := make([]int32, 0, len(data.Users))
+	groupIds := make([]int32, 0)
+	for _, user := range data.Users {
+		userIds = append(userIds, user.ID)
+		groupIds = append(groupIds, user.Groups...)
+	}
+	userList := make([]*models.User, 0)
+	if len(userIds) > 0 {
+		// check if users belongs to the organization
+		userList, _ = m.organizationService.FindUsersByIds(organization, userIds)
+		if len(userList) != len(userIds) {
+			validationErrors.Add("users", "invalid user list")
+		}
+	}
+	groupList, err := m.service.Groups(organization)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	organizationGroups := make([]int32, 0, len(groupList))
+	for _, group := range groupList {
+		organizationGroups = append(organizationGroups, group.ID)
+	}
+	// check if groups belongs to the organization
+	if len(utils.Minus(utils.Unique(groupIds), organizationGroups)) > 0 {
+		validationErrors.Add("groups", "invalid group list")
+	}
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	summary, err := m.service.Reconcile(ctx, groupList, userList, data.memberships())
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	if err := render.Render(w, r, NewSummaryResponse(summary)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
@@ -0,0 +1,93 @@
+package memberships
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// maxUsers bounds the users of a reconciliation request
+const maxUsers = 10000
+
+type MembershipsPayload struct {
+	// Users is the complete mapping of the users to their groups, users left
+	// out are removed from every group
+	Users []*UserMembershipPayload `json:"users"`
+}
+
+type UserMembershipPayload struct {
+	ID     int32   `json:"id"`
+	Groups []int32 `json:"groups"`
+}
+
+func (m *MembershipsPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (m *MembershipsPayload) validate() url.Values {
+	e := make(url.Values)
+	if m.Users == nil {
+		e.Add("users", "The users field is required")
+		return e
+	}
+	if len(m.Users) > maxUsers {
+		e.Add("users", fmt.Sprintf("The users field may not have more than %d items", maxUsers))
+		return e
+	}
+	seen := make(map[int32]bool, len(m.Users))
+	for _, user := range m.Users {
+		if user == nil || user.ID == 0 {
+			e.Add("users", "The users field must only contain users with an id")
+			break
+		}
+		if seen[user.ID] {
+			e.Add("users", fmt.Sprintf("User %d is listed more than once", user.ID))
+			break
+		}
+		seen[user.ID] = true
+	}
+	return e
+}
+
+// memberships returns the group ids of each user id
+func (m *MembershipsPayload) memberships() map[int32][]int32 {
+	memberships := make(map[int32][]int32, len(m.Users))
+	for _, user := range m.Users {
+		memberships[user.ID] = user.Groups
+	}
+	return memberships
+}
+
+type GroupChangeResponse struct {
+	ID      int32   `json:"id"`
+	Name    string  `json:"name"`
+	Added   []int32 `json:"added"`
+	Removed []int32 `json:"removed"`
+}
+
+type SummaryResponse struct {
+	Added   int                    `json:"added"`
+	Removed int                    `json:"removed"`
+	Groups  []*GroupChangeResponse `json:"groups"`
+}
+
+func (s *SummaryResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewSummaryResponse(summary *Summary) *SummaryResponse {
+	resp := &SummaryResponse{
+		Added:   summary.Added,
+		Removed: summary.Removed,
+		Groups:  make([]*GroupChangeResponse, 0, len(summary.Groups)),
+	}
+	for _, group := range summary.Groups {
+		resp.Groups = append(resp.Groups, &GroupChangeResponse{
+			ID:      group.Group.ID,
+			Name:    group.Group.Name,
+			Added:   group.Added,
+			Removed: group.Removed,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,33 @@
+package memberships
+
+import (
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+type Repository interface {
+	// ListGroups returns the groups of the organization which are not deleted
+	ListGroups(organizationId int32) ([]*models.Group, error)
+}
+
+type membershipRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*membershipRepository)(nil)
+
+func NewMembershipRepository(db *pg.DB) Repository {
+	return &membershipRepository{
+		db,
+	}
+}
+
+func (m *membershipRepository) ListGroups(organizationId int32) ([]*models.Group, error) {
+	var groups []*models.Group
+	err := m.db.Model(&groups).
+		Where(`"group".organization_id = ?`, organizationId).
+		OrderExpr(`"group".id ASC`).
+		Select()
+	return groups, err
+}
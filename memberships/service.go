@@ -0,0 +1,134 @@
+// Package memberships reconciles the group memberships of an organization
+// with a complete user to groups mapping stated by an external system.
+package memberships
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils"
+)
+
+// Summary lists the membership changes of a reconciliation
+type Summary struct {
+	// Groups are the groups whose members changed, ordered by id
+	Groups  []*GroupSummary
+	Added   int
+	Removed int
+}
+
+// GroupSummary lists the ids of the users added to and removed from a group
+type GroupSummary struct {
+	Group   *models.Group
+	Added   []int32
+	Removed []int32
+}
+
+type Service interface {
+	// Groups returns the groups of the organization which are not deleted
+	Groups(organization *models.Organization) ([]*models.Group, error)
+	// Reconcile makes memberships, the group ids of each user id, the
+	// complete memberships of groups: listed users become members of exactly
+	// their groups and other users are removed from every group. The changes
+	// are applied in one transaction, users and groups are neither created
+	// nor deleted.
+	Reconcile(ctx context.Context, groups []*models.Group, users []*models.User, memberships map[int32][]int32) (*Summary, error)
+}
+
+type membershipService struct {
+	db                *pg.DB
+	repository        Repository
+	authorizerService authorizer.Service
+}
+
+var _ Service = (*membershipService)(nil)
+
+func NewMembershipService(db *pg.DB, authorizerService authorizer.Service) Service {
+	return &membershipService{
+		db:                db,
+		repository:        NewMembershipRepository(db),
+		authorizerService: authorizerService,
+	}
+}
+
+func (m *membershipService) Groups(organization *models.Organization) ([]*models.Group, error) {
+	return m.repository.ListGroups(organization.ID)
+}
+
+func (m *membershipService) Reconcile(ctx context.Context, groups []*models.Group, users []*models.User, memberships map[int32][]int32) (*Summary, error) {
+	groupIds := make([]int32, 0, len(groups))
+	for _, group := range groups {
+		groupIds = append(groupIds, group.ID)
+	}
+	existing, err := m.authorizerService.GetUsersForGroups(groupIds)
+	if err != nil {
+		return nil, err
+	}
+
+	userMap := make(map[int32]*models.User, len(users))
+	for _, user := range users {
+		userMap[user.ID] = user
+	}
+	wanted := make(map[int32][]int32, len(groups))
+	for userId, ids := range memberships {
+		for _, groupId := range utils.Unique(ids) {
+			wanted[groupId] = append(wanted[groupId], userId)
+		}
+	}
+
+	summary := &Summary{Groups: make([]*GroupSummary, 0)}
+	added := make(map[int32][]*models.User)
+	removed := make(map[int32][]*models.User)
+	for _, group := range groups {
+		existingUsers := make([]int32, 0, len(existing[group.ID]))
+		for _, user := range existing[group.ID] {
+			existingUsers = append(existingUsers, user.ID)
+			userMap[user.ID] = user
+		}
+		newUsers := wanted[group.ID]
+		oldUsers := utils.Intersection(existingUsers, newUsers)
+		deleteUsers := sorted(utils.Minus(existingUsers, oldUsers))
+		willBeAddedUsers := sorted(utils.Minus(newUsers, oldUsers))
+		if len(deleteUsers) == 0 && len(willBeAddedUsers) == 0 {
+			continue
+		}
+
+		for _, id := range willBeAddedUsers {
+			added[group.ID] = append(added[group.ID], userMap[id])
+		}
+		for _, id := range deleteUsers {
+			removed[group.ID] = append(removed[group.ID], userMap[id])
+		}
+		summary.Groups = append(summary.Groups, &GroupSummary{
+			Group:   group,
+			Added:   willBeAddedUsers,
+			Removed: deleteUsers,
+		})
+		summary.Added += len(willBeAddedUsers)
+		summary.Removed += len(deleteUsers)
+	}
+	sort.Slice(summary.Groups, func(i, j int) bool {
+		return summary.Groups[i].Group.ID < summary.Groups[j].Group.ID
+	})
+
+	if len(summary.Groups) == 0 {
+		return summary, nil
+	}
+	if err := m.authorizerService.UpdateUsersForGroups(ctx, added, removed); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// sorted returns ids in ascending order, utils.Minus returns them unordered
+func sorted(ids []int32) []int32 {
+	if ids == nil {
+		ids = make([]int32, 0)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/go-pg/pg/v9/orm"
 	"time"
 )
@@ -14,30 +15,70 @@ func init() {
 
 // Organization represent organizations table
 type Organization struct {
-	ID    int32   `pg:"id,notnull,unique"`
-	Name  string  `pg:"name,notnull"`
-	Users []*User `pg:"fk:organization_id"`
+	ID int32 `pg:"id,notnull,unique"`
+	// ExternalID is the id of the organization in the identity provider
+	ExternalID string    `pg:"external_id"`
+	Name       string    `pg:"name,notnull"`
+	CreatedAt  time.Time `pg:"created_at,notnull,default:now()"`
+	Users      []*User   `pg:"fk:organization_id"`
 }
 
-// User represent users table
+var _ orm.BeforeInsertHook = (*Organization)(nil)
+
+//BeforeInsert organization hooks
+func (o *Organization) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if o.CreatedAt.IsZero() {
+		o.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
+
+// User represent users table, the user belongs to the organization of
+// OrganizationID and may be a member of other organizations
 type User struct {
-	ID             int32  `pg:"id,notnull,unique"`
-	Email          string `pg:"email,notnull,unique"`
-	OrganizationID int32  `pg:"organization_id,notnull"`
+	ID int32 `pg:"id,notnull,unique"`
+	// ExternalID is the id of the user in the identity provider, unique
+	// within the organization
+	ExternalID     string    `pg:"external_id"`
+	Email          string    `pg:"email,notnull,unique"`
+	OrganizationID int32     `pg:"organization_id,notnull"`
+	CreatedAt      time.Time `pg:"created_at,notnull,default:now()"`
 	Organization   *Organization
 	Groups         []*Group `pg:"-"`
 }
 
+var _ orm.BeforeInsertHook = (*User)(nil)
+
+//BeforeInsert user hooks
+func (u *User) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
+
 // Group represent groups table
 type Group struct {
-	ID             int32         `pg:"id,notnull"`
-	Name           string        `pg:"name,notnull,unique:uk_groups_name_org"`
-	OrganizationID int32         `pg:"organization_id,notnull,unique:uk_groups_name_org"`
-	CreatedAt      time.Time     `pg:"created_at,notnull,default:now()"`
-	UpdatedAt      time.Time     `pg:"updated_at,default:now()"`
-	Users          []*User       `pg:"-"`
-	Permissions    []*Permission `pg:"-"`
-	Organization   *Organization
+	ID             int32          `pg:"id,notnull"`
+	Name           string         `pg:"name,notnull,unique:uk_groups_name_org"`
+	OrganizationID int32          `pg:"organization_id,notnull,unique:uk_groups_name_org"`
+	CreatedAt      time.Time      `pg:"created_at,notnull,default:now()"`
+	UpdatedAt      time.Time      `pg:"updated_at,default:now()"`
+	Revision       int32          `pg:"revision,notnull,default:1"`
+	DeletedAt      time.Time      `pg:"deleted_at,soft_delete"`
+	Snapshot       *GroupSnapshot `pg:"snapshot"`
+	Users          []*User        `pg:"-"`
+	Permissions    []*Permission  `pg:"-"`
+	// ServiceAccounts are only loaded from the snapshot of deleted groups
+	ServiceAccounts []*ServiceAccount `pg:"-"`
+	Organization    *Organization
+}
+
+// GroupSnapshot keeps the memberships of a deleted group so it can be restored
+type GroupSnapshot struct {
+	Users           []int32 `json:"users"`
+	ServiceAccounts []int32 `json:"service_accounts"`
+	Permissions     []int32 `json:"permissions"`
 }
 
 var _ orm.BeforeInsertHook = (*Group)(nil)
@@ -51,6 +92,9 @@ func (g *Group) BeforeInsert(ctx context.Context) (context.Context, error) {
 	if g.UpdatedAt.IsZero() {
 		g.UpdatedAt = time.Now()
 	}
+	if g.Revision == 0 {
+		g.Revision = 1
+	}
 	return ctx, nil
 }
 
@@ -87,3 +131,227 @@ func (p *Permission) BeforeUpdate(ctx context.Context) (context.Context, error)
 	p.UpdatedAt = time.Now()
 	return ctx, nil
 }
+
+// APIKey represent api_keys table, a key without organization belongs to a superadmin
+type APIKey struct {
+	tableName      struct{}  `pg:"api_keys,alias:api_key"`
+	ID             int32     `pg:"id,notnull"`
+	Name           string    `pg:"name,notnull"`
+	Prefix         string    `pg:"prefix,notnull,unique"`
+	KeyHash        string    `pg:"key_hash,notnull"`
+	OrganizationID int32     `pg:"organization_id"`
+	CreatedAt      time.Time `pg:"created_at,notnull,default:now()"`
+	RevokedAt      time.Time `pg:"revoked_at"`
+	Organization   *Organization
+}
+
+var _ orm.BeforeInsertHook = (*APIKey)(nil)
+
+//BeforeInsert hooks
+func (k *APIKey) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
+
+// IsRevoked reports whether the key has been revoked
+func (k *APIKey) IsRevoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// AuditEntry represent audit_log table, entries are only ever appended and
+// Hash chains each entry to the PrevHash of the organization's previous entry
+type AuditEntry struct {
+	tableName      struct{}        `pg:"audit_log,alias:audit_entry"`
+	ID             int32           `pg:"id,notnull"`
+	OrganizationID int32           `pg:"organization_id"`
+	Actor          string          `pg:"actor,notnull"`
+	Action         string          `pg:"action,notnull"`
+	TargetType     string          `pg:"target_type,notnull"`
+	TargetID       int32           `pg:"target_id,notnull"`
+	Before         json.RawMessage `pg:"before"`
+	After          json.RawMessage `pg:"after"`
+	RequestID      string          `pg:"request_id"`
+	CreatedAt      time.Time       `pg:"created_at,notnull,default:now()"`
+	PrevHash       string          `pg:"prev_hash"`
+	Hash           string          `pg:"hash,notnull"`
+}
+
+var _ orm.BeforeInsertHook = (*AuditEntry)(nil)
+
+//BeforeInsert hooks
+func (e *AuditEntry) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
+
+// Webhook represent webhooks table, Secret signs the events of the
+// subscribed Events types delivered to URL
+type Webhook struct {
+	tableName      struct{}  `pg:"webhooks,alias:webhook"`
+	ID             int32     `pg:"id,notnull"`
+	OrganizationID int32     `pg:"organization_id,notnull"`
+	URL            string    `pg:"url,notnull"`
+	Secret         string    `pg:"secret,notnull"`
+	Events         []string  `pg:"events,array"`
+	Active         bool      `pg:"active,notnull,use_zero"`
+	CreatedAt      time.Time `pg:"created_at,notnull,default:now()"`
+	UpdatedAt      time.Time `pg:"updated_at"`
+}
+
+var _ orm.BeforeInsertHook = (*Webhook)(nil)
+
+//BeforeInsert hooks
+func (w *Webhook) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if w.CreatedAt.IsZero() {
+		w.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
+
+var _ orm.BeforeUpdateHook = (*Webhook)(nil)
+
+//BeforeUpdate hooks
+func (w *Webhook) BeforeUpdate(ctx context.Context) (context.Context, error) {
+	w.UpdatedAt = time.Now()
+	return ctx, nil
+}
+
+// WebhookDelivery represent webhook_deliveries table, one row per attempt
+// to deliver an event
+type WebhookDelivery struct {
+	tableName  struct{}        `pg:"webhook_deliveries,alias:webhook_delivery"`
+	ID         int32           `pg:"id,notnull"`
+	WebhookID  int32           `pg:"webhook_id,notnull"`
+	EventID    string          `pg:"event_id,notnull"`
+	EventType  string          `pg:"event_type,notnull"`
+	Payload    json.RawMessage `pg:"payload"`
+	Attempt    int             `pg:"attempt,notnull"`
+	StatusCode int             `pg:"status_code"`
+	Error      string          `pg:"error"`
+	DurationMs int64           `pg:"duration_ms,notnull,use_zero"`
+	CreatedAt  time.Time       `pg:"created_at,notnull,default:now()"`
+}
+
+var _ orm.BeforeInsertHook = (*WebhookDelivery)(nil)
+
+//BeforeInsert hooks
+func (d *WebhookDelivery) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
+
+// Succeeded reports whether the endpoint acknowledged the delivery
+func (d *WebhookDelivery) Succeeded() bool {
+	return d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// WebhookJob represent webhook_jobs table, an event waiting to be delivered
+// to a webhook
+type WebhookJob struct {
+	tableName   struct{}        `pg:"webhook_jobs,alias:webhook_job"`
+	ID          int32           `pg:"id,notnull"`
+	WebhookID   int32           `pg:"webhook_id,notnull"`
+	EventID     string          `pg:"event_id,notnull"`
+	EventType   string          `pg:"event_type,notnull"`
+	Payload     json.RawMessage `pg:"payload"`
+	Attempts    int             `pg:"attempts,notnull,use_zero"`
+	AvailableAt time.Time       `pg:"available_at,notnull,default:now()"`
+	CreatedAt   time.Time       `pg:"created_at,notnull,default:now()"`
+}
+
+// OutboxEvent represent outbox table, events are written in the transaction
+// of the change they describe and published by the outbox dispatcher
+type OutboxEvent struct {
+	tableName      struct{}        `pg:"outbox,alias:outbox_event"`
+	ID             int32           `pg:"id,notnull"`
+	EventID        string          `pg:"event_id,notnull"`
+	EventType      string          `pg:"event_type,notnull"`
+	OrganizationID int32           `pg:"organization_id"`
+	Data           json.RawMessage `pg:"data"`
+	Attempts       int             `pg:"attempts,notnull,use_zero"`
+	LastError      string          `pg:"last_error"`
+	PublishedSinks []int           `pg:"published_sinks,array,notnull"`
+	AvailableAt    time.Time       `pg:"available_at,notnull,default:now()"`
+	CreatedAt      time.Time       `pg:"created_at,notnull,default:now()"`
+	PublishedAt    time.Time       `pg:"published_at"`
+}
+
+// ImportJob represent import_jobs table, the progress of a user import run
+// in the background
+type ImportJob struct {
+	tableName      struct{}  `pg:"import_jobs,alias:import_job"`
+	ID             int32     `pg:"id,notnull"`
+	OrganizationID int32     `pg:"organization_id,notnull"`
+	Status         string    `pg:"status,notnull"`
+	Total          int       `pg:"total,notnull,use_zero"`
+	Imported       int       `pg:"imported,notnull,use_zero"`
+	Error          string    `pg:"error"`
+	CreatedAt      time.Time `pg:"created_at,notnull,default:now()"`
+	FinishedAt     time.Time `pg:"finished_at"`
+}
+
+var _ orm.BeforeInsertHook = (*ImportJob)(nil)
+
+//BeforeInsert hooks
+func (j *ImportJob) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if j.CreatedAt.IsZero() {
+		j.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
+
+// ServiceAccount represent service_accounts table, the principal of a batch
+// job or a service which is granted permissions like a user
+type ServiceAccount struct {
+	tableName      struct{}      `pg:"service_accounts,alias:service_account"`
+	ID             int32         `pg:"id,notnull"`
+	OrganizationID int32         `pg:"organization_id,notnull"`
+	Name           string        `pg:"name,notnull"`
+	Description    string        `pg:"description"`
+	CreatedAt      time.Time     `pg:"created_at,notnull,default:now()"`
+	UpdatedAt      time.Time     `pg:"updated_at"`
+	Groups         []*Group      `pg:"-"`
+	Permissions    []*Permission `pg:"-"`
+}
+
+var _ orm.BeforeInsertHook = (*ServiceAccount)(nil)
+var _ orm.BeforeUpdateHook = (*ServiceAccount)(nil)
+
+//BeforeInsert hooks
+func (s *ServiceAccount) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
+
+func (s *ServiceAccount) BeforeUpdate(ctx context.Context) (context.Context, error) {
+	s.UpdatedAt = time.Now()
+	return ctx, nil
+}
+
+// OrganizationMember represent organization_members table, the membership of
+// a user in an organization other than its own
+type OrganizationMember struct {
+	tableName      struct{}  `pg:"organization_members,alias:organization_member"`
+	OrganizationID int32     `pg:"organization_id,pk"`
+	UserID         int32     `pg:"user_id,pk"`
+	CreatedAt      time.Time `pg:"created_at,notnull,default:now()"`
+	User           *User
+}
+
+var _ orm.BeforeInsertHook = (*OrganizationMember)(nil)
+
+//BeforeInsert hooks
+func (m *OrganizationMember) BeforeInsert(ctx context.Context) (context.Context, error) {
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+	return ctx, nil
+}
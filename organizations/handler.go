@@ -1,17 +1,27 @@
 package organizations
 
 import (
+	"context"
 	"net/http"
+	"strconv"
 
+	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	"github.com/go-pg/pg/v9"
-	param "github.com/oceanicdev/chi-param"
 
+	"github.com/imtanmoy/authz/auth"
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils"
 	"github.com/imtanmoy/authz/utils/httputil"
+	"github.com/imtanmoy/authz/utils/pagination"
 )
 
 type Handler interface {
+	// ResolveID rewrites the url parameter of an organization given by its
+	// external id to its id, so the organization checks of later handlers
+	// see the id
+	ResolveID(name string) func(next http.Handler) http.Handler
+	OrganizationCtx(next http.Handler) http.Handler
 	List(w http.ResponseWriter, r *http.Request)
 	Get(w http.ResponseWriter, r *http.Request)
 	Create(w http.ResponseWriter, r *http.Request)
@@ -24,21 +34,71 @@ type organizationHandler struct {
 	db      *pg.DB
 }
 
-func NewOrganizationHandler(db *pg.DB) Handler {
+func NewOrganizationHandler(db *pg.DB, service Service) Handler {
 	return &organizationHandler{
-		service: NewOrganizationService(db),
+		service: service,
 		db:      db,
 	}
 }
 
+// ResolveID leaves unknown external ids to superadmins, who may create
+// organizations by external id, and rejects them as forbidden for other
+// callers to not reveal the organizations of others
+func (o *organizationHandler) ResolveID(name string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, externalID := utils.ParseID(chi.URLParam(r, name))
+			if externalID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			organization, err := o.service.FindByIdentifier(externalID)
+			if err != nil {
+				if caller, ok := auth.FromContext(r.Context()); ok && caller.IsSuperAdmin() {
+					next.ServeHTTP(w, r)
+					return
+				}
+				_ = render.Render(w, r, httputil.NewAPIError(403, "Forbidden"))
+				return
+			}
+			// url parameters are looked up from the last one
+			rctx := chi.RouteContext(r.Context())
+			rctx.URLParams.Add(name, strconv.Itoa(int(organization.ID)))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// OrganizationCtx loads the organization identified by the oid url
+// parameter, its id or its external id, into the request context
+func (o *organizationHandler) OrganizationCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		organization, err := o.service.FindByIdentifier(chi.URLParam(r, "oid"))
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(404, "organization not found", err))
+			return
+		}
+		ctx := context.WithValue(r.Context(), "organization", organization)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func (o *organizationHandler) List(w http.ResponseWriter, r *http.Request) {
-	organizations, err := o.service.List()
+	params, validationErrors := pagination.ParseParams(r, "name", "created_at")
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+	organizations, err := o.service.List(params)
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
+	n, page := params.Page(len(organizations), func(i int) (int32, interface{}) {
+		return organizations[i].ID, organizationSortValue(organizations[i], params.Sort)
+	})
 
-	if err := render.RenderList(w, r, NewOrganizationListResponse(organizations)); err != nil {
+	if err := render.Render(w, r, pagination.NewListResponse(NewOrganizationListResponse(organizations[:n]), page)); err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
@@ -58,20 +118,27 @@ func (o *organizationHandler) Create(w http.ResponseWriter, r *http.Request) {
 		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid Request", validationErrors))
 		return
 	}
-	exist := o.service.Exists(data.ID)
-	if exist {
+	if data.ID != 0 && o.service.Exists(data.ID) {
 		existErr := map[string][]string{
 			"id": {"Organization with same id already exits"},
 		}
 		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid Request", existErr))
 		return
 	}
+	if data.ExternalID != "" && o.service.ExternalIdExists(data.ExternalID) {
+		existErr := map[string][]string{
+			"external_id": {"Organization with same external_id already exits"},
+		}
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid Request", existErr))
+		return
+	}
 
 	var organization models.Organization
 	organization.ID = data.ID
+	organization.ExternalID = data.ExternalID
 	organization.Name = data.Name
 
-	newOrganization, err := o.service.Create(&organization)
+	newOrganization, err := o.service.Create(r.Context(), &organization)
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
@@ -83,12 +150,7 @@ func (o *organizationHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (o *organizationHandler) Get(w http.ResponseWriter, r *http.Request) {
-	id, err := param.Int32(r, "id")
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
-		return
-	}
-	organization, err := o.service.Find(id)
+	organization, err := o.service.FindByIdentifier(chi.URLParam(r, "id"))
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(404, "organization not found", err))
 		return
@@ -99,18 +161,26 @@ func (o *organizationHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Update creates or replaces the organization identified by the id url
+// parameter, its id or its external id, responding 201 when it was created
+// and 200 when it already existed, so retried requests are idempotent. Only
+// superadmins with the admin scope create organizations, other callers get
+// 404 for missing ones.
 func (o *organizationHandler) Update(w http.ResponseWriter, r *http.Request) {
-	id, err := param.Int32(r, "id")
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
-		return
-	}
-
+	ctx := r.Context()
 	data := &OrganizationPayload{}
 	if err := render.Bind(r, data); err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
+	// the id or the external id is taken from the url
+	id, externalID := utils.ParseID(chi.URLParam(r, "id"))
+	if externalID != "" {
+		data.ID = 0
+		data.ExternalID = externalID
+	} else {
+		data.ID = id
+	}
 
 	validationErrors := data.validate()
 
@@ -119,17 +189,43 @@ func (o *organizationHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	organization, err := o.service.Find(id)
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(404, "organization not found", err))
+	var organization *models.Organization
+	var created bool
+	var err error
+	if caller, ok := auth.FromContext(ctx); ok && caller.IsSuperAdmin() && caller.HasScope(auth.ScopeAdmin) {
+		organization, created, err = o.service.FirstOrCreate(ctx, &models.Organization{
+			ID:         data.ID,
+			ExternalID: data.ExternalID,
+			Name:       data.Name,
+		})
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(err))
+			return
+		}
+	} else {
+		organization, err = o.service.FindByIdentifier(chi.URLParam(r, "id"))
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(404, "organization not found", err))
+			return
+		}
+	}
+	if created {
+		render.Status(r, http.StatusCreated)
+		_ = render.Render(w, r, NewOrganizationResponse(organization))
 		return
 	}
 
-	organization.Name = data.Name
-	organization, err = o.service.Update(organization)
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(err))
-		return
+	// an omitted external id keeps the external id of the organization
+	if organization.Name != data.Name || (data.ExternalID != "" && organization.ExternalID != data.ExternalID) {
+		organization.Name = data.Name
+		if data.ExternalID != "" {
+			organization.ExternalID = data.ExternalID
+		}
+		organization, err = o.service.Update(ctx, organization)
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(err))
+			return
+		}
 	}
 	if err := render.Render(w, r, NewOrganizationResponse(organization)); err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
@@ -138,17 +234,12 @@ func (o *organizationHandler) Update(w http.ResponseWriter, r *http.Request) {
 }
 
 func (o *organizationHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id, err := param.Int32(r, "id")
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
-		return
-	}
-	organization, err := o.service.Find(id)
+	organization, err := o.service.FindByIdentifier(chi.URLParam(r, "id"))
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(404, "organization not found", err))
 		return
 	}
-	err = o.service.Delete(organization)
+	err = o.service.Delete(r.Context(), organization)
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
@@ -3,16 +3,19 @@ package organizations
 import (
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/go-chi/render"
 	"gopkg.in/thedevsaddam/govalidator.v1"
 
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils"
 )
 
 type userResponse struct {
-	ID    int32  `json:"id"`
-	Email string `json:"email"`
+	ID         int32  `json:"id"`
+	ExternalID string `json:"external_id,omitempty"`
+	Email      string `json:"email"`
 }
 
 func (u *userResponse) Render(w http.ResponseWriter, r *http.Request) error {
@@ -20,13 +23,16 @@ func (u *userResponse) Render(w http.ResponseWriter, r *http.Request) error {
 }
 
 func NewUserResponse(user *models.User) *userResponse {
-	resp := &userResponse{ID: user.ID, Email: user.Email}
+	resp := &userResponse{ID: user.ID, ExternalID: user.ExternalID, Email: user.Email}
 	return resp
 }
 
+// OrganizationPayload identifies an organization by its id, its external id
+// or both
 type OrganizationPayload struct {
-	ID   int32  `json:"id"`
-	Name string `json:"name"`
+	ID         int32  `json:"id"`
+	ExternalID string `json:"external_id"`
+	Name       string `json:"name"`
 }
 
 func (o *OrganizationPayload) Bind(r *http.Request) error {
@@ -35,8 +41,8 @@ func (o *OrganizationPayload) Bind(r *http.Request) error {
 
 func (o *OrganizationPayload) validate() url.Values {
 	rules := govalidator.MapData{
-		"id":   []string{"required"},
-		"name": []string{"required", "min:4", "max:20"},
+		"external_id": []string{"max:255"},
+		"name":        []string{"required", "min:4", "max:20"},
 	}
 	opts := govalidator.Options{
 		Data:  o,
@@ -45,13 +51,26 @@ func (o *OrganizationPayload) validate() url.Values {
 
 	v := govalidator.New(opts)
 	e := v.ValidateStruct()
+	if o.ID < 0 {
+		e.Add("id", "The id field must be a positive integer")
+	}
+	if o.ID == 0 && o.ExternalID == "" {
+		e.Add("id", "The id field is required when external_id is missing")
+	}
+	if o.ExternalID != "" {
+		if _, externalID := utils.ParseID(o.ExternalID); externalID == "" {
+			e.Add("external_id", "The external_id field must not be a positive integer")
+		}
+	}
 	return e
 }
 
 type OrganizationResponse struct {
-	ID    int32           `json:"id"`
-	Name  string          `json:"name"`
-	Users []*userResponse `json:"users"`
+	ID         int32           `json:"id"`
+	ExternalID string          `json:"external_id,omitempty"`
+	Name       string          `json:"name"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Users      []*userResponse `json:"users"`
 }
 
 func (o *OrganizationResponse) Render(w http.ResponseWriter, r *http.Request) error {
@@ -67,9 +86,11 @@ func NewOrganizationResponse(organization *models.Organization) *OrganizationRes
 		list = append(list, NewUserResponse(user))
 	}
 	resp := &OrganizationResponse{
-		ID:    organization.ID,
-		Name:  organization.Name,
-		Users: list,
+		ID:         organization.ID,
+		ExternalID: organization.ExternalID,
+		Name:       organization.Name,
+		CreatedAt:  organization.CreatedAt,
+		Users:      list,
 	}
 	return resp
 }
@@ -81,3 +102,14 @@ func NewOrganizationListResponse(organizations []*models.Organization) []render.
 	}
 	return list
 }
+
+// organizationSortValue returns the value of the sort field of organization
+func organizationSortValue(organization *models.Organization, sort string) interface{} {
+	switch sort {
+	case "name":
+		return organization.Name
+	case "created_at":
+		return organization.CreatedAt
+	}
+	return organization.ID
+}
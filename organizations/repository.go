@@ -4,16 +4,30 @@ import (
 	"errors"
 	"github.com/go-pg/pg/v9"
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/pagination"
 )
 
+// sortColumns maps the sortable fields of organizations to their columns
+var sortColumns = map[string]string{
+	"id":         `"organization".id`,
+	"name":       `"organization".name`,
+	"created_at": `"organization".created_at`,
+}
+
 type Repository interface {
-	List() ([]*models.Organization, error)
+	List(params *pagination.Params) ([]*models.Organization, error)
 	Find(id int32) (*models.Organization, error)
+	FindByExternalId(externalId string) (*models.Organization, error)
 	Create(tx *pg.Tx, organization *models.Organization) (*models.Organization, error)
-	FirstOrCreate(tx *pg.Tx, organization *models.Organization) (*models.Organization, error)
+	// FirstOrCreate inserts the organization unless an organization with its
+	// id exists, which is returned instead, created reports whether the
+	// organization was inserted. An organization without id is matched by
+	// its external id.
+	FirstOrCreate(tx *pg.Tx, organization *models.Organization) (o *models.Organization, created bool, err error)
 	Update(tx *pg.Tx, organization *models.Organization) (*models.Organization, error)
 	Delete(tx *pg.Tx, organization *models.Organization) error
 	Exists(ID int32) bool
+	ExternalIdExists(externalId string) bool
 	FindUsersByIds(organization *models.Organization, ids []int32) ([]*models.User, error)
 	FindPermissionsByIds(organization *models.Organization, ids []int32) ([]*models.Permission, error)
 }
@@ -30,9 +44,16 @@ func NewOrganizationRepository(db *pg.DB) Repository {
 	}
 }
 
-func (o *organizationRepository) List() ([]*models.Organization, error) {
+func (o *organizationRepository) List(params *pagination.Params) ([]*models.Organization, error) {
 	var organizations []*models.Organization
-	err := o.db.Model(&organizations).Relation("Users").Select()
+	q := o.db.Model(&organizations)
+	if params.Name != "" {
+		q = q.Where(`"organization".name ILIKE ?`, pagination.Prefix(params.Name))
+	}
+	if !params.CreatedAfter.IsZero() {
+		q = q.Where(`"organization".created_at > ?`, params.CreatedAfter)
+	}
+	err := params.Apply(q, sortColumns[params.Sort], sortColumns["id"]).Select()
 	return organizations, err
 }
 
@@ -45,14 +66,41 @@ func (o *organizationRepository) Find(id int32) (*models.Organization, error) {
 	return organization, err
 }
 
+func (o *organizationRepository) FindByExternalId(externalId string) (*models.Organization, error) {
+	organization := new(models.Organization)
+	err := o.db.Model(organization).Where("external_id = ?", externalId).Relation("Users").Select()
+	return organization, err
+}
+
 func (o *organizationRepository) Create(tx *pg.Tx, organization *models.Organization) (*models.Organization, error) {
 	err := o.db.Insert(organization)
 	return organization, err
 	//tx.Insert(organization)
 }
 
-func (o *organizationRepository) FirstOrCreate(tx *pg.Tx, organization *models.Organization) (*models.Organization, error) {
-	panic("implement me")
+func (o *organizationRepository) FirstOrCreate(tx *pg.Tx, organization *models.Organization) (*models.Organization, bool, error) {
+	// an insert racing with a concurrent insert of the id waits for it to
+	// commit and inserts nothing, the select then sees the committed organization
+	conflict := "(id) DO NOTHING"
+	if organization.ID == 0 {
+		conflict = "(external_id) DO NOTHING"
+	}
+	res, err := tx.Model(organization).OnConflict(conflict).Returning("*").Insert()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, false, err
+	}
+	if err == nil && res.RowsAffected() == 1 {
+		return organization, true, nil
+	}
+	existing := new(models.Organization)
+	q := tx.Model(existing).Relation("Users")
+	if organization.ID == 0 {
+		q = q.Where("external_id = ?", organization.ExternalID)
+	} else {
+		q = q.Where("id = ?", organization.ID)
+	}
+	err = q.Select()
+	return existing, false, err
 }
 
 func (o *organizationRepository) Update(tx *pg.Tx, organization *models.Organization) (*models.Organization, error) {
@@ -74,11 +122,20 @@ func (o *organizationRepository) Exists(id int32) bool {
 	return num == id
 }
 
+func (o *organizationRepository) ExternalIdExists(externalId string) bool {
+	exists, err := o.db.Model((*models.Organization)(nil)).Where("external_id = ?", externalId).Exists()
+	if err != nil {
+		panic(err)
+	}
+	return exists
+}
+
 func (o *organizationRepository) FindUsersByIds(organization *models.Organization, ids []int32) ([]*models.User, error) {
 	var users []*models.User
 	err := o.db.Model(&users).
 		Where("id in (?)", pg.In(ids)).
-		Where("organization_id = ? ", organization.ID).
+		// members of the organization are found along its own users
+		Where(`(organization_id = ?0 OR id IN (SELECT user_id FROM organization_members WHERE organization_id = ?0))`, organization.ID).
 		Select()
 	return users, err
 }
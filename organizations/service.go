@@ -1,68 +1,181 @@
 package organizations
 
 import (
+	"context"
+
 	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/audit"
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/permissions"
+	"github.com/imtanmoy/authz/utils"
+	"github.com/imtanmoy/authz/utils/pagination"
 )
 
 type Service interface {
-	List() ([]*models.Organization, error)
+	List(params *pagination.Params) ([]*models.Organization, error)
 	Find(id int32) (*models.Organization, error)
-	Create(organization *models.Organization) (*models.Organization, error)
-	FirstOrCreate(organization *models.Organization) (*models.Organization, error)
-	Update(organization *models.Organization) (*models.Organization, error)
-	Delete(organization *models.Organization) error
+	// FindByIdentifier finds the organization by its internal id or its
+	// external id, see utils.ParseID
+	FindByIdentifier(identifier string) (*models.Organization, error)
+	Create(ctx context.Context, organization *models.Organization) (*models.Organization, error)
+	// FirstOrCreate creates the organization unless an organization with its
+	// id exists, which is returned instead, created reports whether the
+	// organization was created. An organization without id is matched by its
+	// external id.
+	FirstOrCreate(ctx context.Context, organization *models.Organization) (o *models.Organization, created bool, err error)
+	Update(ctx context.Context, organization *models.Organization) (*models.Organization, error)
+	Delete(ctx context.Context, organization *models.Organization) error
 	Exists(id int32) bool
+	ExternalIdExists(externalId string) bool
 	FindUsersByIds(organization *models.Organization, ids []int32) ([]*models.User, error)
 	FindPermissionsByIds(organization *models.Organization, ids []int32) ([]*models.Permission, error)
 }
 
 type organizationService struct {
-	db         *pg.DB
-	repository Repository
+	db                *pg.DB
+	repository        Repository
+	permissionService permissions.Service
+	auditService      audit.Service
 }
 
 var _ Service = (*organizationService)(nil)
 
-func NewOrganizationService(db *pg.DB) Service {
+func NewOrganizationService(db *pg.DB, permissionService permissions.Service, auditService audit.Service) Service {
 	return &organizationService{
-		repository: NewOrganizationRepository(db),
-		db:         db,
+		repository:        NewOrganizationRepository(db),
+		db:                db,
+		permissionService: permissionService,
+		auditService:      auditService,
 	}
 }
 
-func (o *organizationService) List() ([]*models.Organization, error) {
-	return o.repository.List()
+func (o *organizationService) List(params *pagination.Params) ([]*models.Organization, error) {
+	return o.repository.List(params)
 }
 
 func (o *organizationService) Exists(id int32) bool {
 	return o.repository.Exists(id)
 }
 
+func (o *organizationService) ExternalIdExists(externalId string) bool {
+	return o.repository.ExternalIdExists(externalId)
+}
+
 func (o *organizationService) Find(id int32) (*models.Organization, error) {
 	return o.repository.Find(id)
 }
 
-func (o *organizationService) Create(organization *models.Organization) (*models.Organization, error) {
+func (o *organizationService) FindByIdentifier(identifier string) (*models.Organization, error) {
+	id, externalId := utils.ParseID(identifier)
+	if externalId != "" {
+		return o.repository.FindByExternalId(externalId)
+	}
+	return o.repository.Find(id)
+}
+
+func (o *organizationService) Create(ctx context.Context, organization *models.Organization) (*models.Organization, error) {
 	tx, _ := o.db.Begin()
-	defer tx.Commit()
-	return o.repository.Create(tx, organization)
+	organization, err := o.repository.Create(tx, organization)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = o.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: organization.ID,
+		Action:         audit.OrganizationCreated,
+		TargetType:     audit.TargetOrganization,
+		TargetID:       organization.ID,
+		After:          auditOrganization(organization),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	// reserve the system permissions guarding the management api
+	_, err = o.permissionService.Bootstrap(organization)
+	return organization, err
 }
 
-func (o *organizationService) FirstOrCreate(organization *models.Organization) (*models.Organization, error) {
-	panic("implement me")
+func (o *organizationService) FirstOrCreate(ctx context.Context, organization *models.Organization) (*models.Organization, bool, error) {
+	tx, _ := o.db.Begin()
+	organization, created, err := o.repository.FirstOrCreate(tx, organization)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, false, err
+	}
+	if !created {
+		if err := tx.Commit(); err != nil {
+			return nil, false, err
+		}
+		return organization, false, nil
+	}
+	err = o.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: organization.ID,
+		Action:         audit.OrganizationCreated,
+		TargetType:     audit.TargetOrganization,
+		TargetID:       organization.ID,
+		After:          auditOrganization(organization),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	// reserve the system permissions guarding the management api
+	_, err = o.permissionService.Bootstrap(organization)
+	return organization, true, err
 }
 
-func (o *organizationService) Update(organization *models.Organization) (*models.Organization, error) {
+func (o *organizationService) Update(ctx context.Context, organization *models.Organization) (*models.Organization, error) {
+	before, err := o.repository.Find(organization.ID)
+	if err != nil {
+		return nil, err
+	}
 	tx, _ := o.db.Begin()
-	defer tx.Commit()
-	return o.repository.Update(tx, organization)
+	organization, err = o.repository.Update(tx, organization)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = o.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: organization.ID,
+		Action:         audit.OrganizationUpdated,
+		TargetType:     audit.TargetOrganization,
+		TargetID:       organization.ID,
+		Before:         auditOrganization(before),
+		After:          auditOrganization(organization),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return organization, tx.Commit()
 }
 
-func (o *organizationService) Delete(organization *models.Organization) error {
+func (o *organizationService) Delete(ctx context.Context, organization *models.Organization) error {
 	tx, _ := o.db.Begin()
-	defer tx.Commit()
-	return o.repository.Delete(tx, organization)
+	err := o.repository.Delete(tx, organization)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	err = o.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: organization.ID,
+		Action:         audit.OrganizationDeleted,
+		TargetType:     audit.TargetOrganization,
+		TargetID:       organization.ID,
+		Before:         auditOrganization(organization),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 func (o *organizationService) FindUsersByIds(organization *models.Organization, ids []int32) ([]*models.User, error) {
@@ -72,3 +185,8 @@ func (o *organizationService) FindUsersByIds(organization *models.Organization,
 func (o *organizationService) FindPermissionsByIds(organization *models.Organization, ids []int32) ([]*models.Permission, error) {
 	return o.repository.FindPermissionsByIds(organization, ids)
 }
+
+// auditOrganization returns the audited state of the organization
+func auditOrganization(organization *models.Organization) map[string]interface{} {
+	return map[string]interface{}{"name": organization.Name, "external_id": organization.ExternalID}
+}
@@ -0,0 +1,177 @@
+// Package outbox publishes the change events written to the outbox table in
+// the transaction of their change, delivering them at least once to sinks.
+package outbox
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/logger"
+	"github.com/imtanmoy/authz/models"
+)
+
+const (
+	// batchSize is the number of events claimed at once
+	batchSize = 100
+	// lease is how long claimed events are hidden from other dispatchers
+	lease = 5 * time.Minute
+	// pollInterval is how often the outbox is checked without notification
+	pollInterval = 5 * time.Second
+
+	// initialBackoff is the delay before the first retry of an event, doubled
+	// on every following retry up to maxBackoff
+	initialBackoff = time.Second
+	maxBackoff     = time.Hour
+
+	// retention is how long published events are kept
+	retention = 7 * 24 * time.Hour
+	// cleanupInterval is how often published events are deleted
+	cleanupInterval = time.Hour
+)
+
+// Dispatcher publishes the events of the outbox. An event is published to
+// every sink before it is marked published, events failing in any sink are
+// retried for the failing sinks only. Sinks are told apart by their position,
+// so sinks may be appended but not reordered while events are pending. An
+// event is delivered again when its lease expires before the outcome is
+// saved, so sinks must still tolerate duplicates. Events are published in
+// order unless they are retried.
+type Dispatcher interface {
+	// Run publishes pending events until ctx is done
+	Run(ctx context.Context)
+}
+
+type dispatcher struct {
+	db         *pg.DB
+	repository Repository
+	sinks      []events.Publisher
+	log        logger.Logger
+}
+
+var _ Dispatcher = (*dispatcher)(nil)
+
+// NewDispatcher creates a dispatcher publishing to sinks, failures are
+// reported to log
+func NewDispatcher(db *pg.DB, log logger.Logger, sinks ...events.Publisher) Dispatcher {
+	return &dispatcher{
+		db:         db,
+		repository: NewOutboxRepository(db),
+		sinks:      sinks,
+		log:        log,
+	}
+}
+
+func (d *dispatcher) Run(ctx context.Context) {
+	listener := d.db.Listen(channel)
+	defer listener.Close()
+	notifications := listener.Channel()
+
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		// full batches are followed by another one without waiting
+		if d.dispatch(ctx) == batchSize && ctx.Err() == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-notifications:
+		case <-poll.C:
+		case <-cleanup.C:
+			if n, err := d.repository.DeletePublished(time.Now().Add(-retention)); err != nil {
+				d.errorf("published events could not be deleted : %s", err)
+			} else if n > 0 && d.log != nil {
+				d.log.Infof("%d published events deleted", n)
+			}
+		}
+	}
+}
+
+// dispatch publishes one batch of events and returns its size
+func (d *dispatcher) dispatch(ctx context.Context) int {
+	entries, err := d.repository.Claim(batchSize, lease)
+	if err != nil {
+		d.errorf("outbox events could not be claimed : %s", err)
+		return 0
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			// the lease expires and the rest of the batch is claimed again
+			break
+		}
+		if err := d.publish(ctx, entry); err != nil {
+			if err := d.repository.Reschedule(entry, backoff(entry.Attempts), err.Error()); err != nil {
+				d.errorf("event %s could not be rescheduled : %s", entry.EventID, err)
+			}
+			continue
+		}
+		if err := d.repository.MarkPublished(entry); err != nil {
+			d.errorf("event %s could not be marked published : %s", entry.EventID, err)
+		}
+	}
+	return len(entries)
+}
+
+func (d *dispatcher) publish(ctx context.Context, entry *models.OutboxEvent) error {
+	event := &events.Event{
+		ID:             entry.EventID,
+		Type:           entry.EventType,
+		OrganizationID: entry.OrganizationID,
+		OccurredAt:     entry.CreatedAt,
+		Data:           entry.Data,
+	}
+	// every sink is attempted, so one failing sink does not hold back others
+	var failed error
+	for i, sink := range d.sinks {
+		if published(entry, i) {
+			continue
+		}
+		if err := sink.Publish(ctx, event); err != nil {
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+		entry.PublishedSinks = append(entry.PublishedSinks, i)
+	}
+	return failed
+}
+
+// published reports whether the event was published to the sink at position
+func published(entry *models.OutboxEvent, position int) bool {
+	for _, p := range entry.PublishedSinks {
+		if p == position {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *dispatcher) errorf(format string, args ...interface{}) {
+	if d.log != nil {
+		d.log.Errorf(format, args...)
+	}
+}
+
+// backoff returns the delay before the retry following attempt
+func backoff(attempt int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,95 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/models"
+)
+
+// channel is notified when events are added to the outbox
+const channel = "outbox"
+
+type Repository interface {
+	// Add stores the event within tx, the dispatcher is notified once tx commits
+	Add(tx *pg.Tx, event *events.Event) error
+	// Claim leases up to limit pending events for lease and counts the attempt,
+	// events which are neither published nor rescheduled before the lease
+	// expires are claimed again
+	Claim(limit int, lease time.Duration) ([]*models.OutboxEvent, error)
+	MarkPublished(entry *models.OutboxEvent) error
+	// Reschedule makes the event available again after delay and saves the
+	// sinks it was published to
+	Reschedule(entry *models.OutboxEvent, delay time.Duration, reason string) error
+	// DeletePublished removes the events published before t
+	DeletePublished(t time.Time) (int, error)
+}
+
+type outboxRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*outboxRepository)(nil)
+
+func NewOutboxRepository(db *pg.DB) Repository {
+	return &outboxRepository{
+		db,
+	}
+}
+
+func (o *outboxRepository) Add(tx *pg.Tx, event *events.Event) error {
+	entry := &models.OutboxEvent{
+		EventID:        event.ID,
+		EventType:      event.Type,
+		OrganizationID: event.OrganizationID,
+		Data:           event.Data,
+		CreatedAt:      event.OccurredAt,
+	}
+	if _, err := tx.Model(entry).Insert(); err != nil {
+		return err
+	}
+	_, err := tx.Exec("NOTIFY ?", pg.Ident(channel))
+	return err
+}
+
+func (o *outboxRepository) Claim(limit int, lease time.Duration) ([]*models.OutboxEvent, error) {
+	var entries []*models.OutboxEvent
+	_, err := o.db.Query(&entries, `
+		UPDATE outbox SET available_at = now() + ? * interval '1 millisecond', attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE published_at IS NULL AND available_at <= now()
+			ORDER BY id LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, lease.Milliseconds(), limit)
+	return entries, err
+}
+
+func (o *outboxRepository) MarkPublished(entry *models.OutboxEvent) error {
+	_, err := o.db.Model(entry).
+		Set("published_at = now()").
+		Set("last_error = NULL").
+		Where("id = ?id").
+		Update()
+	return err
+}
+
+func (o *outboxRepository) Reschedule(entry *models.OutboxEvent, delay time.Duration, reason string) error {
+	_, err := o.db.Model(entry).
+		Set("available_at = now() + ? * interval '1 millisecond'", delay.Milliseconds()).
+		Set("last_error = ?", reason).
+		Set("published_sinks = coalesce(?published_sinks, '{}'::smallint[])").
+		Where("id = ?id").
+		Update()
+	return err
+}
+
+func (o *outboxRepository) DeletePublished(t time.Time) (int, error) {
+	res, err := o.db.Exec("DELETE FROM outbox WHERE published_at < ?", t)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,66 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/imtanmoy/authz/events"
+)
+
+// natsFlushTimeout bounds the wait for the NATS server to acknowledge a flush
+const natsFlushTimeout = 5 * time.Second
+
+// ChannelSink hands events to in-process consumers
+type ChannelSink struct {
+	events chan *events.Event
+}
+
+var _ events.Publisher = (*ChannelSink)(nil)
+
+// NewChannelSink creates a sink buffering size events, publishing blocks
+// while the buffer is full
+func NewChannelSink(size int) *ChannelSink {
+	return &ChannelSink{events: make(chan *events.Event, size)}
+}
+
+// Publish waits until the event is buffered or ctx is done
+func (c *ChannelSink) Publish(ctx context.Context, event *events.Event) error {
+	select {
+	case c.events <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Events returns the channel the published events are received from
+func (c *ChannelSink) Events() <-chan *events.Event {
+	return c.events
+}
+
+// natsSink publishes events to NATS subjects named after their type
+type natsSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATSSink creates a sink publishing events as JSON to the subject
+// "<subject>.<event type>", such as "authz.group.member_added"
+func NewNATSSink(conn *nats.Conn, subject string) events.Publisher {
+	return &natsSink{conn: conn, subject: subject}
+}
+
+// Publish returns once the NATS server received the event
+func (n *natsSink) Publish(ctx context.Context, event *events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if err := n.conn.Publish(n.subject+"."+event.Type, data); err != nil {
+		return err
+	}
+	return n.conn.FlushTimeout(natsFlushTimeout)
+}
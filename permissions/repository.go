@@ -7,6 +7,8 @@ import (
 
 type Repository interface {
 	FindAllByIdIn(ids []int32) []*models.Permission
+	FindByName(organizationId int32, name string) (*models.Permission, error)
+	Create(permission *models.Permission) (*models.Permission, error)
 }
 
 type permissionRepository struct {
@@ -28,3 +30,17 @@ func (p *permissionRepository) FindAllByIdIn(ids []int32) []*models.Permission {
 		Select()
 	return permissions
 }
+
+func (p *permissionRepository) FindByName(organizationId int32, name string) (*models.Permission, error) {
+	var permission models.Permission
+	err := p.db.Model(&permission).
+		Where("name = ?", name).
+		Where("organization_id = ?", organizationId).
+		First()
+	return &permission, err
+}
+
+func (p *permissionRepository) Create(permission *models.Permission) (*models.Permission, error) {
+	_, err := p.db.Model(permission).Returning("*").Insert()
+	return permission, err
+}
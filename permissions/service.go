@@ -1,12 +1,46 @@
 package permissions
 
 import (
+	"errors"
+
 	"github.com/go-pg/pg/v9"
 	"github.com/imtanmoy/authz/models"
 )
 
+// System permissions guard the authz management api itself, they are
+// bootstrapped for every organization and granted through groups
+const (
+	OrganizationsWrite   = "authz.organizations.write"
+	UsersWrite           = "authz.users.write"
+	GroupsWrite          = "authz.groups.write"
+	APIKeysWrite         = "authz.api_keys.write"
+	AuditRead            = "authz.audit.read"
+	WebhooksWrite        = "authz.webhooks.write"
+	ServiceAccountsWrite = "authz.service_accounts.write"
+)
+
+// SystemType is the type of reserved system permissions
+const SystemType = "system"
+
+// SystemAction is the action of reserved system permissions
+const SystemAction = "ALL"
+
+// SystemPermissions lists the reserved permissions of every organization
+var SystemPermissions = []string{
+	OrganizationsWrite,
+	UsersWrite,
+	GroupsWrite,
+	APIKeysWrite,
+	AuditRead,
+	WebhooksWrite,
+	ServiceAccountsWrite,
+}
+
 type Service interface {
 	FindAllByIdIn(ids []int32) []*models.Permission
+	FindByName(organization *models.Organization, name string) (*models.Permission, error)
+	// Bootstrap creates the missing system permissions of the organization
+	Bootstrap(organization *models.Organization) ([]*models.Permission, error)
 }
 
 type permissionService struct {
@@ -26,3 +60,27 @@ func NewPermissionService(db *pg.DB) Service {
 func (p *permissionService) FindAllByIdIn(ids []int32) []*models.Permission {
 	return p.repository.FindAllByIdIn(ids)
 }
+
+func (p *permissionService) FindByName(organization *models.Organization, name string) (*models.Permission, error) {
+	return p.repository.FindByName(organization.ID, name)
+}
+
+func (p *permissionService) Bootstrap(organization *models.Organization) ([]*models.Permission, error) {
+	list := make([]*models.Permission, 0, len(SystemPermissions))
+	for _, name := range SystemPermissions {
+		permission, err := p.repository.FindByName(organization.ID, name)
+		if errors.Is(err, pg.ErrNoRows) {
+			permission, err = p.repository.Create(&models.Permission{
+				Name:           name,
+				OrganizationID: organization.ID,
+				Action:         SystemAction,
+				Type:           SystemType,
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, permission)
+	}
+	return list, nil
+}
@@ -0,0 +1,371 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imtanmoy/authz/utils/pagination"
+)
+
+// attribute kinds
+const (
+	stringAttribute = iota
+	idAttribute
+	timeAttribute
+)
+
+// attribute maps a filterable SCIM attribute to SQL
+type attribute struct {
+	column string
+	kind   int
+	// condition overrides the column comparison for attributes which are
+	// not stored in a column
+	condition func(op string, value interface{}) (string, []interface{}, error)
+}
+
+// expression is a node of a parsed filter
+type expression interface {
+	sql(attributes map[string]attribute) (string, []interface{}, error)
+}
+
+type logical struct {
+	op          string
+	left, right expression
+}
+
+func (l *logical) sql(attributes map[string]attribute) (string, []interface{}, error) {
+	left, leftArgs, err := l.left.sql(attributes)
+	if err != nil {
+		return "", nil, err
+	}
+	right, rightArgs, err := l.right.sql(attributes)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(l.op), right), append(leftArgs, rightArgs...), nil
+}
+
+type negation struct {
+	expr expression
+}
+
+func (n *negation) sql(attributes map[string]attribute) (string, []interface{}, error) {
+	s, args, err := n.expr.sql(attributes)
+	if err != nil {
+		return "", nil, err
+	}
+	return "NOT " + s, args, nil
+}
+
+type comparison struct {
+	attr  string
+	op    string
+	value interface{}
+}
+
+func (c *comparison) sql(attributes map[string]attribute) (string, []interface{}, error) {
+	a, ok := attributes[c.attr]
+	if !ok {
+		return "", nil, invalidFilter("attribute %q can not be filtered", c.attr)
+	}
+	if a.condition != nil {
+		return a.condition(c.op, c.value)
+	}
+	if c.op == "pr" {
+		return a.column + " IS NOT NULL", nil, nil
+	}
+	if c.value == nil {
+		switch c.op {
+		case "eq":
+			return a.column + " IS NULL", nil, nil
+		case "ne":
+			return a.column + " IS NOT NULL", nil, nil
+		}
+		return "", nil, invalidFilter("null can only be compared with eq and ne")
+	}
+	s, ok := c.value.(string)
+	if !ok {
+		return "", nil, invalidFilter("attribute %q must be compared with a string", c.attr)
+	}
+
+	switch a.kind {
+	case idAttribute:
+		id, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			// ids are numeric, other values match no resource
+			return "FALSE", nil, nil
+		}
+		if op, ok := orderings[c.op]; ok {
+			return a.column + " " + op + " ?", []interface{}{id}, nil
+		}
+	case timeAttribute:
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "", nil, invalidFilter("attribute %q must be compared with a RFC3339 timestamp", c.attr)
+		}
+		if op, ok := orderings[c.op]; ok {
+			return a.column + " " + op + " ?", []interface{}{t.UTC()}, nil
+		}
+	default:
+		// string attributes of users and groups are case insensitive
+		switch c.op {
+		case "eq":
+			return "lower(" + a.column + ") = lower(?)", []interface{}{s}, nil
+		case "ne":
+			return "lower(" + a.column + ") <> lower(?)", []interface{}{s}, nil
+		case "co":
+			return a.column + " ILIKE ?", []interface{}{pagination.Contains(s)}, nil
+		case "sw":
+			return a.column + " ILIKE ?", []interface{}{pagination.Prefix(s)}, nil
+		case "ew":
+			return a.column + " ILIKE ?", []interface{}{pagination.Suffix(s)}, nil
+		}
+		if op, ok := orderings[c.op]; ok {
+			return "lower(" + a.column + ") " + op + " lower(?)", []interface{}{s}, nil
+		}
+	}
+	return "", nil, invalidFilter("operator %q is not supported for attribute %q", c.op, c.attr)
+}
+
+// orderings maps the comparison operators to SQL
+var orderings = map[string]string{
+	"eq": "=",
+	"ne": "<>",
+	"gt": ">",
+	"ge": ">=",
+	"lt": "<",
+	"le": "<=",
+}
+
+// operators lists the comparison operators of filters
+var operators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+// parseFilter parses a SCIM filter such as
+// `userName eq "bjensen" and meta.created gt "2020-01-01T00:00:00Z"`,
+// attribute names are kept in lower case without their schema
+func parseFilter(filter string) (expression, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr("")
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilter("unexpected %q", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+// token kinds
+const (
+	wordToken = iota
+	stringToken
+	punctToken
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '[' || c == ']':
+			tokens = append(tokens, token{punctToken, string(c)})
+			i++
+		case c == '"':
+			j := i + 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return nil, invalidFilter("unterminated string")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &value); err != nil {
+				return nil, invalidFilter("invalid string %s", s[i:j+1])
+			}
+			tokens = append(tokens, token{stringToken, value})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(s) && !strings.ContainsRune(" \t\n\r()[]\"", rune(s[j])); j++ {
+			}
+			tokens = append(tokens, token{wordToken, s[i:j]})
+			i = j
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, invalidFilter("empty filter")
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t != nil && t.kind == wordToken && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) punct(c string) bool {
+	t := p.peek()
+	if t != nil && t.kind == punctToken && t.text == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// parseOr parses disjunctions, prefix is prepended to attribute names
+// within value filters such as members[value eq "1"]
+func (p *parser) parseOr(prefix string) (expression, error) {
+	left, err := p.parseAnd(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd(prefix)
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd(prefix string) (expression, error) {
+	left, err := p.parseTerm(prefix)
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseTerm(prefix)
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseTerm(prefix string) (expression, error) {
+	if p.keyword("not") {
+		if !p.punct("(") {
+			return nil, invalidFilter("not must be followed by (")
+		}
+		expr, err := p.parseGroup(prefix)
+		if err != nil {
+			return nil, err
+		}
+		return &negation{expr: expr}, nil
+	}
+	if p.punct("(") {
+		return p.parseGroup(prefix)
+	}
+
+	t := p.peek()
+	if t == nil || t.kind != wordToken {
+		return nil, invalidFilter("attribute expected")
+	}
+	p.pos++
+	attr := prefix + attributeName(t.text)
+	if p.punct("[") {
+		expr, err := p.parseOr(attr + ".")
+		if err != nil {
+			return nil, err
+		}
+		if !p.punct("]") {
+			return nil, invalidFilter("missing ]")
+		}
+		return expr, nil
+	}
+
+	op := p.peek()
+	if op == nil || op.kind != wordToken {
+		return nil, invalidFilter("operator expected after %q", t.text)
+	}
+	p.pos++
+	name := strings.ToLower(op.text)
+	if name == "pr" {
+		return &comparison{attr: attr, op: name}, nil
+	}
+	if !operators[name] {
+		return nil, invalidFilter("unknown operator %q", op.text)
+	}
+
+	v := p.peek()
+	if v == nil || v.kind == punctToken {
+		return nil, invalidFilter("value expected after %q", op.text)
+	}
+	p.pos++
+	c := &comparison{attr: attr, op: name}
+	if v.kind == stringToken {
+		c.value = v.text
+		return c, nil
+	}
+	switch strings.ToLower(v.text) {
+	case "null":
+	case "true":
+		c.value = true
+	case "false":
+		c.value = false
+	default:
+		// numbers are compared like the strings of ids
+		if _, err := strconv.ParseFloat(v.text, 64); err != nil {
+			return nil, invalidFilter("invalid value %q", v.text)
+		}
+		c.value = v.text
+	}
+	return c, nil
+}
+
+func (p *parser) parseGroup(prefix string) (expression, error) {
+	expr, err := p.parseOr(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !p.punct(")") {
+		return nil, invalidFilter("missing )")
+	}
+	return expr, nil
+}
+
+// attributeName returns the lower case name of the attribute without the
+// schema urn it may be qualified with
+func attributeName(name string) string {
+	if strings.HasPrefix(strings.ToLower(name), "urn:") {
+		name = name[strings.LastIndex(name, ":")+1:]
+	}
+	return strings.ToLower(name)
+}
@@ -0,0 +1,185 @@
+package scim
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		filter string
+		sql    string
+		args   []interface{}
+	}{
+		// operators
+		{`userName eq "bjensen"`, `lower("user".email) = lower(?)`, []interface{}{"bjensen"}},
+		{`userName ne "bjensen"`, `lower("user".email) <> lower(?)`, []interface{}{"bjensen"}},
+		{`userName co "jen"`, `"user".email ILIKE ?`, []interface{}{"%jen%"}},
+		{`userName sw "bj"`, `"user".email ILIKE ?`, []interface{}{"bj%"}},
+		{`userName ew "sen"`, `"user".email ILIKE ?`, []interface{}{"%sen"}},
+		{`userName gt "b"`, `lower("user".email) > lower(?)`, []interface{}{"b"}},
+		{`userName ge "b"`, `lower("user".email) >= lower(?)`, []interface{}{"b"}},
+		{`userName lt "b"`, `lower("user".email) < lower(?)`, []interface{}{"b"}},
+		{`userName le "b"`, `lower("user".email) <= lower(?)`, []interface{}{"b"}},
+		{`externalId pr`, `"user".external_id IS NOT NULL`, nil},
+		{`externalId eq null`, `"user".external_id IS NULL`, nil},
+		{`externalId ne null`, `"user".external_id IS NOT NULL`, nil},
+		{`id eq "42"`, `"user".id = ?`, []interface{}{int64(42)}},
+		{`id eq 42`, `"user".id = ?`, []interface{}{int64(42)}},
+		{`id eq "abc"`, `FALSE`, nil},
+		{`meta.created gt "2020-01-01T00:00:00Z"`, `"user".created_at > ?`, []interface{}{created}},
+		// keywords, operators and attributes are case insensitive
+		{`USERNAME EQ "bjensen"`, `lower("user".email) = lower(?)`, []interface{}{"bjensen"}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName eq "bjensen"`, `lower("user".email) = lower(?)`, []interface{}{"bjensen"}},
+		// and, or, not
+		{
+			`userName eq "a" and externalId eq "b"`,
+			`(lower("user".email) = lower(?) AND lower("user".external_id) = lower(?))`,
+			[]interface{}{"a", "b"},
+		},
+		{
+			`userName eq "a" or externalId eq "b"`,
+			`(lower("user".email) = lower(?) OR lower("user".external_id) = lower(?))`,
+			[]interface{}{"a", "b"},
+		},
+		{
+			`userName eq "a" or userName eq "b" and externalId pr`,
+			`(lower("user".email) = lower(?) OR (lower("user".email) = lower(?) AND "user".external_id IS NOT NULL))`,
+			[]interface{}{"a", "b"},
+		},
+		{
+			`(userName eq "a" or userName eq "b") and externalId pr`,
+			`((lower("user".email) = lower(?) OR lower("user".email) = lower(?)) AND "user".external_id IS NOT NULL)`,
+			[]interface{}{"a", "b"},
+		},
+		{`not (userName eq "a")`, `NOT lower("user".email) = lower(?)`, []interface{}{"a"}},
+		{
+			`userName pr and not (externalId eq "b" or id eq "1")`,
+			`("user".email IS NOT NULL AND NOT (lower("user".external_id) = lower(?) OR "user".id = ?))`,
+			[]interface{}{"b", int64(1)},
+		},
+		// value filters
+		{`emails[value co "@example.com"]`, `"user".email ILIKE ?`, []interface{}{"%@example.com%"}},
+		// quoting
+		{`userName eq "a b"`, `lower("user".email) = lower(?)`, []interface{}{"a b"}},
+		{`userName eq "say \"hi\""`, `lower("user".email) = lower(?)`, []interface{}{`say "hi"`}},
+		{`userName eq "back\\slash"`, `lower("user".email) = lower(?)`, []interface{}{`back\slash`}},
+		{`userName eq "and or not ( ) [ ]"`, `lower("user".email) = lower(?)`, []interface{}{"and or not ( ) [ ]"}},
+		{`userName eq "é"`, `lower("user".email) = lower(?)`, []interface{}{"é"}},
+		{`userName co "50%_off"`, `"user".email ILIKE ?`, []interface{}{`%50\%\_off%`}},
+	} {
+		expr, err := parseFilter(tc.filter)
+		if err != nil {
+			t.Errorf("%s: %s", tc.filter, err)
+			continue
+		}
+		sql, args, err := expr.sql(userAttributes)
+		if err != nil {
+			t.Errorf("%s: %s", tc.filter, err)
+			continue
+		}
+		if sql != tc.sql {
+			t.Errorf("%s: sql %s, want %s", tc.filter, sql, tc.sql)
+		}
+		if !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("%s: args %#v, want %#v", tc.filter, args, tc.args)
+		}
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		detail string
+	}{
+		{``, "empty filter"},
+		{`   `, "empty filter"},
+		{`userName`, "operator expected"},
+		{`userName xx "a"`, `unknown operator "xx"`},
+		{`userName eq`, "value expected"},
+		{`userName eq )`, "value expected"},
+		{`userName eq bjensen`, "invalid value"},
+		{`userName eq "a`, "unterminated string"},
+		{`userName eq "a\x"`, "invalid string"},
+		{`userName eq "a" and`, "attribute expected"},
+		{`userName eq "a" or or userName eq "b"`, `unknown operator "userName"`},
+		{`userName eq "a" userName eq "b"`, `unexpected "userName"`},
+		{`(userName eq "a"`, "missing )"},
+		{`userName eq "a")`, `unexpected ")"`},
+		{`not userName eq "a"`, "not must be followed by ("},
+		{`emails[value eq "a"`, "missing ]"},
+		{`"userName" eq "a"`, "attribute expected"},
+	} {
+		_, err := parseFilter(tc.filter)
+		assertInvalidFilter(t, tc.filter, err, tc.detail)
+	}
+}
+
+func TestFilterAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		filter     string
+		attributes map[string]attribute
+		detail     string
+	}{
+		// unknown attributes are rejected, not ignored
+		{`password eq "secret"`, userAttributes, `attribute "password" can not be filtered`},
+		{`userName eq "a" or password pr`, userAttributes, `attribute "password" can not be filtered`},
+		{`not (name.givenName eq "a")`, userAttributes, `attribute "name.givenname" can not be filtered`},
+		{`emails[type eq "work"]`, userAttributes, `attribute "emails.type" can not be filtered`},
+		{`userName eq "a"`, groupAttributes, `attribute "username" can not be filtered`},
+		{`displayName eq "a"`, userAttributes, `attribute "displayname" can not be filtered`},
+		// values of the wrong kind
+		{`meta.created gt "yesterday"`, userAttributes, "RFC3339 timestamp"},
+		{`userName eq true`, userAttributes, "must be compared with a string"},
+		{`userName gt null`, userAttributes, "null can only be compared with eq and ne"},
+		{`id co "1"`, userAttributes, `operator "co" is not supported`},
+		{`meta.created sw "2020"`, userAttributes, "RFC3339 timestamp"},
+		{`members ne "1"`, groupAttributes, "members can only be compared with eq"},
+		{`members eq "abc"`, groupAttributes, "members can only be compared with eq"},
+	} {
+		expr, err := parseFilter(tc.filter)
+		if err != nil {
+			t.Errorf("%s: %s", tc.filter, err)
+			continue
+		}
+		_, _, err = expr.sql(tc.attributes)
+		assertInvalidFilter(t, tc.filter, err, tc.detail)
+	}
+}
+
+func TestFilterMembers(t *testing.T) {
+	expr, err := parseFilter(`members[value eq "7"] and displayName sw "eng"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sql, args, err := expr.sql(groupAttributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `(EXISTS (SELECT 1 FROM casbin_rules WHERE p_type = 'g' AND v0 = ? AND v1 = ? || "group".id) AND "group".name ILIKE ?)`
+	if sql != want {
+		t.Errorf("sql %s, want %s", sql, want)
+	}
+	if len(args) != 3 || args[2] != "eng%" {
+		t.Errorf("args %#v", args)
+	}
+}
+
+func assertInvalidFilter(t *testing.T, filter string, err error, detail string) {
+	t.Helper()
+	var scimErr *Error
+	if !errors.As(err, &scimErr) {
+		t.Errorf("%s: error %v, want an invalidFilter error", filter, err)
+		return
+	}
+	if scimErr.ScimType != "invalidFilter" {
+		t.Errorf("%s: scimType %q, want invalidFilter", filter, scimErr.ScimType)
+	}
+	if !strings.Contains(scimErr.Detail, detail) {
+		t.Errorf("%s: detail %q, want %q", filter, scimErr.Detail, detail)
+	}
+}
@@ -0,0 +1,478 @@
+package scim
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/models"
+)
+
+// basePath is the path of the SCIM endpoints below the organization
+const basePath = "/scim/v2"
+
+// Handler handles SCIM http method
+type Handler interface {
+	ServiceProviderConfig(w http.ResponseWriter, r *http.Request)
+	ResourceTypes(w http.ResponseWriter, r *http.Request)
+
+	ListUsers(w http.ResponseWriter, r *http.Request)
+	GetUser(w http.ResponseWriter, r *http.Request)
+	CreateUser(w http.ResponseWriter, r *http.Request)
+	ReplaceUser(w http.ResponseWriter, r *http.Request)
+	PatchUser(w http.ResponseWriter, r *http.Request)
+	DeleteUser(w http.ResponseWriter, r *http.Request)
+
+	ListGroups(w http.ResponseWriter, r *http.Request)
+	GetGroup(w http.ResponseWriter, r *http.Request)
+	CreateGroup(w http.ResponseWriter, r *http.Request)
+	ReplaceGroup(w http.ResponseWriter, r *http.Request)
+	PatchGroup(w http.ResponseWriter, r *http.Request)
+	DeleteGroup(w http.ResponseWriter, r *http.Request)
+
+	Bulk(w http.ResponseWriter, r *http.Request)
+}
+
+type scimHandler struct {
+	db      *pg.DB
+	service Service
+}
+
+var _ Handler = (*scimHandler)(nil)
+
+// NewScimHandler construct SCIM handler
+func NewScimHandler(db *pg.DB, service Service) Handler {
+	return &scimHandler{
+		db:      db,
+		service: service,
+	}
+}
+
+func (s *scimHandler) ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, map[string]interface{}{
+		"schemas":          []string{ServiceProviderConfigSchema},
+		"documentationUri": "https://tools.ietf.org/html/rfc7644",
+		"patch":            map[string]bool{"supported": true},
+		"bulk": map[string]interface{}{
+			"supported":      true,
+			"maxOperations":  maxOperations,
+			"maxPayloadSize": maxPayloadSize,
+		},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": maxCount},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "An authz api key or access token sent as bearer token",
+		}},
+		"meta": map[string]string{
+			"resourceType": "ServiceProviderConfig",
+			"location":     baseURL(r) + "/ServiceProviderConfig",
+		},
+	})
+}
+
+func (s *scimHandler) ResourceTypes(w http.ResponseWriter, r *http.Request) {
+	base := baseURL(r)
+	resourceTypes := []interface{}{
+		map[string]interface{}{
+			"schemas":  []string{ResourceTypeSchema},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   UserSchema,
+			"meta":     map[string]string{"resourceType": "ResourceType", "location": base + "/ResourceTypes/User"},
+		},
+		map[string]interface{}{
+			"schemas":  []string{ResourceTypeSchema},
+			"id":       "Group",
+			"name":     "Group",
+			"endpoint": "/Groups",
+			"schema":   GroupSchema,
+			"meta":     map[string]string{"resourceType": "ResourceType", "location": base + "/ResourceTypes/Group"},
+		},
+	}
+	respond(w, http.StatusOK, &ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: len(resourceTypes),
+		StartIndex:   1,
+		ItemsPerPage: len(resourceTypes),
+		Resources:    resourceTypes,
+	})
+}
+
+// ListUsers serves the users of the organization matching the filter
+// parameter, paged by startIndex and count
+func (s *scimHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	query, err := parseQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	userList, total, err := s.service.ListUsers(organization, query)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	base := baseURL(r)
+	resources := make([]interface{}, 0, len(userList))
+	for _, user := range userList {
+		locate(base, user)
+		resources = append(resources, user)
+	}
+	respond(w, http.StatusOK, newListResponse(query, total, resources))
+}
+
+func (s *scimHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	user, err := s.service.GetUser(organization, chi.URLParam(r, "id"))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	locate(baseURL(r), user)
+	respond(w, http.StatusOK, user)
+}
+
+func (s *scimHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	var resource User
+	if err := decodeBody(r, &resource); err != nil {
+		respondError(w, err)
+		return
+	}
+	user, err := s.service.CreateUser(r.Context(), organization, &resource)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	locate(baseURL(r), user)
+	w.Header().Set("Location", user.Meta.Location)
+	respond(w, http.StatusCreated, user)
+}
+
+func (s *scimHandler) ReplaceUser(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	var resource User
+	if err := decodeBody(r, &resource); err != nil {
+		respondError(w, err)
+		return
+	}
+	user, err := s.service.ReplaceUser(r.Context(), organization, chi.URLParam(r, "id"), &resource)
+	s.respondUser(w, r, user, err)
+}
+
+func (s *scimHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	var request PatchRequest
+	if err := decodeBody(r, &request); err != nil {
+		respondError(w, err)
+		return
+	}
+	user, err := s.service.PatchUser(r.Context(), organization, chi.URLParam(r, "id"), request.Operations)
+	s.respondUser(w, r, user, err)
+}
+
+// respondUser writes the changed user, deactivated users were deleted
+func (s *scimHandler) respondUser(w http.ResponseWriter, r *http.Request, user *User, err error) {
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	if user == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	locate(baseURL(r), user)
+	respond(w, http.StatusOK, user)
+}
+
+func (s *scimHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	if err := s.service.DeleteUser(r.Context(), organization, chi.URLParam(r, "id")); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListGroups serves the groups of the organization matching the filter
+// parameter, paged by startIndex and count. Members are left out when
+// excludedAttributes holds members.
+func (s *scimHandler) ListGroups(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	query, err := parseQuery(r)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	groupList, total, err := s.service.ListGroups(organization, query, wantsMembers(r))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	base := baseURL(r)
+	resources := make([]interface{}, 0, len(groupList))
+	for _, group := range groupList {
+		locate(base, group)
+		resources = append(resources, group)
+	}
+	respond(w, http.StatusOK, newListResponse(query, total, resources))
+}
+
+func (s *scimHandler) GetGroup(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	group, err := s.service.GetGroup(organization, chi.URLParam(r, "id"), wantsMembers(r))
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	locate(baseURL(r), group)
+	respond(w, http.StatusOK, group)
+}
+
+func (s *scimHandler) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	var resource Group
+	if err := decodeBody(r, &resource); err != nil {
+		respondError(w, err)
+		return
+	}
+	group, err := s.service.CreateGroup(r.Context(), organization, &resource)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	locate(baseURL(r), group)
+	w.Header().Set("Location", group.Meta.Location)
+	respond(w, http.StatusCreated, group)
+}
+
+func (s *scimHandler) ReplaceGroup(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	var resource Group
+	if err := decodeBody(r, &resource); err != nil {
+		respondError(w, err)
+		return
+	}
+	group, err := s.service.ReplaceGroup(r.Context(), organization, chi.URLParam(r, "id"), &resource)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	locate(baseURL(r), group)
+	respond(w, http.StatusOK, group)
+}
+
+func (s *scimHandler) PatchGroup(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	var request PatchRequest
+	if err := decodeBody(r, &request); err != nil {
+		respondError(w, err)
+		return
+	}
+	group, err := s.service.PatchGroup(r.Context(), organization, chi.URLParam(r, "id"), request.Operations)
+	if err != nil {
+		respondError(w, err)
+		return
+	}
+	locate(baseURL(r), group)
+	respond(w, http.StatusOK, group)
+}
+
+func (s *scimHandler) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	if err := s.service.DeleteGroup(r.Context(), organization, chi.URLParam(r, "id")); err != nil {
+		respondError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *scimHandler) Bulk(w http.ResponseWriter, r *http.Request) {
+	organization, ok := organizationFromContext(w, r)
+	if !ok {
+		return
+	}
+	var request BulkRequest
+	if err := decodeBody(r, &request); err != nil {
+		respondError(w, err)
+		return
+	}
+	if len(request.Operations) > maxOperations {
+		respondError(w, newError(http.StatusRequestEntityTooLarge, "tooMany", "bulk requests are limited to %d operations", maxOperations))
+		return
+	}
+	response := s.service.Bulk(r.Context(), organization, &request)
+	base := baseURL(r)
+	for i := range response.Operations {
+		if response.Operations[i].Location != "" {
+			response.Operations[i].Location = base + "/" + response.Operations[i].Location
+		}
+	}
+	respond(w, http.StatusOK, response)
+}
+
+func organizationFromContext(w http.ResponseWriter, r *http.Request) (*models.Organization, bool) {
+	organization, ok := r.Context().Value("organization").(*models.Organization)
+	if !ok {
+		respondError(w, newError(http.StatusUnprocessableEntity, "", "Request Can not be processed"))
+	}
+	return organization, ok
+}
+
+// parseQuery parses the filter, startIndex and count parameters
+func parseQuery(r *http.Request) (*Query, error) {
+	params := r.URL.Query()
+	query := &Query{StartIndex: 1, Count: defaultCount}
+	if filter := params.Get("filter"); filter != "" {
+		expr, err := parseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		query.Filter = expr
+	}
+	if startIndex := params.Get("startIndex"); startIndex != "" {
+		n, err := strconv.Atoi(startIndex)
+		if err != nil {
+			return nil, invalidValue("startIndex must be an integer")
+		}
+		if n > 1 {
+			query.StartIndex = n
+		}
+	}
+	if count := params.Get("count"); count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return nil, invalidValue("count must be an integer")
+		}
+		if n < 0 {
+			n = 0
+		}
+		if n > maxCount {
+			n = maxCount
+		}
+		query.Count = n
+	}
+	return query, nil
+}
+
+// wantsMembers reports whether the attributes and excludedAttributes
+// parameters select the members of groups
+func wantsMembers(r *http.Request) bool {
+	params := r.URL.Query()
+	if attributes := params.Get("attributes"); attributes != "" {
+		return containsAttribute(attributes, "members")
+	}
+	return !containsAttribute(params.Get("excludedAttributes"), "members")
+}
+
+func containsAttribute(list string, name string) bool {
+	for _, attr := range strings.Split(list, ",") {
+		if attributeName(strings.TrimSpace(attr)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newListResponse(query *Query, total int, resources []interface{}) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{ListResponseSchema},
+		TotalResults: total,
+		StartIndex:   query.StartIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+// baseURL returns the url of the SCIM endpoints of the request organization
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	path := r.URL.Path
+	if i := strings.Index(path, basePath); i >= 0 {
+		path = path[:i+len(basePath)]
+	}
+	return scheme + "://" + r.Host + path
+}
+
+// locate makes the locations of the resource absolute
+func locate(base string, resource interface{}) {
+	switch v := resource.(type) {
+	case *User:
+		v.Meta.Location = base + "/" + v.Meta.Location
+	case *Group:
+		v.Meta.Location = base + "/" + v.Meta.Location
+		for i := range v.Members {
+			v.Members[i].Ref = base + "/" + v.Members[i].Ref
+		}
+	}
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxPayloadSize)).Decode(v); err != nil {
+		return newError(http.StatusBadRequest, "invalidSyntax", "invalid request body: %s", err)
+	}
+	return nil
+}
+
+func respond(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func respondError(w http.ResponseWriter, err error) {
+	e := asError(err)
+	respond(w, e.code, e)
+}
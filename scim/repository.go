@@ -0,0 +1,125 @@
+package scim
+
+import (
+	"strconv"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
+
+	"github.com/imtanmoy/authz/identifier"
+	"github.com/imtanmoy/authz/models"
+)
+
+// userAttributes maps the filterable attributes of users
+var userAttributes = map[string]attribute{
+	"id":           {column: `"user".id`, kind: idAttribute},
+	"username":     {column: `"user".email`, kind: stringAttribute},
+	"externalid":   {column: `"user".external_id`, kind: stringAttribute},
+	"emails":       {column: `"user".email`, kind: stringAttribute},
+	"emails.value": {column: `"user".email`, kind: stringAttribute},
+	"meta.created": {column: `"user".created_at`, kind: timeAttribute},
+}
+
+// groupAttributes maps the filterable attributes of groups
+var groupAttributes = map[string]attribute{
+	"id":                {column: `"group".id`, kind: idAttribute},
+	"displayname":       {column: `"group".name`, kind: stringAttribute},
+	"meta.created":      {column: `"group".created_at`, kind: timeAttribute},
+	"meta.lastmodified": {column: `"group".updated_at`, kind: timeAttribute},
+	"members":           {condition: memberCondition},
+	"members.value":     {condition: memberCondition},
+}
+
+// memberCondition matches the groups the user is a member of
+func memberCondition(op string, value interface{}) (string, []interface{}, error) {
+	s, ok := value.(string)
+	if op != "eq" || !ok {
+		return "", nil, invalidFilter("members can only be compared with eq and a user id")
+	}
+	id, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || id <= 0 {
+		return "", nil, invalidFilter("members can only be compared with eq and a user id")
+	}
+	return `EXISTS (SELECT 1 FROM casbin_rules WHERE p_type = 'g' AND v0 = ? AND v1 = ? || "group".id)`,
+		[]interface{}{identifier.UserID(int32(id)).String(), identifier.Group.Prefix()}, nil
+}
+
+// Query selects a page of the resources matching Filter
+type Query struct {
+	// Filter is the parsed filter parameter, nil matches every resource
+	Filter expression
+	// StartIndex is the 1-based index of the first resource
+	StartIndex int
+	Count      int
+}
+
+type Repository interface {
+	// ListUsers returns a page of the users of the organization matching
+	// query ordered by id and the number of matching users
+	ListUsers(organizationId int32, query *Query) ([]*models.User, int, error)
+	// ListGroups returns a page of the groups of the organization matching
+	// query ordered by id and the number of matching groups
+	ListGroups(organizationId int32, query *Query) ([]*models.Group, int, error)
+	// FindUserByEmail returns the user of the organization with the email,
+	// ignoring its case
+	FindUserByEmail(organizationId int32, email string) (*models.User, error)
+}
+
+type scimRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*scimRepository)(nil)
+
+func NewScimRepository(db *pg.DB) Repository {
+	return &scimRepository{
+		db,
+	}
+}
+
+func (s *scimRepository) ListUsers(organizationId int32, query *Query) ([]*models.User, int, error) {
+	var users []*models.User
+	q := s.db.Model(&users).
+		Where(`"user".organization_id = ?`, organizationId).
+		Relation("Organization")
+	n, err := page(q, query, userAttributes, `"user".id`)
+	return users, n, err
+}
+
+func (s *scimRepository) ListGroups(organizationId int32, query *Query) ([]*models.Group, int, error) {
+	var groups []*models.Group
+	q := s.db.Model(&groups).
+		Where(`"group".organization_id = ?`, organizationId).
+		Relation("Organization")
+	n, err := page(q, query, groupAttributes, `"group".id`)
+	return groups, n, err
+}
+
+// page applies the filter and page of query to q, selects it and returns
+// the number of matching rows
+func page(q *orm.Query, query *Query, attributes map[string]attribute, idColumn string) (int, error) {
+	if query.Filter != nil {
+		condition, args, err := query.Filter.sql(attributes)
+		if err != nil {
+			return 0, err
+		}
+		q = q.Where(condition, args...)
+	}
+	if query.Count == 0 {
+		return q.Count()
+	}
+	return q.OrderExpr(idColumn + " ASC").
+		Offset(query.StartIndex - 1).
+		Limit(query.Count).
+		SelectAndCount()
+}
+
+func (s *scimRepository) FindUserByEmail(organizationId int32, email string) (*models.User, error) {
+	var user models.User
+	err := s.db.Model(&user).
+		Where(`"user".organization_id = ?`, organizationId).
+		Where(`lower("user".email) = lower(?)`, email).
+		Relation("Organization").
+		First()
+	return &user, err
+}
@@ -0,0 +1,197 @@
+// Package scim implements the SCIM 2.0 provisioning protocol (RFC 7643 and
+// RFC 7644) for the users and groups of an organization, so identity
+// providers can keep them in sync.
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Schema and message urns
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ResourceTypeSchema          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	BulkRequestSchema           = "urn:ietf:params:scim:api:messages:2.0:BulkRequest"
+	BulkResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:BulkResponse"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+const (
+	// defaultCount is the page size of list responses without count
+	defaultCount = 100
+	// maxCount bounds the page size of list responses
+	maxCount = 1000
+	// maxOperations bounds the operations of a bulk request
+	maxOperations = 1000
+	// maxPayloadSize bounds the size of a bulk request in bytes
+	maxPayloadSize = 1 << 20
+)
+
+// Meta holds the resource metadata
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      time.Time  `json:"created"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"`
+}
+
+// Email is an email address of a user
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// User is the SCIM representation of a user, authz only stores the email of
+// users which is also their userName. Deactivating a user deletes it.
+type User struct {
+	Schemas    []string `json:"schemas"`
+	ID         string   `json:"id,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	UserName   string   `json:"userName"`
+	Emails     []Email  `json:"emails,omitempty"`
+	Active     *bool    `json:"active,omitempty"`
+	Meta       *Meta    `json:"meta,omitempty"`
+}
+
+// email returns the email of the user, its primary email or the userName
+// when it has no email
+func (u *User) email() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return u.UserName
+}
+
+// Member is a user of a group
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// Group is the SCIM representation of a group, members are users
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is a page of resources, startIndex is 1-based
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// PatchRequest holds the operations of a PATCH request
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation adds, removes or replaces the attribute at path, or the
+// attributes of value without path
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// BulkRequest holds the operations of a bulk request
+type BulkRequest struct {
+	Schemas []string `json:"schemas"`
+	// FailOnErrors stops processing after that many failed operations, zero
+	// processes every operation
+	FailOnErrors int             `json:"failOnErrors,omitempty"`
+	Operations   []BulkOperation `json:"Operations"`
+}
+
+// BulkOperation is a request of a bulk request, resources created with a
+// bulkId are referenced by "bulkId:<bulkId>" in later operations
+type BulkOperation struct {
+	Method string          `json:"method"`
+	BulkID string          `json:"bulkId,omitempty"`
+	Path   string          `json:"path"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// BulkResponse holds the results of the processed operations
+type BulkResponse struct {
+	Schemas    []string     `json:"schemas"`
+	Operations []BulkResult `json:"Operations"`
+}
+
+// BulkResult is the result of a bulk operation
+type BulkResult struct {
+	Method   string      `json:"method"`
+	BulkID   string      `json:"bulkId,omitempty"`
+	Location string      `json:"location,omitempty"`
+	Status   string      `json:"status"`
+	Response interface{} `json:"response,omitempty"`
+}
+
+// Error is a SCIM error response
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+
+	code int
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+// newError creates an error response with the http status code
+func newError(code int, scimType string, format string, args ...interface{}) *Error {
+	return &Error{
+		Schemas:  []string{ErrorSchema},
+		Status:   fmt.Sprint(code),
+		ScimType: scimType,
+		Detail:   fmt.Sprintf(format, args...),
+		code:     code,
+	}
+}
+
+func invalidFilter(format string, args ...interface{}) *Error {
+	return newError(http.StatusBadRequest, "invalidFilter", format, args...)
+}
+
+func invalidValue(format string, args ...interface{}) *Error {
+	return newError(http.StatusBadRequest, "invalidValue", format, args...)
+}
+
+func invalidPath(format string, args ...interface{}) *Error {
+	return newError(http.StatusBadRequest, "invalidPath", format, args...)
+}
+
+func uniqueness(format string, args ...interface{}) *Error {
+	return newError(http.StatusConflict, "uniqueness", format, args...)
+}
+
+func notFound(format string, args ...interface{}) *Error {
+	return newError(http.StatusNotFound, "", format, args...)
+}
@@ -0,0 +1,868 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/groups"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/users"
+	"github.com/imtanmoy/authz/utils"
+)
+
+// bulkIDPrefix marks references to resources created earlier in a bulk request
+const bulkIDPrefix = "bulkId:"
+
+// Service maps SCIM resources to the users and groups of an organization,
+// changes are made through the user and group services so they are audited
+// and published like the changes of the management api
+type Service interface {
+	ListUsers(organization *models.Organization, query *Query) ([]*User, int, error)
+	GetUser(organization *models.Organization, id string) (*User, error)
+	// CreateUser creates the user, externalId is used as user id when it is
+	// numeric and stored as the external id of the user with a generated id
+	// otherwise
+	CreateUser(ctx context.Context, organization *models.Organization, user *User) (*User, error)
+	// ReplaceUser replaces the user, it returns nil when the user was
+	// deactivated and therefore deleted
+	ReplaceUser(ctx context.Context, organization *models.Organization, id string, user *User) (*User, error)
+	// PatchUser applies the operations to the user, it returns nil when the
+	// user was deactivated and therefore deleted
+	PatchUser(ctx context.Context, organization *models.Organization, id string, operations []PatchOperation) (*User, error)
+	DeleteUser(ctx context.Context, organization *models.Organization, id string) error
+
+	// ListGroups returns a page of groups, members are loaded when members is set
+	ListGroups(organization *models.Organization, query *Query, members bool) ([]*Group, int, error)
+	GetGroup(organization *models.Organization, id string, members bool) (*Group, error)
+	CreateGroup(ctx context.Context, organization *models.Organization, group *Group) (*Group, error)
+	ReplaceGroup(ctx context.Context, organization *models.Organization, id string, group *Group) (*Group, error)
+	PatchGroup(ctx context.Context, organization *models.Organization, id string, operations []PatchOperation) (*Group, error)
+	DeleteGroup(ctx context.Context, organization *models.Organization, id string) error
+
+	// Bulk processes the operations in order until request.FailOnErrors
+	// operations failed
+	Bulk(ctx context.Context, organization *models.Organization, request *BulkRequest) *BulkResponse
+}
+
+type scimService struct {
+	repository        Repository
+	userService       users.Service
+	groupService      groups.Service
+	authorizerService authorizer.Service
+}
+
+var _ Service = (*scimService)(nil)
+
+func NewScimService(db *pg.DB, userService users.Service, groupService groups.Service, authorizerService authorizer.Service) Service {
+	return &scimService{
+		repository:        NewScimRepository(db),
+		userService:       userService,
+		groupService:      groupService,
+		authorizerService: authorizerService,
+	}
+}
+
+func (s *scimService) ListUsers(organization *models.Organization, query *Query) ([]*User, int, error) {
+	userList, total, err := s.repository.ListUsers(organization.ID, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	resources := make([]*User, 0, len(userList))
+	for _, user := range userList {
+		resources = append(resources, newUser(user))
+	}
+	return resources, total, nil
+}
+
+func (s *scimService) GetUser(organization *models.Organization, id string) (*User, error) {
+	user, err := s.findUser(organization, id)
+	if err != nil {
+		return nil, err
+	}
+	return newUser(user), nil
+}
+
+func (s *scimService) CreateUser(ctx context.Context, organization *models.Organization, resource *User) (*User, error) {
+	if resource.Active != nil && !*resource.Active {
+		return nil, invalidValue("inactive users can not be provisioned")
+	}
+	email, err := validEmail(resource.email())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkEmail(organization, email, 0); err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Email:          email,
+		OrganizationID: organization.ID,
+		Organization:   organization,
+	}
+	id, externalID := utils.ParseID(resource.ExternalID)
+	if id != 0 {
+		if s.userService.Exists(id) {
+			return nil, uniqueness("user %d already exists", id)
+		}
+		user.ID = id
+	}
+	if externalID != "" {
+		if err := s.checkExternalID(organization, externalID); err != nil {
+			return nil, err
+		}
+		user.ExternalID = externalID
+	}
+	user, err = s.userService.Create(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return newUser(user), nil
+}
+
+func (s *scimService) ReplaceUser(ctx context.Context, organization *models.Organization, id string, resource *User) (*User, error) {
+	user, err := s.findUser(organization, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.saveUser(ctx, organization, user, resource)
+}
+
+func (s *scimService) PatchUser(ctx context.Context, organization *models.Organization, id string, operations []PatchOperation) (*User, error) {
+	user, err := s.findUser(organization, id)
+	if err != nil {
+		return nil, err
+	}
+	resource := newUser(user)
+	for _, operation := range operations {
+		if err := patch(operation, func(op, path string, value json.RawMessage) error {
+			return patchUser(resource, op, path, value)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return s.saveUser(ctx, organization, user, resource)
+}
+
+func (s *scimService) DeleteUser(ctx context.Context, organization *models.Organization, id string) error {
+	user, err := s.findUser(organization, id)
+	if err != nil {
+		return err
+	}
+	return s.userService.Delete(ctx, user)
+}
+
+// saveUser updates the user to the resource, deactivated users are deleted
+func (s *scimService) saveUser(ctx context.Context, organization *models.Organization, user *models.User, resource *User) (*User, error) {
+	if resource.Active != nil && !*resource.Active {
+		return nil, s.userService.Delete(ctx, user)
+	}
+	email, err := validEmail(resource.email())
+	if err != nil {
+		return nil, err
+	}
+	// an omitted or numeric externalId keeps the external id of the user
+	_, externalID := utils.ParseID(resource.ExternalID)
+	if externalID == "" {
+		externalID = user.ExternalID
+	}
+	if email == user.Email && externalID == user.ExternalID {
+		return newUser(user), nil
+	}
+	if email != user.Email {
+		if err := s.checkEmail(organization, email, user.ID); err != nil {
+			return nil, err
+		}
+	}
+	if externalID != user.ExternalID {
+		if err := s.checkExternalID(organization, externalID); err != nil {
+			return nil, err
+		}
+	}
+	user.Email = email
+	user.ExternalID = externalID
+	user, err = s.userService.Update(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	return newUser(user), nil
+}
+
+// checkEmail fails when a user of the organization other than id has the email
+func (s *scimService) checkEmail(organization *models.Organization, email string, id int32) error {
+	existing, err := s.repository.FindUserByEmail(organization.ID, email)
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != id {
+		return uniqueness("user %s already exists", email)
+	}
+	return nil
+}
+
+// checkExternalID fails when a user of the organization has the external id
+func (s *scimService) checkExternalID(organization *models.Organization, externalID string) error {
+	if s.userService.ExternalIdExists(organization.ID, externalID) {
+		return uniqueness("user %s already exists", externalID)
+	}
+	return nil
+}
+
+// findUser returns the user belonging to the organization, users invited
+// from other organizations are not managed through SCIM
+func (s *scimService) findUser(organization *models.Organization, id string) (*models.User, error) {
+	user, err := s.findMember(organization, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.OrganizationID != organization.ID {
+		return nil, notFound("user %s not found", id)
+	}
+	return user, nil
+}
+
+// findMember returns the user belonging to the organization or invited as
+// its member
+func (s *scimService) findMember(organization *models.Organization, id string) (*models.User, error) {
+	userID, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return nil, notFound("user %s not found", id)
+	}
+	user, err := s.userService.FindByIdAndOrganizationId(int32(userID), organization.ID)
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, notFound("user %s not found", id)
+	}
+	return user, err
+}
+
+func (s *scimService) ListGroups(organization *models.Organization, query *Query, members bool) ([]*Group, int, error) {
+	groupList, total, err := s.repository.ListGroups(organization.ID, query)
+	if err != nil {
+		return nil, 0, err
+	}
+	if members && len(groupList) > 0 {
+		ids := make([]int32, 0, len(groupList))
+		for _, group := range groupList {
+			ids = append(ids, group.ID)
+		}
+		userMap, err := s.authorizerService.GetUsersForGroups(ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, group := range groupList {
+			group.Users = userMap[group.ID]
+		}
+	}
+	resources := make([]*Group, 0, len(groupList))
+	for _, group := range groupList {
+		resources = append(resources, newGroup(group, members))
+	}
+	return resources, total, nil
+}
+
+func (s *scimService) GetGroup(organization *models.Organization, id string, members bool) (*Group, error) {
+	group, err := s.findGroup(organization, id)
+	if err != nil {
+		return nil, err
+	}
+	return newGroup(group, members), nil
+}
+
+func (s *scimService) CreateGroup(ctx context.Context, organization *models.Organization, resource *Group) (*Group, error) {
+	if resource.DisplayName == "" {
+		return nil, invalidValue("displayName is required")
+	}
+	if err := s.checkGroupName(organization, resource.DisplayName, 0); err != nil {
+		return nil, err
+	}
+	members, err := s.findMembers(organization, resource.Members)
+	if err != nil {
+		return nil, err
+	}
+	group, err := s.groupService.Create(ctx, &groups.GroupPayload{Name: resource.DisplayName}, organization, members, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newGroup(group, true), nil
+}
+
+func (s *scimService) ReplaceGroup(ctx context.Context, organization *models.Organization, id string, resource *Group) (*Group, error) {
+	group, err := s.findGroup(organization, id)
+	if err != nil {
+		return nil, err
+	}
+	if resource.DisplayName == "" {
+		return nil, invalidValue("displayName is required")
+	}
+	if err := s.checkGroupName(organization, resource.DisplayName, group.ID); err != nil {
+		return nil, err
+	}
+	members, err := s.findMembers(organization, resource.Members)
+	if err != nil {
+		return nil, err
+	}
+	group.Name = resource.DisplayName
+	if err := s.updateGroup(ctx, group, members); err != nil {
+		return nil, err
+	}
+	return newGroup(group, true), nil
+}
+
+func (s *scimService) PatchGroup(ctx context.Context, organization *models.Organization, id string, operations []PatchOperation) (*Group, error) {
+	group, err := s.findGroup(organization, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, operation := range operations {
+		if err := patch(operation, func(op, path string, value json.RawMessage) error {
+			return s.patchGroup(ctx, organization, group, op, path, value)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return newGroup(group, true), nil
+}
+
+// patchGroup applies an operation to the attribute at path of the group,
+// membership changes are applied through AddUsers and RemoveUsers
+func (s *scimService) patchGroup(ctx context.Context, organization *models.Organization, group *models.Group, op string, path string, value json.RawMessage) error {
+	attr, valueFilter := splitPath(path)
+	switch attr {
+	case "displayname":
+		if op == "remove" {
+			return invalidValue("displayName is required")
+		}
+		var name string
+		if err := json.Unmarshal(value, &name); err != nil || name == "" {
+			return invalidValue("displayName must be a non empty string")
+		}
+		if name == group.Name {
+			return nil
+		}
+		if err := s.checkGroupName(organization, name, group.ID); err != nil {
+			return err
+		}
+		group.Name = name
+		return s.updateGroup(ctx, group, group.Users)
+	case "members":
+		switch op {
+		case "add":
+			members, err := s.parseMembers(organization, value)
+			if err != nil {
+				return err
+			}
+			return s.groupService.AddUsers(ctx, group, members)
+		case "replace":
+			members, err := s.parseMembers(organization, value)
+			if err != nil {
+				return err
+			}
+			return s.updateGroup(ctx, group, members)
+		}
+		// remove the members matching the value filter, the members of
+		// value or every member
+		var ids []string
+		switch {
+		case valueFilter != "":
+			expr, err := parseFilter(valueFilter)
+			if err != nil {
+				return err
+			}
+			if ids, err = memberIDs(expr); err != nil {
+				return err
+			}
+		case len(value) > 0 && string(value) != "null":
+			members, err := decodeMembers(value)
+			if err != nil {
+				return err
+			}
+			for _, member := range members {
+				ids = append(ids, member.Value)
+			}
+		default:
+			return s.groupService.RemoveUsers(ctx, group, group.Users)
+		}
+		var removed []*models.User
+		for _, user := range group.Users {
+			for _, id := range ids {
+				if strconv.Itoa(int(user.ID)) == id {
+					removed = append(removed, user)
+					break
+				}
+			}
+		}
+		if len(removed) == 0 {
+			return nil
+		}
+		return s.groupService.RemoveUsers(ctx, group, removed)
+	}
+	// attributes authz does not store, such as externalId, are ignored
+	return nil
+}
+
+func (s *scimService) DeleteGroup(ctx context.Context, organization *models.Organization, id string) error {
+	group, err := s.findGroup(organization, id)
+	if err != nil {
+		return err
+	}
+	return s.groupService.Delete(ctx, group)
+}
+
+// updateGroup saves the name of the group and reconciles its members,
+// keeping its permissions
+func (s *scimService) updateGroup(ctx context.Context, group *models.Group, members []*models.User) error {
+	err := s.groupService.Update(ctx, group, members, group.Permissions)
+	if errors.Is(err, groups.ErrRevisionMismatch) {
+		return newError(http.StatusConflict, "", "group %d was modified concurrently", group.ID)
+	}
+	return err
+}
+
+// checkGroupName fails when a group of the organization other than id has the name
+func (s *scimService) checkGroupName(organization *models.Organization, name string, id int32) error {
+	existing, err := s.groupService.FindByName(organization, name)
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != id {
+		return uniqueness("group %s already exists", name)
+	}
+	return nil
+}
+
+func (s *scimService) findGroup(organization *models.Organization, id string) (*models.Group, error) {
+	groupID, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return nil, notFound("group %s not found", id)
+	}
+	group, err := s.groupService.FindByIdAndOrganizationId(int32(groupID), organization.ID)
+	if errors.Is(err, pg.ErrNoRows) {
+		return nil, notFound("group %s not found", id)
+	}
+	return group, err
+}
+
+// parseMembers returns the users of the members encoded in value
+func (s *scimService) parseMembers(organization *models.Organization, value json.RawMessage) ([]*models.User, error) {
+	members, err := decodeMembers(value)
+	if err != nil {
+		return nil, err
+	}
+	return s.findMembers(organization, members)
+}
+
+// findMembers returns the users of the members, which must belong to the
+// organization or be invited as its members
+func (s *scimService) findMembers(organization *models.Organization, members []Member) ([]*models.User, error) {
+	userList := make([]*models.User, 0, len(members))
+	seen := make(map[int32]bool, len(members))
+	for _, member := range members {
+		user, err := s.findMember(organization, member.Value)
+		var e *Error
+		if errors.As(err, &e) {
+			return nil, invalidValue("member %s is not a user of the organization", member.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !seen[user.ID] {
+			seen[user.ID] = true
+			userList = append(userList, user)
+		}
+	}
+	return userList, nil
+}
+
+func (s *scimService) Bulk(ctx context.Context, organization *models.Organization, request *BulkRequest) *BulkResponse {
+	response := &BulkResponse{
+		Schemas:    []string{BulkResponseSchema},
+		Operations: make([]BulkResult, 0, len(request.Operations)),
+	}
+	// ids holds the ids of the resources created with a bulkId
+	ids := make(map[string]string)
+	failures := 0
+	for _, operation := range request.Operations {
+		if request.FailOnErrors > 0 && failures >= request.FailOnErrors {
+			break
+		}
+		result := BulkResult{Method: strings.ToUpper(operation.Method), BulkID: operation.BulkID}
+		code, location, err := s.bulk(ctx, organization, operation, ids)
+		if err != nil {
+			failures++
+			e := asError(err)
+			result.Status = e.Status
+			result.Response = e
+		} else {
+			result.Status = strconv.Itoa(code)
+			result.Location = location
+		}
+		response.Operations = append(response.Operations, result)
+	}
+	return response
+}
+
+// bulk processes an operation of a bulk request and returns its status code
+// and the location of its resource
+func (s *scimService) bulk(ctx context.Context, organization *models.Organization, operation BulkOperation, ids map[string]string) (int, string, error) {
+	path, data, err := resolveBulkIDs(operation, ids)
+	if err != nil {
+		return 0, "", err
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	resourceType, id := parts[0], ""
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+	if len(parts) > 2 || (resourceType != "Users" && resourceType != "Groups") {
+		return 0, "", invalidPath("unknown path %s", operation.Path)
+	}
+	method := strings.ToUpper(operation.Method)
+	if (method == http.MethodPost) != (id == "") {
+		return 0, "", invalidPath("%s is not supported on %s", method, operation.Path)
+	}
+
+	var resourceID string
+	code := http.StatusOK
+	switch method {
+	case http.MethodPost:
+		code = http.StatusCreated
+		if resourceType == "Users" {
+			var resource User
+			if err := decode(data, &resource); err != nil {
+				return 0, "", err
+			}
+			user, err := s.CreateUser(ctx, organization, &resource)
+			if err != nil {
+				return 0, "", err
+			}
+			resourceID = user.ID
+		} else {
+			var resource Group
+			if err := decode(data, &resource); err != nil {
+				return 0, "", err
+			}
+			group, err := s.CreateGroup(ctx, organization, &resource)
+			if err != nil {
+				return 0, "", err
+			}
+			resourceID = group.ID
+		}
+		if operation.BulkID != "" {
+			ids[operation.BulkID] = resourceID
+		}
+	case http.MethodPut:
+		if resourceType == "Users" {
+			var resource User
+			if err := decode(data, &resource); err != nil {
+				return 0, "", err
+			}
+			user, err := s.ReplaceUser(ctx, organization, id, &resource)
+			if err != nil {
+				return 0, "", err
+			}
+			if user == nil {
+				return http.StatusNoContent, "", nil
+			}
+		} else {
+			var resource Group
+			if err := decode(data, &resource); err != nil {
+				return 0, "", err
+			}
+			if _, err := s.ReplaceGroup(ctx, organization, id, &resource); err != nil {
+				return 0, "", err
+			}
+		}
+		resourceID = id
+	case http.MethodPatch:
+		var request PatchRequest
+		if err := decode(data, &request); err != nil {
+			return 0, "", err
+		}
+		if resourceType == "Users" {
+			user, err := s.PatchUser(ctx, organization, id, request.Operations)
+			if err != nil {
+				return 0, "", err
+			}
+			if user == nil {
+				return http.StatusNoContent, "", nil
+			}
+		} else if _, err := s.PatchGroup(ctx, organization, id, request.Operations); err != nil {
+			return 0, "", err
+		}
+		resourceID = id
+	case http.MethodDelete:
+		if resourceType == "Users" {
+			err = s.DeleteUser(ctx, organization, id)
+		} else {
+			err = s.DeleteGroup(ctx, organization, id)
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		return http.StatusNoContent, "", nil
+	default:
+		return 0, "", invalidValue("unknown method %s", operation.Method)
+	}
+	return code, resourceType + "/" + resourceID, nil
+}
+
+// resolveBulkIDs replaces the bulkId references of the path and data of the
+// operation with the ids of the resources created for them
+func resolveBulkIDs(operation BulkOperation, ids map[string]string) (string, json.RawMessage, error) {
+	path, data := operation.Path, []byte(operation.Data)
+	for bulkID, id := range ids {
+		path = strings.Replace(path, bulkIDPrefix+bulkID, id, -1)
+		data = bytes.Replace(data, []byte(`"`+bulkIDPrefix+bulkID+`"`), []byte(`"`+id+`"`), -1)
+	}
+	if strings.Contains(path, bulkIDPrefix) || bytes.Contains(data, []byte(`"`+bulkIDPrefix)) {
+		return "", nil, newError(http.StatusConflict, "invalidValue", "operation references an unknown bulkId")
+	}
+	return path, data, nil
+}
+
+// patch applies the operation through apply, operations without path apply
+// each attribute of their value
+func patch(operation PatchOperation, apply func(op, path string, value json.RawMessage) error) error {
+	op := strings.ToLower(operation.Op)
+	if op != "add" && op != "remove" && op != "replace" {
+		return newError(http.StatusBadRequest, "invalidSyntax", "unknown operation %q", operation.Op)
+	}
+	if operation.Path != "" {
+		return apply(op, operation.Path, operation.Value)
+	}
+	if op == "remove" {
+		return newError(http.StatusBadRequest, "noTarget", "remove operations require a path")
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(operation.Value, &values); err != nil {
+		return invalidValue("operations without path require an object value")
+	}
+	for path, value := range values {
+		if err := apply(op, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchUser applies an operation to the attribute at path of the resource
+func patchUser(resource *User, op string, path string, value json.RawMessage) error {
+	attr, _ := splitPath(path)
+	switch {
+	case attr == "username":
+		if op == "remove" {
+			return invalidValue("userName is required")
+		}
+		var userName string
+		if err := json.Unmarshal(value, &userName); err != nil {
+			return invalidValue("userName must be a string")
+		}
+		resource.UserName = userName
+		resource.Emails = nil
+	case attr == "active":
+		if op == "remove" {
+			return nil
+		}
+		active, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		resource.Active = &active
+	case attr == "emails" || strings.HasPrefix(attr, "emails."):
+		if op == "remove" {
+			// the email of a user can not be removed
+			return nil
+		}
+		email, err := parseEmail(value)
+		if err != nil {
+			return err
+		}
+		resource.Emails = []Email{{Value: email, Primary: true}}
+	case attr == "externalid":
+		if op == "remove" {
+			// the external id of a user is kept
+			return nil
+		}
+		var externalID string
+		if err := json.Unmarshal(value, &externalID); err != nil {
+			return invalidValue("externalId must be a string")
+		}
+		resource.ExternalID = externalID
+	}
+	// attributes authz does not store, such as name, are ignored
+	return nil
+}
+
+// splitPath splits a patch path such as `members[value eq "1"]` into its
+// lower case attribute name and value filter
+func splitPath(path string) (string, string) {
+	if i := strings.Index(path, "["); i >= 0 && strings.HasSuffix(path, "]") {
+		return attributeName(path[:i]), path[i+1 : len(path)-1]
+	}
+	if i := strings.Index(path, "["); i >= 0 {
+		// sub attributes of filtered values, such as emails[type eq "work"].value
+		return attributeName(path[:i]), path[i+1 : strings.LastIndex(path, "]")]
+	}
+	return attributeName(path), ""
+}
+
+// memberIDs returns the ids of a members value filter such as
+// `value eq "1" or value eq "2"`
+func memberIDs(expr expression) ([]string, error) {
+	switch e := expr.(type) {
+	case *logical:
+		if e.op == "or" {
+			left, err := memberIDs(e.left)
+			if err != nil {
+				return nil, err
+			}
+			right, err := memberIDs(e.right)
+			if err != nil {
+				return nil, err
+			}
+			return append(left, right...), nil
+		}
+	case *comparison:
+		id, ok := e.value.(string)
+		if ok && e.op == "eq" && (e.attr == "value" || e.attr == "members.value") {
+			return []string{id}, nil
+		}
+	}
+	return nil, invalidFilter("members can only be selected by value eq")
+}
+
+// decodeMembers decodes a member or a list of members
+func decodeMembers(value json.RawMessage) ([]Member, error) {
+	var members []Member
+	if err := json.Unmarshal(value, &members); err == nil {
+		return members, nil
+	}
+	var member Member
+	if err := json.Unmarshal(value, &member); err != nil {
+		return nil, invalidValue("members must be a list of objects with a value")
+	}
+	return []Member{member}, nil
+}
+
+// parseBool decodes a boolean, some identity providers send "True" and "False"
+func parseBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b, nil
+		}
+	}
+	return false, invalidValue("active must be a boolean")
+}
+
+// parseEmail decodes an email address, an email or a list of emails
+func parseEmail(value json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		return s, nil
+	}
+	user := &User{}
+	if err := json.Unmarshal(value, &user.Emails); err != nil {
+		var email Email
+		if err := json.Unmarshal(value, &email); err != nil {
+			return "", invalidValue("emails must be a list of emails")
+		}
+		user.Emails = []Email{email}
+	}
+	return user.email(), nil
+}
+
+// validEmail returns the address of email if it is valid
+func validEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", invalidValue("%q is not a valid email address", email)
+	}
+	return email, nil
+}
+
+func decode(data json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return newError(http.StatusBadRequest, "invalidSyntax", "invalid data: %s", err)
+	}
+	return nil
+}
+
+// asError converts err to an error response
+func asError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, pg.ErrNoRows) {
+		return notFound("resource not found")
+	}
+	return newError(http.StatusInternalServerError, "", "%s", err)
+}
+
+func newUser(user *models.User) *User {
+	id := strconv.Itoa(int(user.ID))
+	active := true
+	return &User{
+		Schemas:    []string{UserSchema},
+		ID:         id,
+		ExternalID: user.ExternalID,
+		UserName:   user.Email,
+		Emails:     []Email{{Value: user.Email, Primary: true}},
+		Active:     &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			Location:     "Users/" + id,
+		},
+	}
+}
+
+func newGroup(group *models.Group, members bool) *Group {
+	id := strconv.Itoa(int(group.ID))
+	resource := &Group{
+		Schemas:     []string{GroupSchema},
+		ID:          id,
+		DisplayName: group.Name,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			Location:     "Groups/" + id,
+			Version:      `W/"` + strconv.Itoa(int(group.Revision)) + `"`,
+		},
+	}
+	if !group.UpdatedAt.IsZero() {
+		lastModified := group.UpdatedAt
+		resource.Meta.LastModified = &lastModified
+	}
+	if members {
+		resource.Members = make([]Member, 0, len(group.Users))
+		for _, user := range group.Users {
+			userID := strconv.Itoa(int(user.ID))
+			resource.Members = append(resource.Members, Member{
+				Value:   userID,
+				Display: user.Email,
+				Ref:     "Users/" + userID,
+			})
+		}
+	}
+	return resource
+}
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"github.com/imtanmoy/authz/groups"
 	"net/http"
 	"time"
 
@@ -9,89 +8,356 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
 
-	"github.com/imtanmoy/authz/db"
+	"github.com/imtanmoy/authz/apikeys"
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/auth"
+	"github.com/imtanmoy/authz/checks"
+	"github.com/imtanmoy/authz/groups"
+	"github.com/imtanmoy/authz/guard"
+	"github.com/imtanmoy/authz/imports"
+	"github.com/imtanmoy/authz/members"
+	"github.com/imtanmoy/authz/memberships"
 	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/permissions"
+	"github.com/imtanmoy/authz/scim"
+	"github.com/imtanmoy/authz/serviceaccounts"
+	"github.com/imtanmoy/authz/stream"
 	"github.com/imtanmoy/authz/users"
+	"github.com/imtanmoy/authz/webhooks"
 )
 
-// New configures application resources and routes.
-func New() (*chi.Mux, error) {
+// Handlers holds the resource handlers mounted by the api
+type Handlers struct {
+	Organizations   organizations.Handler
+	Users           users.Handler
+	Groups          groups.Handler
+	APIKeys         apikeys.Handler
+	Audit           audit.Handler
+	Webhooks        webhooks.Handler
+	Events          stream.Handler
+	SCIM            scim.Handler
+	Memberships     memberships.Handler
+	Imports         imports.Handler
+	ServiceAccounts serviceaccounts.Handler
+	Checks          checks.Handler
+	Members         members.Handler
+}
+
+// New configures application resources and routes, every route but ping
+// requires a caller accepted by one of the authenticators and mutations are
+// guarded by system permissions.
+func New(handlers Handlers, guard guard.Guard, authenticators ...auth.Authenticator) (*chi.Mux, error) {
 
 	r := chi.NewRouter()
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.DefaultCompress)
-	r.Use(middleware.Timeout(15 * time.Second))
 
 	//r.Use(logging.NewStructuredLogger(logger))
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 
 	//r.Use(corsConfig().Handler)
 
-	r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
-		_, _ = w.Write([]byte("pong"))
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(15 * time.Second))
+
+		r.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("pong"))
+		})
+
+		//routes.Routes(r)
+		r.Group(func(r chi.Router) {
+			r.Use(auth.Middleware(authenticators...))
+			r.Mount("/organizations", organizationRouter(guard, handlers.Organizations))
+			r.Mount("/users", allUserRouter(handlers.Users))
+			r.Mount("/{oid}/users", userRouter(guard, handlers.Organizations, handlers.Users, handlers.Imports))
+			r.Mount("/{oid}/groups", groupRouter(guard, handlers.Organizations, handlers.Groups))
+			r.Mount("/{oid}/memberships", membershipRouter(guard, handlers.Organizations, handlers.Memberships))
+			r.Mount("/{oid}/members", memberRouter(guard, handlers.Organizations, handlers.Members))
+			r.Mount("/{oid}/service-accounts", serviceAccountRouter(guard, handlers.Organizations, handlers.ServiceAccounts))
+			r.Mount("/{oid}/check", checkRouter(handlers.Organizations, handlers.Checks))
+			r.Mount("/{oid}/api-keys", apiKeyRouter(guard, handlers.Organizations, handlers.APIKeys))
+			r.Mount("/{oid}/audit", auditRouter(guard, handlers.Organizations, handlers.Audit))
+			r.Mount("/{oid}/webhooks", webhookRouter(guard, handlers.Organizations, handlers.Webhooks))
+			r.Mount("/{oid}/scim/v2", scimRouter(guard, handlers.Organizations, handlers.SCIM))
+		})
 	})
 
-	//routes.Routes(r)
-	r.Mount("/organizations", organizationRouter())
-	r.Mount("/users", userRouter())
-	r.Mount("/{oid}/groups", groupRouter())
+	// event streams stay open past the request timeout until the client
+	// leaves or the server shuts down
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Middleware(authenticators...))
+		r.Mount("/{oid}/events", eventRouter(handlers.Organizations, handlers.Events))
+	})
 
 	return r, nil
 }
 
-func organizationRouter() http.Handler {
+func organizationRouter(guard guard.Guard, organizationHandler organizations.Handler) http.Handler {
 	r := chi.NewRouter()
-	organizationHandler := organizations.NewOrganizationHandler(db.DB)
 
 	r.Group(func(r chi.Router) {
-		r.Get("/", organizationHandler.List)
-		r.Post("/", organizationHandler.Create)
-		r.Get("/{id}", organizationHandler.Get)
-		r.Put("/{id}", organizationHandler.Update)
-		r.Delete("/{id}", organizationHandler.Delete)
+		r.Group(func(r chi.Router) {
+			r.Use(auth.RequireSuperAdmin)
+			r.Use(auth.RequireScope(auth.ScopeAdmin))
+			r.Get("/", organizationHandler.List)
+			r.Post("/", organizationHandler.Create)
+			r.Delete("/{id}", organizationHandler.Delete)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(organizationHandler.ResolveID("id"))
+			r.Use(auth.RequireOrganization("id"))
+			r.With(auth.RequireScope(auth.ScopeRead)).Get("/{id}", organizationHandler.Get)
+			r.With(
+				auth.RequireScope(auth.ScopeWrite),
+				guard.Require(permissions.OrganizationsWrite),
+			).Put("/{id}", organizationHandler.Update)
+		})
 	})
 
 	return r
 }
 
-func userRouter() http.Handler {
+// allUserRouter serves the cross organization user listing to superadmins
+func allUserRouter(userHandler users.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(auth.RequireSuperAdmin)
+	r.Use(auth.RequireScope(auth.ScopeAdmin))
+
+	r.Get("/", userHandler.ListAll)
+
+	return r
+}
+
+func userRouter(guard guard.Guard, organizationHandler organizations.Handler, userHandler users.Handler, importHandler imports.Handler) http.Handler {
 	r := chi.NewRouter()
-	userHandler := users.NewUserHandler(db.DB)
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(organizationHandler.OrganizationCtx)
 
 	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireScope(auth.ScopeRead))
 		r.Get("/", userHandler.List)
-		r.Post("/", userHandler.Create)
-		r.Get("/{id}", userHandler.Get)
-		r.Put("/{id}", userHandler.Update)
-		r.Delete("/{id}", userHandler.Delete)
+		r.Get("/import/{id}", importHandler.Job)
 
 		r.Group(func(r chi.Router) {
 			r.Use(userHandler.UserCtx)
+			r.Get("/{id}", userHandler.Get)
 			r.Get("/{id}/groups", userHandler.GetGroups)
 			r.Get("/{id}/permissions", userHandler.GetPermissions)
 		})
 	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireScope(auth.ScopeWrite))
+		r.Use(guard.Require(permissions.UsersWrite))
+		r.Post("/", userHandler.Create)
+		// imports add the users to groups
+		r.With(guard.Require(permissions.GroupsWrite)).Post("/import", importHandler.Import)
+		r.Put("/{id}", userHandler.Update)
+		r.With(userHandler.UserCtx).Delete("/{id}", userHandler.Delete)
+	})
 
 	return r
 }
 
-func groupRouter() http.Handler {
+func groupRouter(guard guard.Guard, organizationHandler organizations.Handler, groupHandler groups.Handler) http.Handler {
 	r := chi.NewRouter()
-	groupHandler := groups.NewGroupHandler(db.DB)
-	r.Use(groupHandler.OrganizationCtx)
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(organizationHandler.OrganizationCtx)
 
 	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireScope(auth.ScopeRead))
 		r.Get("/", groupHandler.List)
+		r.With(groupHandler.GroupCtx).Get("/{id}", groupHandler.Get)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireScope(auth.ScopeWrite))
+		r.Use(guard.Require(permissions.GroupsWrite))
 		r.Post("/", groupHandler.Create)
+		r.Post("/{id}/restore", groupHandler.Restore)
 		r.Group(func(r chi.Router) {
 			r.Use(groupHandler.GroupCtx)
-			r.Get("/{id}", groupHandler.Get)
 			r.Put("/{id}", groupHandler.Update)
 			r.Delete("/{id}", groupHandler.Delete)
+			r.Post("/{id}/users", groupHandler.AddUsers)
+			r.Delete("/{id}/users", groupHandler.RemoveUsers)
+			r.Post("/{id}/permissions", groupHandler.AddPermissions)
+			r.Delete("/{id}/permissions", groupHandler.RemovePermissions)
 		})
 	})
 
 	return r
-}
\ No newline at end of file
+}
+
+// membershipRouter serves the reconciliation of the organization's group
+// memberships, which changes users and groups at once
+func membershipRouter(guard guard.Guard, organizationHandler organizations.Handler, membershipHandler memberships.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(auth.RequireScope(auth.ScopeWrite))
+	r.Use(organizationHandler.OrganizationCtx)
+	r.Use(guard.Require(permissions.UsersWrite))
+	r.Use(guard.Require(permissions.GroupsWrite))
+
+	r.Put("/", membershipHandler.Reconcile)
+
+	return r
+}
+
+// memberRouter serves the invitation of users of other organizations and
+// their removal, which also removes them from the organization's groups
+func memberRouter(guard guard.Guard, organizationHandler organizations.Handler, memberHandler members.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(auth.RequireScope(auth.ScopeWrite))
+	r.Use(organizationHandler.OrganizationCtx)
+	r.Use(guard.Require(permissions.UsersWrite))
+
+	r.Post("/", memberHandler.Invite)
+	r.With(guard.Require(permissions.GroupsWrite)).Delete("/{id}", memberHandler.Remove)
+
+	return r
+}
+
+func serviceAccountRouter(guard guard.Guard, organizationHandler organizations.Handler, serviceAccountHandler serviceaccounts.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(organizationHandler.OrganizationCtx)
+
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireScope(auth.ScopeRead))
+		r.Get("/", serviceAccountHandler.List)
+		r.With(serviceAccountHandler.ServiceAccountCtx).Get("/{id}", serviceAccountHandler.Get)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(auth.RequireScope(auth.ScopeWrite))
+		r.Use(guard.Require(permissions.ServiceAccountsWrite))
+		r.Post("/", serviceAccountHandler.Create)
+		r.Group(func(r chi.Router) {
+			r.Use(serviceAccountHandler.ServiceAccountCtx)
+			r.Put("/{id}", serviceAccountHandler.Update)
+			r.Delete("/{id}", serviceAccountHandler.Delete)
+			// memberships change the groups as well
+			r.With(guard.Require(permissions.GroupsWrite)).Post("/{id}/groups", serviceAccountHandler.AddGroups)
+			r.With(guard.Require(permissions.GroupsWrite)).Delete("/{id}/groups", serviceAccountHandler.RemoveGroups)
+			r.Post("/{id}/permissions", serviceAccountHandler.AddPermissions)
+			r.Delete("/{id}/permissions", serviceAccountHandler.RemovePermissions)
+		})
+	})
+
+	return r
+}
+
+// checkRouter serves the permission checks of the organization's users and
+// service accounts
+func checkRouter(organizationHandler organizations.Handler, checkHandler checks.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(auth.RequireScope(auth.ScopeRead))
+	r.Use(organizationHandler.OrganizationCtx)
+
+	r.Post("/", checkHandler.Check)
+
+	return r
+}
+
+func apiKeyRouter(guard guard.Guard, organizationHandler organizations.Handler, apiKeyHandler apikeys.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(auth.RequireScope(auth.ScopeAdmin))
+	r.Use(organizationHandler.OrganizationCtx)
+	r.Use(guard.Require(permissions.APIKeysWrite))
+
+	r.Group(func(r chi.Router) {
+		r.Get("/", apiKeyHandler.List)
+		r.Post("/", apiKeyHandler.Create)
+		r.Delete("/{id}", apiKeyHandler.Revoke)
+	})
+
+	return r
+}
+
+func auditRouter(guard guard.Guard, organizationHandler organizations.Handler, auditHandler audit.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(auth.RequireScope(auth.ScopeRead))
+	r.Use(organizationHandler.OrganizationCtx)
+	r.Use(guard.Require(permissions.AuditRead))
+
+	r.Get("/", auditHandler.List)
+
+	return r
+}
+
+func webhookRouter(guard guard.Guard, organizationHandler organizations.Handler, webhookHandler webhooks.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(auth.RequireScope(auth.ScopeAdmin))
+	r.Use(organizationHandler.OrganizationCtx)
+	r.Use(guard.Require(permissions.WebhooksWrite))
+
+	r.Get("/", webhookHandler.List)
+	r.Post("/", webhookHandler.Create)
+	r.Group(func(r chi.Router) {
+		r.Use(webhookHandler.WebhookCtx)
+		r.Get("/{id}", webhookHandler.Get)
+		r.Put("/{id}", webhookHandler.Update)
+		r.Delete("/{id}", webhookHandler.Delete)
+		r.Get("/{id}/deliveries", webhookHandler.Deliveries)
+	})
+
+	return r
+}
+
+// scimRouter serves SCIM provisioning of the organization's users and groups,
+// identity providers are granted both the user and group write permissions
+func scimRouter(guard guard.Guard, organizationHandler organizations.Handler, scimHandler scim.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(auth.RequireScope(auth.ScopeWrite))
+	r.Use(organizationHandler.OrganizationCtx)
+	r.Use(guard.Require(permissions.UsersWrite))
+	r.Use(guard.Require(permissions.GroupsWrite))
+
+	r.Get("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+	r.Get("/ResourceTypes", scimHandler.ResourceTypes)
+	r.Post("/Bulk", scimHandler.Bulk)
+
+	r.Get("/Users", scimHandler.ListUsers)
+	r.Post("/Users", scimHandler.CreateUser)
+	r.Get("/Users/{id}", scimHandler.GetUser)
+	r.Put("/Users/{id}", scimHandler.ReplaceUser)
+	r.Patch("/Users/{id}", scimHandler.PatchUser)
+	r.Delete("/Users/{id}", scimHandler.DeleteUser)
+
+	r.Get("/Groups", scimHandler.ListGroups)
+	r.Post("/Groups", scimHandler.CreateGroup)
+	r.Get("/Groups/{id}", scimHandler.GetGroup)
+	r.Put("/Groups/{id}", scimHandler.ReplaceGroup)
+	r.Patch("/Groups/{id}", scimHandler.PatchGroup)
+	r.Delete("/Groups/{id}", scimHandler.DeleteGroup)
+
+	return r
+}
+
+func eventRouter(organizationHandler organizations.Handler, eventHandler stream.Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(organizationHandler.ResolveID("oid"))
+	r.Use(auth.RequireOrganization("oid"))
+	r.Use(auth.RequireScope(auth.ScopeRead))
+	r.Use(organizationHandler.OrganizationCtx)
+
+	r.Get("/", eventHandler.Events)
+
+	return r
+}
@@ -2,49 +2,47 @@ package server
 
 import (
 	"context"
+	"net"
 	"net/http"
-	"strconv"
 	"time"
 
-	"github.com/imtanmoy/authz/config"
-	"github.com/imtanmoy/authz/db"
 	"github.com/imtanmoy/authz/logger"
 )
 
 // Server provides an http.Server.
 type Server struct {
 	*http.Server
+	log logger.Logger
 }
 
-// NewServer creates and configures an APIServer serving all application routes.
-func NewServer() (*Server, error) {
-	logger.Info("configuring server...")
-	api, err := New()
-	if err != nil {
-		return nil, err
-	}
-
-	host := config.Conf.SERVER.HOST
-	port := strconv.Itoa(config.Conf.SERVER.PORT)
-	addr := host + ":" + port
+// NewServer creates and configures an APIServer serving the given handler on addr.
+// The contexts of requests are canceled once shutdown starts, so long lived
+// requests such as event streams end instead of holding up the shutdown.
+func NewServer(addr string, handler http.Handler, log logger.Logger) (*Server, error) {
+	log.Info("configuring server...")
 
+	baseCtx, cancel := context.WithCancel(context.Background())
 	srv := http.Server{
 		Addr:    addr,
-		Handler: api,
+		Handler: handler,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
+	srv.RegisterOnShutdown(cancel)
 
-	return &Server{&srv}, nil
+	return &Server{&srv, log}, nil
 }
 
 // Start runs ListenAndServe on the http.Server with graceful shutdown.
 func (srv *Server) Start(ctx context.Context) (err error) {
-	logger.Info("starting server...")
+	srv.log.Info("starting server...")
 	go func() {
 		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
-			logger.Fatalf("listen:%+s\n", err)
+			srv.log.Fatalf("listen:%+s\n", err)
 		}
 	}()
-	logger.Infof("Listening on %s\n", srv.Addr)
+	srv.log.Infof("Listening on %s\n", srv.Addr)
 
 	<-ctx.Done()
 
@@ -54,16 +52,12 @@ func (srv *Server) Start(ctx context.Context) (err error) {
 	}()
 
 	if err = srv.Shutdown(ctxShutDown); err != nil {
-		logger.Fatalf("server Shutdown Failed:%+s", err)
+		srv.log.Fatalf("server Shutdown Failed:%+s", err)
 	}
-	logger.Info("server exited properly")
+	srv.log.Info("server exited properly")
 
 	if err == http.ErrServerClosed {
 		err = nil
 	}
-	dbErr := db.Shutdown()
-	if dbErr != nil {
-		logger.Errorf("%s : %s", "Database shutdown failed", dbErr)
-	}
 	return
 }
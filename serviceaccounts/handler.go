@@ -0,0 +1,305 @@
+package serviceaccounts
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9"
+	param "github.com/oceanicdev/chi-param"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/utils"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+// Handler handles service accounts http method
+type Handler interface {
+	ServiceAccountCtx(next http.Handler) http.Handler
+	List(w http.ResponseWriter, r *http.Request)
+	Create(w http.ResponseWriter, r *http.Request)
+	Get(w http.ResponseWriter, r *http.Request)
+	Update(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+	AddGroups(w http.ResponseWriter, r *http.Request)
+	RemoveGroups(w http.ResponseWriter, r *http.Request)
+	AddPermissions(w http.ResponseWriter, r *http.Request)
+	RemovePermissions(w http.ResponseWriter, r *http.Request)
+}
+
+type serviceAccountHandler struct {
+	service             Service
+	organizationService organizations.Service
+	db                  *pg.DB
+}
+
+var _ Handler = (*serviceAccountHandler)(nil)
+
+// NewServiceAccountHandler construct service account handler
+func NewServiceAccountHandler(db *pg.DB, service Service, organizationService organizations.Service) Handler {
+	return &serviceAccountHandler{
+		service:             service,
+		organizationService: organizationService,
+		db:                  db,
+	}
+}
+
+func (s *serviceAccountHandler) ServiceAccountCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := param.Int32(r, "id")
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+			return
+		}
+		ctx := r.Context()
+		organization, ok := ctx.Value("organization").(*models.Organization)
+		if !ok {
+			_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+			return
+		}
+		serviceAccount, err := s.service.FindByIdAndOrganizationId(id, organization.ID)
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(404, "service account not found", err))
+			return
+		}
+		ctx = context.WithValue(r.Context(), "serviceAccount", serviceAccount)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *serviceAccountHandler) List(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	serviceAccounts, err := s.service.List(organization)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewServiceAccountListResponse(serviceAccounts)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
+func (s *serviceAccountHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	data := &ServiceAccountPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+
+	validationErrors := data.validate()
+	if _, err := s.service.FindByName(organization, data.Name); !errors.Is(err, pg.ErrNoRows) {
+		validationErrors.Add("name", "service account already exists")
+	}
+	groupList := s.findGroups(organization, data.Groups, validationErrors)
+	permissionList := s.findPermissions(organization, data.Permissions, validationErrors)
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	serviceAccount := &models.ServiceAccount{
+		OrganizationID: organization.ID,
+		Name:           data.Name,
+		Description:    data.Description,
+	}
+	serviceAccount, err := s.service.Create(ctx, serviceAccount, groupList, permissionList)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	render.Status(r, http.StatusCreated)
+	_ = render.Render(w, r, NewServiceAccountResponse(serviceAccount))
+}
+
+func (s *serviceAccountHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	serviceAccount, ok := ctx.Value("serviceAccount").(*models.ServiceAccount)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	if err := render.Render(w, r, NewServiceAccountResponse(serviceAccount)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
+// Update changes the name and description of the service account, its
+// groups and permissions are changed by their own endpoints
+func (s *serviceAccountHandler) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	serviceAccount, ok := ctx.Value("serviceAccount").(*models.ServiceAccount)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	data := &ServiceAccountPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+
+	validationErrors := data.validate()
+	if data.Name != serviceAccount.Name {
+		organization := &models.Organization{ID: serviceAccount.OrganizationID}
+		if _, err := s.service.FindByName(organization, data.Name); !errors.Is(err, pg.ErrNoRows) {
+			validationErrors.Add("name", "service account already exists")
+		}
+	}
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	serviceAccount.Name = data.Name
+	serviceAccount.Description = data.Description
+	if err := s.service.Update(ctx, serviceAccount); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	if err := render.Render(w, r, NewServiceAccountResponse(serviceAccount)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
+func (s *serviceAccountHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	serviceAccount, ok := ctx.Value("serviceAccount").(*models.ServiceAccount)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	if err := s.service.Delete(ctx, serviceAccount); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+func (s *serviceAccountHandler) AddGroups(w http.ResponseWriter, r *http.Request) {
+	s.changeGroups(w, r, s.service.AddGroups)
+}
+
+func (s *serviceAccountHandler) RemoveGroups(w http.ResponseWriter, r *http.Request) {
+	s.changeGroups(w, r, s.service.RemoveGroups)
+}
+
+func (s *serviceAccountHandler) AddPermissions(w http.ResponseWriter, r *http.Request) {
+	s.changePermissions(w, r, s.service.AddPermissions)
+}
+
+func (s *serviceAccountHandler) RemovePermissions(w http.ResponseWriter, r *http.Request) {
+	s.changePermissions(w, r, s.service.RemovePermissions)
+}
+
+// changeGroups applies change to the service account with the groups listed in the request
+func (s *serviceAccountHandler) changeGroups(w http.ResponseWriter, r *http.Request, change func(context.Context, *models.ServiceAccount, []*models.Group) error) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	serviceAccount, ok := ctx.Value("serviceAccount").(*models.ServiceAccount)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+
+	data := &ServiceAccountGroupsPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+
+	validationErrors := data.validate()
+	groupList := s.findGroups(organization, data.Groups, validationErrors)
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	if err := change(ctx, serviceAccount, groupList); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	_ = render.Render(w, r, NewServiceAccountResponse(serviceAccount))
+}
+
+// changePermissions applies change to the service account with the permissions listed in the request
+func (s *serviceAccountHandler) changePermissions(w http.ResponseWriter, r *http.Request, change func(context.Context, *models.ServiceAccount, []*models.Permission) error) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	serviceAccount, ok := ctx.Value("serviceAccount").(*models.ServiceAccount)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+
+	data := &ServiceAccountPermissionsPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+
+	validationErrors := data.validate()
+	permissionList := s.findPermissions(organization, data.Permissions, validationErrors)
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	if err := change(ctx, serviceAccount, permissionList); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	_ = render.Render(w, r, NewServiceAccountResponse(serviceAccount))
+}
+
+// findGroups returns the groups of the organization with groupIds, unknown
+// groups are reported in validationErrors
+func (s *serviceAccountHandler) findGroups(organization *models.Organization, groupIds []int32, validationErrors url.Values) []*models.Group {
+	groupList := make([]*models.Group, 0)
+	if groupIds = utils.Unique(groupIds); len(groupIds) > 0 {
+		// check if groups belongs to the organization
+		groupList, _ = s.service.FindGroupsByIds(organization, groupIds)
+		if len(groupList) != len(groupIds) {
+			validationErrors.Add("groups", "invalid group list")
+		}
+	}
+	return groupList
+}
+
+// findPermissions returns the permissions of the organization with
+// permissionIds, unknown permissions are reported in validationErrors
+func (s *serviceAccountHandler) findPermissions(organization *models.Organization, permissionIds []int32, validationErrors url.Values) []*models.Permission {
+	permissionList := make([]*models.Permission, 0)
+	if permissionIds = utils.Unique(permissionIds); len(permissionIds) > 0 {
+		// check if permissions belongs to the organization
+		permissionList, _ = s.organizationService.FindPermissionsByIds(organization, permissionIds)
+		if len(permissionList) != len(permissionIds) {
+			validationErrors.Add("permissions", "invalid permission list")
+		}
+	}
+	return permissionList
+}
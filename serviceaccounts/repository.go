@@ -0,0 +1,106 @@
+package serviceaccounts
+
+import (
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/models"
+)
+
+type Repository interface {
+	List(organizationId int32) ([]*models.ServiceAccount, error)
+	Create(tx *pg.Tx, serviceAccount *models.ServiceAccount) (*models.ServiceAccount, error)
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.ServiceAccount, error)
+	FindByName(organizationId int32, name string) (*models.ServiceAccount, error)
+	Update(tx *pg.Tx, serviceAccount *models.ServiceAccount) error
+	Delete(tx *pg.Tx, serviceAccount *models.ServiceAccount) error
+	// FindGroupsByIds returns the groups among ids which belong to the
+	// organization and are not deleted
+	FindGroupsByIds(organizationId int32, ids []int32) ([]*models.Group, error)
+	// FindAllByIdInOrganization returns the service accounts among ids which
+	// belong to the organization
+	FindAllByIdInOrganization(ids []int32, Oid int32) ([]*models.ServiceAccount, error)
+}
+
+type serviceAccountRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*serviceAccountRepository)(nil)
+
+func NewServiceAccountRepository(db *pg.DB) Repository {
+	return &serviceAccountRepository{
+		db,
+	}
+}
+
+func (s *serviceAccountRepository) List(organizationId int32) ([]*models.ServiceAccount, error) {
+	var serviceAccounts []*models.ServiceAccount
+	err := s.db.Model(&serviceAccounts).
+		Where("organization_id = ?", organizationId).
+		Order("id ASC").
+		Select()
+	return serviceAccounts, err
+}
+
+func (s *serviceAccountRepository) Create(tx *pg.Tx, serviceAccount *models.ServiceAccount) (*models.ServiceAccount, error) {
+	_, err := tx.Model(serviceAccount).Returning("*").Insert()
+	return serviceAccount, err
+}
+
+func (s *serviceAccountRepository) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.ServiceAccount, error) {
+	var serviceAccount models.ServiceAccount
+	err := s.db.Model(&serviceAccount).
+		Where("id = ?", Id).
+		Where("organization_id = ?", Oid).
+		First()
+	return &serviceAccount, err
+}
+
+func (s *serviceAccountRepository) FindByName(organizationId int32, name string) (*models.ServiceAccount, error) {
+	var serviceAccount models.ServiceAccount
+	err := s.db.Model(&serviceAccount).
+		Where("name = ?", name).
+		Where("organization_id = ?", organizationId).
+		First()
+	return &serviceAccount, err
+}
+
+func (s *serviceAccountRepository) Update(tx *pg.Tx, serviceAccount *models.ServiceAccount) error {
+	_, err := tx.Model(serviceAccount).
+		Set("name = ?name").
+		Set("description = ?description").
+		Set("updated_at = ?updated_at").
+		WherePK().
+		Update()
+	return err
+}
+
+func (s *serviceAccountRepository) Delete(tx *pg.Tx, serviceAccount *models.ServiceAccount) error {
+	_, err := tx.Model(serviceAccount).WherePK().Delete()
+	return err
+}
+
+func (s *serviceAccountRepository) FindGroupsByIds(organizationId int32, ids []int32) ([]*models.Group, error) {
+	groups := make([]*models.Group, 0)
+	if len(ids) == 0 {
+		return groups, nil
+	}
+	err := s.db.Model(&groups).
+		Where(`"group".id in (?)`, pg.In(ids)).
+		Where(`"group".organization_id = ?`, organizationId).
+		OrderExpr(`"group".id ASC`).
+		Select()
+	return groups, err
+}
+
+func (s *serviceAccountRepository) FindAllByIdInOrganization(ids []int32, Oid int32) ([]*models.ServiceAccount, error) {
+	serviceAccounts := make([]*models.ServiceAccount, 0)
+	if len(ids) == 0 {
+		return serviceAccounts, nil
+	}
+	err := s.db.Model(&serviceAccounts).
+		Where("id in (?)", pg.In(ids)).
+		Where("organization_id = ?", Oid).
+		Order("id ASC").
+		Select()
+	return serviceAccounts, err
+}
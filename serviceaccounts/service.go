@@ -0,0 +1,209 @@
+// Package serviceaccounts manages the service accounts of organizations,
+// principals of batch jobs and services which join groups and are granted
+// permissions like users and appear in policy lines as service::id.
+package serviceaccounts
+
+import (
+	"context"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/authorizer"
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/outbox"
+)
+
+type Service interface {
+	List(organization *models.Organization) ([]*models.ServiceAccount, error)
+	// Create creates the service account with its groups and permissions
+	Create(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group, permissions []*models.Permission) (*models.ServiceAccount, error)
+	// FindByIdAndOrganizationId finds the service account with its groups and permissions
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.ServiceAccount, error)
+	FindByName(organization *models.Organization, name string) (*models.ServiceAccount, error)
+	Update(ctx context.Context, serviceAccount *models.ServiceAccount) error
+	// Delete deletes the service account and revokes its memberships and grants
+	Delete(ctx context.Context, serviceAccount *models.ServiceAccount) error
+	// FindGroupsByIds returns the groups among ids which belong to the organization
+	FindGroupsByIds(organization *models.Organization, ids []int32) ([]*models.Group, error)
+	AddGroups(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group) error
+	RemoveGroups(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group) error
+	AddPermissions(ctx context.Context, serviceAccount *models.ServiceAccount, permissions []*models.Permission) error
+	RemovePermissions(ctx context.Context, serviceAccount *models.ServiceAccount, permissions []*models.Permission) error
+}
+
+type serviceAccountService struct {
+	db                *pg.DB
+	repository        Repository
+	authorizerService authorizer.Service
+	auditService      audit.Service
+	outboxRepository  outbox.Repository
+}
+
+var _ Service = (*serviceAccountService)(nil)
+
+func NewServiceAccountService(db *pg.DB, authorizerService authorizer.Service, auditService audit.Service) Service {
+	return &serviceAccountService{
+		db:                db,
+		repository:        NewServiceAccountRepository(db),
+		authorizerService: authorizerService,
+		auditService:      auditService,
+		outboxRepository:  outbox.NewOutboxRepository(db),
+	}
+}
+
+func (s *serviceAccountService) List(organization *models.Organization) ([]*models.ServiceAccount, error) {
+	return s.repository.List(organization.ID)
+}
+
+func (s *serviceAccountService) Create(
+	ctx context.Context,
+	serviceAccount *models.ServiceAccount,
+	groups []*models.Group,
+	permissions []*models.Permission,
+) (*models.ServiceAccount, error) {
+	err := s.db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := s.repository.Create(tx, serviceAccount); err != nil {
+			return err
+		}
+		return s.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: serviceAccount.OrganizationID,
+			Action:         audit.ServiceAccountCreated,
+			TargetType:     audit.TargetServiceAccount,
+			TargetID:       serviceAccount.ID,
+			After:          auditServiceAccount(serviceAccount),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.authorizerService.AddGroupsForServiceAccount(ctx, serviceAccount, groups)
+	if err != nil {
+		return nil, err
+	}
+	err = s.authorizerService.AddPermissionsForServiceAccount(ctx, serviceAccount, permissions)
+	if err != nil {
+		return nil, err
+	}
+	serviceAccount.Groups = groups
+	serviceAccount.Permissions = permissions
+	return serviceAccount, nil
+}
+
+func (s *serviceAccountService) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.ServiceAccount, error) {
+	serviceAccount, err := s.repository.FindByIdAndOrganizationId(Id, Oid)
+	if err != nil {
+		return nil, err
+	}
+	return serviceAccount, s.loadPolicies(serviceAccount)
+}
+
+func (s *serviceAccountService) FindByName(organization *models.Organization, name string) (*models.ServiceAccount, error) {
+	return s.repository.FindByName(organization.ID, name)
+}
+
+func (s *serviceAccountService) Update(ctx context.Context, serviceAccount *models.ServiceAccount) error {
+	before, err := s.repository.FindByIdAndOrganizationId(serviceAccount.ID, serviceAccount.OrganizationID)
+	if err != nil {
+		return err
+	}
+	return s.db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := s.repository.Update(tx, serviceAccount); err != nil {
+			return err
+		}
+		return s.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: serviceAccount.OrganizationID,
+			Action:         audit.ServiceAccountUpdated,
+			TargetType:     audit.TargetServiceAccount,
+			TargetID:       serviceAccount.ID,
+			Before:         auditServiceAccount(before),
+			After:          auditServiceAccount(serviceAccount),
+		})
+	})
+}
+
+func (s *serviceAccountService) Delete(ctx context.Context, serviceAccount *models.ServiceAccount) error {
+	err := s.db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := s.repository.Delete(tx, serviceAccount); err != nil {
+			return err
+		}
+		event, err := events.New(events.ServiceAccountDeleted, serviceAccount.OrganizationID, map[string]interface{}{
+			"service_account_id": serviceAccount.ID,
+			"name":               serviceAccount.Name,
+		})
+		if err != nil {
+			return err
+		}
+		if err := s.outboxRepository.Add(tx, event); err != nil {
+			return err
+		}
+		return s.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: serviceAccount.OrganizationID,
+			Action:         audit.ServiceAccountDeleted,
+			TargetType:     audit.TargetServiceAccount,
+			TargetID:       serviceAccount.ID,
+			Before:         auditServiceAccount(serviceAccount),
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return s.authorizerService.DeleteServiceAccount(ctx, serviceAccount)
+}
+
+func (s *serviceAccountService) FindGroupsByIds(organization *models.Organization, ids []int32) ([]*models.Group, error) {
+	return s.repository.FindGroupsByIds(organization.ID, ids)
+}
+
+func (s *serviceAccountService) AddGroups(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group) error {
+	err := s.authorizerService.AddGroupsForServiceAccount(ctx, serviceAccount, groups)
+	if err != nil {
+		return err
+	}
+	return s.loadPolicies(serviceAccount)
+}
+
+func (s *serviceAccountService) RemoveGroups(ctx context.Context, serviceAccount *models.ServiceAccount, groups []*models.Group) error {
+	err := s.authorizerService.RemoveGroupsForServiceAccount(ctx, serviceAccount, groups)
+	if err != nil {
+		return err
+	}
+	return s.loadPolicies(serviceAccount)
+}
+
+func (s *serviceAccountService) AddPermissions(ctx context.Context, serviceAccount *models.ServiceAccount, permissions []*models.Permission) error {
+	err := s.authorizerService.AddPermissionsForServiceAccount(ctx, serviceAccount, permissions)
+	if err != nil {
+		return err
+	}
+	return s.loadPolicies(serviceAccount)
+}
+
+func (s *serviceAccountService) RemovePermissions(ctx context.Context, serviceAccount *models.ServiceAccount, permissions []*models.Permission) error {
+	err := s.authorizerService.RemovePermissionsForServiceAccount(ctx, serviceAccount, permissions)
+	if err != nil {
+		return err
+	}
+	return s.loadPolicies(serviceAccount)
+}
+
+// loadPolicies loads the groups and the directly granted permissions of the
+// service account from the policy
+func (s *serviceAccountService) loadPolicies(serviceAccount *models.ServiceAccount) error {
+	groupIds, err := s.authorizerService.GetGroupIdsForServiceAccount(serviceAccount.ID)
+	if err != nil {
+		return err
+	}
+	serviceAccount.Groups, err = s.repository.FindGroupsByIds(serviceAccount.OrganizationID, groupIds)
+	if err != nil {
+		return err
+	}
+	serviceAccount.Permissions, err = s.authorizerService.GetPermissionsForServiceAccount(serviceAccount.ID)
+	return err
+}
+
+// auditServiceAccount returns the audited state of the service account
+func auditServiceAccount(serviceAccount *models.ServiceAccount) map[string]interface{} {
+	return map[string]interface{}{"name": serviceAccount.Name, "description": serviceAccount.Description}
+}
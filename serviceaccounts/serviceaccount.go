@@ -0,0 +1,143 @@
+package serviceaccounts
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/render"
+	"gopkg.in/thedevsaddam/govalidator.v1"
+
+	"github.com/imtanmoy/authz/identifier"
+	"github.com/imtanmoy/authz/models"
+)
+
+type ServiceAccountPayload struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Groups      []int32 `json:"groups"`
+	Permissions []int32 `json:"permissions"`
+}
+
+func (s *ServiceAccountPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (s *ServiceAccountPayload) validate() url.Values {
+	rules := govalidator.MapData{
+		"name":        []string{"required", "max:64"},
+		"description": []string{"max:255"},
+	}
+	opts := govalidator.Options{
+		Data:  s,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	return e
+}
+
+type ServiceAccountGroupsPayload struct {
+	Groups []int32 `json:"groups"`
+}
+
+func (s *ServiceAccountGroupsPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (s *ServiceAccountGroupsPayload) validate() url.Values {
+	rules := govalidator.MapData{
+		"groups": []string{"required"},
+	}
+	opts := govalidator.Options{
+		Data:  s,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	return e
+}
+
+type ServiceAccountPermissionsPayload struct {
+	Permissions []int32 `json:"permissions"`
+}
+
+func (s *ServiceAccountPermissionsPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (s *ServiceAccountPermissionsPayload) validate() url.Values {
+	rules := govalidator.MapData{
+		"permissions": []string{"required"},
+	}
+	opts := govalidator.Options{
+		Data:  s,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	return e
+}
+
+type groupResponse struct {
+	ID   int32  `json:"id"`
+	Name string `json:"name"`
+}
+
+type permissionResponse struct {
+	ID     int32  `json:"id"`
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Action string `json:"action"`
+}
+
+type ServiceAccountResponse struct {
+	ID          int32                 `json:"id"`
+	Subject     string                `json:"subject"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	Groups      []*groupResponse      `json:"groups,omitempty"`
+	Permissions []*permissionResponse `json:"permissions,omitempty"`
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   *time.Time            `json:"updated_at"`
+}
+
+func (s *ServiceAccountResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewServiceAccountResponse(serviceAccount *models.ServiceAccount) *ServiceAccountResponse {
+	resp := &ServiceAccountResponse{
+		ID:          serviceAccount.ID,
+		Subject:     identifier.ServiceAccountID(serviceAccount.ID).String(),
+		Name:        serviceAccount.Name,
+		Description: serviceAccount.Description,
+		CreatedAt:   serviceAccount.CreatedAt,
+	}
+	if !serviceAccount.UpdatedAt.IsZero() {
+		resp.UpdatedAt = &serviceAccount.UpdatedAt
+	}
+	for _, group := range serviceAccount.Groups {
+		resp.Groups = append(resp.Groups, &groupResponse{ID: group.ID, Name: group.Name})
+	}
+	for _, permission := range serviceAccount.Permissions {
+		resp.Permissions = append(resp.Permissions, &permissionResponse{
+			ID:     permission.ID,
+			Name:   permission.Name,
+			Type:   permission.Type,
+			Action: permission.Action,
+		})
+	}
+	return resp
+}
+
+func NewServiceAccountListResponse(serviceAccounts []*models.ServiceAccount) []render.Renderer {
+	list := make([]render.Renderer, 0, len(serviceAccounts))
+	for _, serviceAccount := range serviceAccounts {
+		list = append(list, NewServiceAccountResponse(serviceAccount))
+	}
+	return list
+}
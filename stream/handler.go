@@ -0,0 +1,115 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/render"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/httputil"
+)
+
+const (
+	// heartbeatInterval is how often idle streams send a comment to keep
+	// proxies from closing them
+	heartbeatInterval = 15 * time.Second
+	// retryInterval is how long clients wait before reconnecting
+	retryInterval = 3 * time.Second
+)
+
+// resetEvent tells clients they missed events and must reload their state
+const resetEvent = "reset"
+
+// Handler handles event stream http method
+type Handler interface {
+	Events(w http.ResponseWriter, r *http.Request)
+}
+
+type streamHandler struct {
+	hub Hub
+}
+
+var _ Handler = (*streamHandler)(nil)
+
+// NewStreamHandler construct event stream handler
+func NewStreamHandler(hub Hub) Handler {
+	return &streamHandler{
+		hub: hub,
+	}
+}
+
+// Events streams the events of the organization until the client goes away
+// or the server shuts down, a Last-Event-ID header resumes the stream
+func (s *streamHandler) Events(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(500, "Streaming is not supported"))
+		return
+	}
+
+	subscription := s.hub.Subscribe(organization.ID, r.Header.Get("Last-Event-ID"))
+	defer subscription.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", retryInterval.Milliseconds()); err != nil {
+		return
+	}
+	if subscription.Reset {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: {}\n\n", resetEvent); err != nil {
+			return
+		}
+	}
+	for _, entry := range subscription.Backlog {
+		if err := writeEntry(w, entry); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case entry, ok := <-subscription.Events():
+			if !ok {
+				// lagging clients reconnect and resume from the history
+				return
+			}
+			if err := writeEntry(w, entry); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEntry writes the entry as an SSE message named after the event type
+func writeEntry(w http.ResponseWriter, entry *Entry) error {
+	data, err := json.Marshal(entry.Event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", entry.ID, entry.Event.Type, data)
+	return err
+}
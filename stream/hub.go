@@ -0,0 +1,174 @@
+// Package stream serves the change events of an organization as a
+// Server-Sent Events stream, clients resume from the event history kept by
+// the hub after reconnecting.
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imtanmoy/authz/events"
+)
+
+// subscriberBuffer is the number of events a subscriber may lag behind
+// before it is dropped, dropped clients resume from the history
+const subscriberBuffer = 64
+
+// Entry is an event with its position in the stream
+type Entry struct {
+	// ID identifies the position of the event, it is sent as SSE id and
+	// received back as Last-Event-ID
+	ID    string
+	Event *events.Event
+}
+
+// Subscription receives the events of an organization published after it
+// was created
+type Subscription struct {
+	// Backlog holds the events of the history following the resumed event
+	Backlog []*Entry
+	// Reset reports that the resumed event is no longer in the history, the
+	// client missed events and must reload its state
+	Reset bool
+
+	organizationID int32
+	events         chan *Entry
+	hub            *hub
+}
+
+// Events returns the channel the published events are received from, it
+// is closed once the subscription is closed or lags too far behind
+func (s *Subscription) Events() <-chan *Entry {
+	return s.events
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// Hub is an events.Publisher keeping a bounded history of the published
+// events and fanning them out to subscriptions. The history only holds the
+// events published to this hub, events dispatched by other instances sharing
+// the outbox are not streamed.
+type Hub interface {
+	events.Publisher
+	// Subscribe subscribes to the events of the organization, lastEventID
+	// resumes after that event and is empty for new clients
+	Subscribe(organizationID int32, lastEventID string) *Subscription
+}
+
+type hub struct {
+	mu sync.Mutex
+	// epoch distinguishes the ids of this hub from the ids of earlier
+	// processes, their history is lost
+	epoch       string
+	seq         uint64
+	history     []*Entry
+	size        int
+	subscribers map[*Subscription]struct{}
+}
+
+var _ Hub = (*hub)(nil)
+
+// NewHub creates a hub keeping the last size events
+func NewHub(size int) Hub {
+	if size < 1 {
+		size = 1
+	}
+	return &hub{
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		history:     make([]*Entry, 0, size),
+		size:        size,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish appends the event to the history and sends it to the subscribers
+// of its organization, events already in the history are ignored as the
+// outbox may publish an event more than once
+func (h *hub) Publish(ctx context.Context, event *events.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, entry := range h.history {
+		if entry.Event.ID == event.ID {
+			return nil
+		}
+	}
+
+	h.seq++
+	entry := &Entry{ID: fmt.Sprintf("%s-%d", h.epoch, h.seq), Event: event}
+	if len(h.history) == h.size {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:len(h.history)-1]
+	}
+	h.history = append(h.history, entry)
+
+	for s := range h.subscribers {
+		if s.organizationID != event.OrganizationID {
+			continue
+		}
+		select {
+		case s.events <- entry:
+		default:
+			delete(h.subscribers, s)
+			close(s.events)
+		}
+	}
+	return nil
+}
+
+func (h *hub) Subscribe(organizationID int32, lastEventID string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &Subscription{
+		organizationID: organizationID,
+		events:         make(chan *Entry, subscriberBuffer),
+		hub:            h,
+	}
+	if lastEventID != "" {
+		start, ok := h.resume(lastEventID)
+		s.Reset = !ok
+		for _, entry := range h.history[start:] {
+			if entry.Event.OrganizationID == organizationID {
+				s.Backlog = append(s.Backlog, entry)
+			}
+		}
+	}
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// resume returns the index of the history following lastEventID and
+// whether no event was missed since lastEventID
+func (h *hub) resume(lastEventID string) (int, bool) {
+	parts := strings.SplitN(lastEventID, "-", 2)
+	if len(parts) != 2 || parts[0] != h.epoch {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil || seq > h.seq {
+		return 0, false
+	}
+	if seq == h.seq {
+		return len(h.history), true
+	}
+	oldest := h.seq - uint64(len(h.history)) + 1
+	if seq+1 < oldest {
+		return 0, false
+	}
+	return int(seq + 1 - oldest), true
+}
+
+func (h *hub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[s]; ok {
+		delete(h.subscribers, s)
+		close(s.events)
+	}
+}
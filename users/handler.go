@@ -4,17 +4,20 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
 	"github.com/go-pg/pg/v9"
 	"github.com/imtanmoy/authz/organizations"
+	"github.com/imtanmoy/authz/utils"
 	"github.com/imtanmoy/authz/utils/httputil"
-	param "github.com/oceanicdev/chi-param"
+	"github.com/imtanmoy/authz/utils/pagination"
 
 	"github.com/imtanmoy/authz/models"
 )
 
 type Handler interface {
 	UserCtx(next http.Handler) http.Handler
+	ListAll(w http.ResponseWriter, r *http.Request)
 	List(w http.ResponseWriter, r *http.Request)
 	Get(w http.ResponseWriter, r *http.Request)
 	Create(w http.ResponseWriter, r *http.Request)
@@ -33,44 +36,92 @@ type userHandler struct {
 
 var _ Handler = (*userHandler)(nil)
 
-func NewUserHandler(db *pg.DB) Handler {
+// memberConflict is the message of upserts of members belonging to another
+// organization, they are changed within their own organization
+const memberConflict = "user is a member from another organization, update it in its own organization"
+
+func NewUserHandler(db *pg.DB, service Service, organizationService organizations.Service) Handler {
 	return &userHandler{
 		db:                  db,
-		service:             NewUserService(db),
-		organizationService: organizations.NewOrganizationService(db),
+		service:             service,
+		organizationService: organizationService,
 	}
 }
 
+// UserCtx loads the user identified by the id url parameter, its id or its
+// external id, within the request organization
 func (u *userHandler) UserCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		id, err := param.Int32(r, "id")
-		if err != nil {
-			_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+		ctx := r.Context()
+		organization, ok := ctx.Value("organization").(*models.Organization)
+		if !ok {
+			_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
 			return
 		}
-		user, err := u.service.Find(id)
+		user, err := u.service.FindByIdentifier(chi.URLParam(r, "id"), organization.ID)
 		if err != nil {
 			_ = render.Render(w, r, httputil.NewAPIError(404, "user not found", err))
 			return
 		}
-		ctx := context.WithValue(r.Context(), "user", user)
+		ctx = context.WithValue(ctx, "user", user)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// ListAll lists the users of every organization
+func (u *userHandler) ListAll(w http.ResponseWriter, r *http.Request) {
+	params, validationErrors := pagination.ParseParams(r, "email", "created_at")
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+	users, err := u.service.List(params)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	n, page := params.Page(len(users), func(i int) (int32, interface{}) {
+		return users[i].ID, userSortValue(users[i], params.Sort)
+	})
+	if err := render.Render(w, r, pagination.NewListResponse(NewUserListResponse(users[:n]), page)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
 func (u *userHandler) List(w http.ResponseWriter, r *http.Request) {
-	users, err := u.service.List()
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	params, validationErrors := pagination.ParseParams(r, "email", "created_at")
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+	users, err := u.service.ListByOrganization(organization, params)
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
-	if err := render.RenderList(w, r, NewUserListResponse(users)); err != nil {
+	n, page := params.Page(len(users), func(i int) (int32, interface{}) {
+		return users[i].ID, userSortValue(users[i], params.Sort)
+	})
+	if err := render.Render(w, r, pagination.NewListResponse(NewUserListResponse(users[:n]), page)); err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
 }
 
 func (u *userHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
 
 	data := &UserPayload{}
 	if err := render.Bind(r, data); err != nil {
@@ -84,37 +135,29 @@ func (u *userHandler) Create(w http.ResponseWriter, r *http.Request) {
 		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
 		return
 	}
-	exist := u.service.Exists(data.ID)
-	orgExist := u.organizationService.Exists(data.OrganizationID)
-	existErr := make(map[string][]string)
-	if exist {
-		existErr = map[string][]string{
+	if data.ID != 0 && u.service.Exists(data.ID) {
+		existErr := map[string][]string{
 			"id": {"User with same id already exits"},
 		}
-	}
-	if !orgExist {
-		existErr = map[string][]string{
-			"organization_id": {"organization does not exist"},
-		}
-	}
-	if len(existErr) > 0 {
 		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", existErr))
 		return
 	}
-
-	organization, err := u.organizationService.Find(data.OrganizationID)
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(err))
+	if data.ExternalID != "" && u.service.ExternalIdExists(organization.ID, data.ExternalID) {
+		existErr := map[string][]string{
+			"external_id": {"User with same external_id already exits"},
+		}
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", existErr))
 		return
 	}
 
 	var user models.User
 	user.ID = data.ID
+	user.ExternalID = data.ExternalID
 	user.Email = data.Email
 	user.Organization = organization
 	user.OrganizationID = organization.ID
 
-	newUser, err := u.service.Create(&user)
+	newUser, err := u.service.Create(ctx, &user)
 
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
@@ -127,14 +170,10 @@ func (u *userHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (u *userHandler) Get(w http.ResponseWriter, r *http.Request) {
-	id, err := param.Int32(r, "id")
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
-		return
-	}
-	user, err := u.service.Find(id)
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(404, "user not found", err))
+	ctx := r.Context()
+	user, ok := ctx.Value("user").(*models.User)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
 		return
 	}
 	if err := render.Render(w, r, NewUserResponse(user)); err != nil {
@@ -143,10 +182,15 @@ func (u *userHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Update creates or replaces the user identified by the id url parameter,
+// its id or its external id, responding 201 when it was created and 200 when
+// it already existed, so retried requests are idempotent. Users created by
+// external id get a generated id.
 func (u *userHandler) Update(w http.ResponseWriter, r *http.Request) {
-	id, err := param.Int32(r, "id")
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
 		return
 	}
 
@@ -155,6 +199,14 @@ func (u *userHandler) Update(w http.ResponseWriter, r *http.Request) {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
+	// the id or the external id is taken from the url
+	id, externalID := utils.ParseID(chi.URLParam(r, "id"))
+	if externalID != "" {
+		data.ID = 0
+		data.ExternalID = externalID
+	} else {
+		data.ID = id
+	}
 
 	validationErrors := data.validate()
 
@@ -162,37 +214,82 @@ func (u *userHandler) Update(w http.ResponseWriter, r *http.Request) {
 		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
 		return
 	}
+	if externalID != "" {
+		// a member from another organization is not created again
+		member, err := u.service.FindByIdentifier(externalID, organization.ID)
+		if err == nil && member.OrganizationID != organization.ID {
+			_ = render.Render(w, r, httputil.NewAPIError(409, memberConflict))
+			return
+		}
+	}
 
-	user, err := u.service.Find(id)
+	user, created, err := u.service.FirstOrCreate(ctx, &models.User{
+		ID:             data.ID,
+		ExternalID:     data.ExternalID,
+		Email:          data.Email,
+		OrganizationID: organization.ID,
+		Organization:   organization,
+	})
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
-
-	user.Email = data.Email
-	user, err = u.service.Update(user)
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(err))
+	if user.OrganizationID != organization.ID {
+		if _, err := u.service.FindByIdAndOrganizationId(user.ID, organization.ID); err == nil {
+			_ = render.Render(w, r, httputil.NewAPIError(409, memberConflict))
+			return
+		}
+		// user ids are unique across organizations
+		existErr := map[string][]string{
+			"id": {"User with same id already exits"},
+		}
+		_ = render.Render(w, r, httputil.NewAPIError(409, "Invalid request", existErr))
+		return
+	}
+	if created {
+		render.Status(r, http.StatusCreated)
+		_ = render.Render(w, r, NewUserResponse(user))
 		return
 	}
+
+	// an omitted external id keeps the external id of the user
+	if user.Email != data.Email || (data.ExternalID != "" && user.ExternalID != data.ExternalID) {
+		user.Email = data.Email
+		if data.ExternalID != "" {
+			user.ExternalID = data.ExternalID
+		}
+		user, err = u.service.Update(ctx, user)
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(err))
+			return
+		}
+	}
 	if err := render.Render(w, r, NewUserResponse(user)); err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
 	}
 }
 
+// Delete deletes the user from every organization, members of the request
+// organization belonging to another organization are removed through the
+// members endpoint instead
 func (u *userHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	id, err := param.Int32(r, "id")
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
 		return
 	}
-	user, err := u.service.Find(id)
-	if err != nil {
-		_ = render.Render(w, r, httputil.NewAPIError(err))
+	user, ok := ctx.Value("user").(*models.User)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	if user.OrganizationID != organization.ID {
+		_ = render.Render(w, r, httputil.NewAPIError(409, "user belongs to another organization, remove its membership instead"))
 		return
 	}
-	err = u.service.Delete(user)
+	err := u.service.Delete(ctx, user)
 	if err != nil {
 		_ = render.Render(w, r, httputil.NewAPIError(err))
 		return
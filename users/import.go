@@ -0,0 +1,127 @@
+package users
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxImportRows bounds the rows of an import file
+const MaxImportRows = 10000
+
+// ImportRow is a user of an import file with the ids of its groups
+type ImportRow struct {
+	// Row is the 1-based position of the row after the header
+	Row    int
+	ID     int32
+	Email  string
+	Groups []int32
+}
+
+// RowErrors collects the validation errors of an import file by row, keyed
+// "row <n>"
+type RowErrors url.Values
+
+// Add adds the validation message of the row
+func (e RowErrors) Add(row int, message string) {
+	url.Values(e).Add(fmt.Sprintf("row %d", row), message)
+}
+
+// ParseImport reads an import file, a CSV file with an id and an email
+// column and an optional groups column listing group ids separated by ";".
+// Every row is validated like the payload of a created user, the rows are
+// only returned when no row is invalid.
+func ParseImport(r io.Reader) ([]*ImportRow, RowErrors, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, errors.New("import file is empty")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range []string{"id", "email"} {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("import file has no %s column", name)
+		}
+	}
+	groupsColumn, hasGroups := columns["groups"]
+
+	rows := make([]*ImportRow, 0)
+	rowErrors := make(RowErrors)
+	ids := make(map[int32]int)
+	emails := make(map[string]int)
+	reader.FieldsPerRecord = len(header)
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) && errors.Is(parseErr.Err, csv.ErrFieldCount) {
+			rowErrors.Add(n, fmt.Sprintf("The row must have %d fields", len(header)))
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if n > MaxImportRows {
+			return nil, nil, fmt.Errorf("import file has more than %d rows", MaxImportRows)
+		}
+
+		row := &ImportRow{Row: n, Email: strings.TrimSpace(record[columns["email"]])}
+		data := &UserPayload{Email: row.Email}
+		if value := strings.TrimSpace(record[columns["id"]]); value != "" {
+			id, err := strconv.ParseInt(value, 10, 32)
+			if err != nil || id <= 0 {
+				rowErrors.Add(n, "The id field must be a positive integer")
+				continue
+			}
+			data.ID = int32(id)
+		}
+		for _, messages := range data.validate() {
+			for _, message := range messages {
+				rowErrors.Add(n, message)
+			}
+		}
+		row.ID = data.ID
+		if first, ok := ids[row.ID]; ok && row.ID != 0 {
+			rowErrors.Add(n, fmt.Sprintf("The id is already used by row %d", first))
+		} else {
+			ids[row.ID] = n
+		}
+		email := strings.ToLower(row.Email)
+		if first, ok := emails[email]; ok && email != "" {
+			rowErrors.Add(n, fmt.Sprintf("The email is already used by row %d", first))
+		} else {
+			emails[email] = n
+		}
+		if hasGroups {
+			for _, value := range strings.Split(record[groupsColumn], ";") {
+				if value = strings.TrimSpace(value); value == "" {
+					continue
+				}
+				id, err := strconv.ParseInt(value, 10, 32)
+				if err != nil {
+					rowErrors.Add(n, fmt.Sprintf("The group %q must be a group id", value))
+					continue
+				}
+				row.Groups = append(row.Groups, int32(id))
+			}
+		}
+		rows = append(rows, row)
+	}
+	if len(rowErrors) > 0 {
+		return nil, rowErrors, nil
+	}
+	return rows, nil, nil
+}
@@ -3,18 +3,54 @@ package users
 import (
 	"errors"
 	"github.com/go-pg/pg/v9"
+	"github.com/go-pg/pg/v9/orm"
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/pagination"
 )
 
+// sortColumns maps the sortable fields of users to their columns
+var sortColumns = map[string]string{
+	"id":         `"user".id`,
+	"email":      `"user".email`,
+	"created_at": `"user".created_at`,
+}
+
+// memberOf matches the users belonging to the organization ?0 and the users
+// invited as its members
+const memberOf = `("user".organization_id = ?0 OR "user".id IN (SELECT user_id FROM organization_members WHERE organization_id = ?0))`
+
 type Repository interface {
-	List() ([]*models.User, error)
+	List(params *pagination.Params) ([]*models.User, error)
+	// ListByOrganization lists the users belonging to the organization and its members
+	ListByOrganization(organizationId int32, params *pagination.Params) ([]*models.User, error)
 	Find(ID int32) (*models.User, error)
+	// FindByIdAndOrganizationId finds the user if it belongs to the
+	// organization or is a member of it
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.User, error)
+	// FindByExternalIdAndOrganizationId finds the user belonging to the
+	// organization or member of it by its external id, the organization's own
+	// user wins when a member has the same external id
+	FindByExternalIdAndOrganizationId(externalId string, Oid int32) (*models.User, error)
 	Create(tx *pg.Tx, user *models.User) (*models.User, error)
-	FirstOrCreate(tx *pg.Tx, user *models.User) (*models.User, error)
+	// CreateAll inserts the users with one statement
+	CreateAll(tx *pg.Tx, users []*models.User) error
+	// FirstOrCreate inserts the user unless a user with its id exists, which
+	// is returned instead, created reports whether the user was inserted. A
+	// user without id is matched by its external id within its organization.
+	FirstOrCreate(tx *pg.Tx, user *models.User) (u *models.User, created bool, err error)
 	Update(tx *pg.Tx, user *models.User) (*models.User, error)
 	Delete(tx *pg.Tx, user *models.User) error
 	Exists(ID int32) bool
+	// ExternalIdExists reports whether a user of the organization has the
+	// external id
+	ExternalIdExists(Oid int32, externalId string) bool
 	FindAllByIdIn(ids []int32) []*models.User
+	// FindAllByIdInOrganization returns the users among ids which belong to
+	// the organization or are members of it
+	FindAllByIdInOrganization(ids []int32, Oid int32) ([]*models.User, error)
+	// ExistingIds returns the ids among ids which are used by a user of any
+	// organization
+	ExistingIds(ids []int32) ([]int32, error)
 }
 
 type userRepository struct {
@@ -29,12 +65,33 @@ func NewUserRepository(db *pg.DB) Repository {
 	}
 }
 
-func (u *userRepository) List() ([]*models.User, error) {
+func (u *userRepository) List(params *pagination.Params) ([]*models.User, error) {
+	var users []*models.User
+	q := filter(u.db.Model(&users).Relation("Organization"), params)
+	err := params.Apply(q, sortColumns[params.Sort], sortColumns["id"]).Select()
+	return users, err
+}
+
+func (u *userRepository) ListByOrganization(organizationId int32, params *pagination.Params) ([]*models.User, error) {
 	var users []*models.User
-	err := u.db.Model(&users).Relation("Organization").Select()
+	q := u.db.Model(&users).
+		Where(memberOf, organizationId).
+		Relation("Organization")
+	err := params.Apply(filter(q, params), sortColumns[params.Sort], sortColumns["id"]).Select()
 	return users, err
 }
 
+// filter applies the user filters of params to q
+func filter(q *orm.Query, params *pagination.Params) *orm.Query {
+	if params.Email != "" {
+		q = q.Where(`"user".email ILIKE ?`, pagination.Contains(params.Email))
+	}
+	if !params.CreatedAfter.IsZero() {
+		q = q.Where(`"user".created_at > ?`, params.CreatedAfter)
+	}
+	return q
+}
+
 func (u *userRepository) Find(ID int32) (*models.User, error) {
 	if !u.Exists(ID) {
 		return nil, errors.New("user does not exists")
@@ -44,22 +101,71 @@ func (u *userRepository) Find(ID int32) (*models.User, error) {
 	return &user, err
 }
 
+func (u *userRepository) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.User, error) {
+	var user models.User
+	err := u.db.Model(&user).
+		Where("\"user\".id = ?", Id).
+		Where(memberOf, Oid).
+		Relation("Organization").Select()
+	return &user, err
+}
+
+func (u *userRepository) FindByExternalIdAndOrganizationId(externalId string, Oid int32) (*models.User, error) {
+	var user models.User
+	// external ids are unique within the organization of the user only, the
+	// organization's own user is preferred over a member with the same one
+	err := u.db.Model(&user).
+		Where("\"user\".external_id = ?", externalId).
+		Where(memberOf, Oid).
+		OrderExpr("\"user\".organization_id = ? DESC", Oid).
+		Limit(1).
+		Relation("Organization").Select()
+	return &user, err
+}
+
 func (u *userRepository) Create(tx *pg.Tx, user *models.User) (*models.User, error) {
-	_, err := u.db.Model(user).Returning("*").Insert()
+	_, err := tx.Model(user).Returning("*").Insert()
 	return user, err
 }
 
-func (u *userRepository) FirstOrCreate(tx *pg.Tx, user *models.User) (*models.User, error) {
-	panic("implement me")
+func (u *userRepository) CreateAll(tx *pg.Tx, users []*models.User) error {
+	_, err := tx.Model(&users).Insert()
+	return err
+}
+
+func (u *userRepository) FirstOrCreate(tx *pg.Tx, user *models.User) (*models.User, bool, error) {
+	// an insert racing with a concurrent insert of the id waits for it to
+	// commit and inserts nothing, the select then sees the committed user
+	conflict := "(id) DO NOTHING"
+	if user.ID == 0 {
+		conflict = "(organization_id, external_id) DO NOTHING"
+	}
+	res, err := tx.Model(user).OnConflict(conflict).Returning("*").Insert()
+	if err != nil && !errors.Is(err, pg.ErrNoRows) {
+		return nil, false, err
+	}
+	if err == nil && res.RowsAffected() == 1 {
+		return user, true, nil
+	}
+	existing := new(models.User)
+	q := tx.Model(existing).Relation("Organization")
+	if user.ID == 0 {
+		q = q.Where("\"user\".external_id = ?", user.ExternalID).
+			Where("\"user\".organization_id = ?", user.OrganizationID)
+	} else {
+		q = q.Where("\"user\".id = ?", user.ID)
+	}
+	err = q.Select()
+	return existing, false, err
 }
 
 func (u *userRepository) Update(tx *pg.Tx, user *models.User) (*models.User, error) {
-	err := u.db.Update(user)
+	_, err := tx.Model(user).WherePK().Update()
 	return user, err
 }
 
 func (u *userRepository) Delete(tx *pg.Tx, user *models.User) error {
-	err := u.db.Delete(user)
+	_, err := tx.Model(user).WherePK().Delete()
 	return err
 }
 
@@ -72,10 +178,42 @@ func (u *userRepository) Exists(ID int32) bool {
 	return num == ID
 }
 
+func (u *userRepository) ExternalIdExists(Oid int32, externalId string) bool {
+	exists, err := u.db.Model((*models.User)(nil)).
+		Where("\"user\".external_id = ?", externalId).
+		Where("\"user\".organization_id = ?", Oid).
+		Exists()
+	if err != nil {
+		panic(err)
+	}
+	return exists
+}
+
 func (u *userRepository) FindAllByIdIn(ids []int32) []*models.User {
 	var users []*models.User
 	_ = u.db.Model(&users). // TODO err handling
 		Where("id in (?)", pg.In(ids)).
 		Select()
 	return users
-}
\ No newline at end of file
+}
+
+func (u *userRepository) FindAllByIdInOrganization(ids []int32, Oid int32) ([]*models.User, error) {
+	users := make([]*models.User, 0)
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := u.db.Model(&users).
+		Where("\"user\".id in (?)", pg.In(ids)).
+		Where(memberOf, Oid).
+		Select()
+	return users, err
+}
+
+func (u *userRepository) ExistingIds(ids []int32) ([]int32, error) {
+	existing := make([]int32, 0)
+	if len(ids) == 0 {
+		return existing, nil
+	}
+	_, err := u.db.Query(&existing, "SELECT id FROM users WHERE id IN (?)", pg.In(ids))
+	return existing, err
+}
@@ -1,76 +1,232 @@
 package users
 
 import (
+	"context"
+
 	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/audit"
+	"github.com/imtanmoy/authz/events"
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/outbox"
+	"github.com/imtanmoy/authz/utils"
+	"github.com/imtanmoy/authz/utils/pagination"
 )
 
 type Service interface {
-	List() ([]*models.User, error)
+	List(params *pagination.Params) ([]*models.User, error)
+	ListByOrganization(organization *models.Organization, params *pagination.Params) ([]*models.User, error)
 	Find(ID int32) (*models.User, error)
-	Create(organization *models.User) (*models.User, error)
-	FirstOrCreate(organization *models.User) (*models.User, error)
-	Update(organization *models.User) (*models.User, error)
-	Delete(organization *models.User) error
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.User, error)
+	// FindByIdentifier finds the user of the organization by its internal id
+	// or its external id, see utils.ParseID
+	FindByIdentifier(identifier string, Oid int32) (*models.User, error)
+	Create(ctx context.Context, organization *models.User) (*models.User, error)
+	// FirstOrCreate creates the user unless a user with its id exists, which
+	// is returned instead, created reports whether the user was created. A
+	// user without id is matched by its external id within its organization.
+	FirstOrCreate(ctx context.Context, user *models.User) (u *models.User, created bool, err error)
+	// CreateAll creates the users in one transaction
+	CreateAll(ctx context.Context, users []*models.User) error
+	Update(ctx context.Context, organization *models.User) (*models.User, error)
+	Delete(ctx context.Context, organization *models.User) error
 	Exists(ID int32) bool
+	ExternalIdExists(Oid int32, externalId string) bool
 	FindAllByIdIn(ids []int32) []*models.User
+	// ExistingIds returns the ids among ids which are used by a user of any
+	// organization
+	ExistingIds(ids []int32) ([]int32, error)
 
 	GetGroups(user *models.User) ([]*models.Group, error)
 	GetPermissions(user *models.User) ([]*models.Permission, error)
 }
 
 type userService struct {
-	db                *pg.DB
-	repository        Repository
+	db               *pg.DB
+	repository       Repository
+	auditService     audit.Service
+	outboxRepository outbox.Repository
 }
 
 var _ Service = (*userService)(nil)
 
-func NewUserService(db *pg.DB) Service {
+func NewUserService(db *pg.DB, auditService audit.Service) Service {
 	return &userService{
-		repository: NewUserRepository(db),
-		db:         db,
+		repository:       NewUserRepository(db),
+		db:               db,
+		auditService:     auditService,
+		outboxRepository: outbox.NewOutboxRepository(db),
 	}
 }
 
-func (u *userService) List() ([]*models.User, error) {
-	return u.repository.List()
+func (u *userService) List(params *pagination.Params) ([]*models.User, error) {
+	return u.repository.List(params)
+}
+
+func (u *userService) ListByOrganization(organization *models.Organization, params *pagination.Params) ([]*models.User, error) {
+	return u.repository.ListByOrganization(organization.ID, params)
 }
 
 func (u *userService) Exists(ID int32) bool {
 	return u.repository.Exists(ID)
 }
 
+func (u *userService) ExternalIdExists(Oid int32, externalId string) bool {
+	return u.repository.ExternalIdExists(Oid, externalId)
+}
+
 func (u *userService) Find(ID int32) (*models.User, error) {
 	return u.repository.Find(ID)
 }
 
-func (u *userService) Create(user *models.User) (*models.User, error) {
+func (u *userService) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.User, error) {
+	return u.repository.FindByIdAndOrganizationId(Id, Oid)
+}
+
+func (u *userService) FindByIdentifier(identifier string, Oid int32) (*models.User, error) {
+	id, externalId := utils.ParseID(identifier)
+	if externalId != "" {
+		return u.repository.FindByExternalIdAndOrganizationId(externalId, Oid)
+	}
+	return u.repository.FindByIdAndOrganizationId(id, Oid)
+}
+
+func (u *userService) Create(ctx context.Context, user *models.User) (*models.User, error) {
 	tx, _ := u.db.Begin()
-	defer tx.Commit()
-	return u.repository.Create(tx, user)
+	user, err := u.repository.Create(tx, user)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = u.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: user.OrganizationID,
+		Action:         audit.UserCreated,
+		TargetType:     audit.TargetUser,
+		TargetID:       user.ID,
+		After:          auditUser(user),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return user, tx.Commit()
 }
 
-func (u *userService) FirstOrCreate(organization *models.User) (*models.User, error) {
-	panic("implement me")
+func (u *userService) CreateAll(ctx context.Context, users []*models.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+	tx, _ := u.db.Begin()
+	if err := u.repository.CreateAll(tx, users); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	for _, user := range users {
+		err := u.auditService.Record(ctx, tx, &audit.Change{
+			OrganizationID: user.OrganizationID,
+			Action:         audit.UserCreated,
+			TargetType:     audit.TargetUser,
+			TargetID:       user.ID,
+			After:          auditUser(user),
+		})
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (u *userService) Update(user *models.User) (*models.User, error) {
+func (u *userService) FirstOrCreate(ctx context.Context, user *models.User) (*models.User, bool, error) {
 	tx, _ := u.db.Begin()
-	defer tx.Commit()
-	return u.repository.Update(tx, user)
+	user, created, err := u.repository.FirstOrCreate(tx, user)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, false, err
+	}
+	if !created {
+		if err := tx.Commit(); err != nil {
+			return nil, false, err
+		}
+		return user, false, nil
+	}
+	err = u.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: user.OrganizationID,
+		Action:         audit.UserCreated,
+		TargetType:     audit.TargetUser,
+		TargetID:       user.ID,
+		After:          auditUser(user),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, false, err
+	}
+	return user, true, tx.Commit()
 }
 
-func (u *userService) Delete(user *models.User) error {
+func (u *userService) Update(ctx context.Context, user *models.User) (*models.User, error) {
+	before, err := u.repository.Find(user.ID)
+	if err != nil {
+		return nil, err
+	}
 	tx, _ := u.db.Begin()
-	defer tx.Commit()
-	return u.repository.Delete(tx, user)
+	user, err = u.repository.Update(tx, user)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	err = u.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: user.OrganizationID,
+		Action:         audit.UserUpdated,
+		TargetType:     audit.TargetUser,
+		TargetID:       user.ID,
+		Before:         auditUser(before),
+		After:          auditUser(user),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return user, tx.Commit()
+}
+
+func (u *userService) Delete(ctx context.Context, user *models.User) error {
+	tx, _ := u.db.Begin()
+	err := u.repository.Delete(tx, user)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	event, err := events.New(events.UserDeleted, user.OrganizationID, map[string]interface{}{"user_id": user.ID, "email": user.Email})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := u.outboxRepository.Add(tx, event); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	err = u.auditService.Record(ctx, tx, &audit.Change{
+		OrganizationID: user.OrganizationID,
+		Action:         audit.UserDeleted,
+		TargetType:     audit.TargetUser,
+		TargetID:       user.ID,
+		Before:         auditUser(user),
+	})
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
 
 func (u *userService) FindAllByIdIn(ids []int32) []*models.User {
 	return u.repository.FindAllByIdIn(ids)
 }
 
+func (u *userService) ExistingIds(ids []int32) ([]int32, error) {
+	return u.repository.ExistingIds(ids)
+}
+
 func (u *userService) GetGroups(user *models.User) ([]*models.Group, error) {
 	panic("implement me")
 }
@@ -78,3 +234,8 @@ func (u *userService) GetGroups(user *models.User) ([]*models.Group, error) {
 func (u *userService) GetPermissions(user *models.User) ([]*models.Permission, error) {
 	panic("implement me")
 }
+
+// auditUser returns the audited state of the user
+func auditUser(user *models.User) map[string]interface{} {
+	return map[string]interface{}{"email": user.Email, "external_id": user.ExternalID}
+}
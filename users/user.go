@@ -9,6 +9,7 @@ import (
 	"gopkg.in/thedevsaddam/govalidator.v1"
 
 	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils"
 )
 
 type organizationResponse struct {
@@ -24,7 +25,9 @@ type groupResponse struct {
 
 type UserResponse struct {
 	ID           int32                 `json:"id"`
+	ExternalID   string                `json:"external_id,omitempty"`
 	Email        string                `json:"email"`
+	CreatedAt    time.Time             `json:"created_at"`
 	Organization *organizationResponse `json:"organization"`
 	Groups       []*groupResponse      `json:"groups"`
 }
@@ -47,19 +50,22 @@ func NewUserResponse(user *models.User) *UserResponse {
 	//	groups = append(groups, newGroupsResponse(group))
 	//}
 	return &UserResponse{
-		ID:     user.ID,
-		Email:  user.Email,
-		Groups: groups,
+		ID:         user.ID,
+		ExternalID: user.ExternalID,
+		Email:      user.Email,
+		CreatedAt:  user.CreatedAt,
+		Groups:     groups,
 		Organization: &organizationResponse{
 			ID:   user.Organization.ID,
 			Name: user.Organization.Name,
 		}}
 }
 
+// UserPayload identifies a user by its id, its external id or both
 type UserPayload struct {
-	ID             int32  `json:"id"`
-	Email          string `json:"email"`
-	OrganizationID int32  `json:"organization_id"`
+	ID         int32  `json:"id"`
+	ExternalID string `json:"external_id"`
+	Email      string `json:"email"`
 }
 
 func (u *UserPayload) Bind(r *http.Request) error {
@@ -68,9 +74,8 @@ func (u *UserPayload) Bind(r *http.Request) error {
 
 func (u *UserPayload) validate() url.Values {
 	rules := govalidator.MapData{
-		"id":              []string{"required"},
-		"email":           []string{"required", "email"},
-		"organization_id": []string{"required"},
+		"external_id": []string{"max:255"},
+		"email":       []string{"required", "email"},
 	}
 	opts := govalidator.Options{
 		Data:  u,
@@ -79,6 +84,17 @@ func (u *UserPayload) validate() url.Values {
 
 	v := govalidator.New(opts)
 	e := v.ValidateStruct()
+	if u.ID < 0 {
+		e.Add("id", "The id field must be a positive integer")
+	}
+	if u.ID == 0 && u.ExternalID == "" {
+		e.Add("id", "The id field is required when external_id is missing")
+	}
+	if u.ExternalID != "" {
+		if _, externalID := utils.ParseID(u.ExternalID); externalID == "" {
+			e.Add("external_id", "The external_id field must not be a positive integer")
+		}
+	}
 	return e
 }
 
@@ -89,3 +105,14 @@ func NewUserListResponse(users []*models.User) []render.Renderer {
 	}
 	return list
 }
+
+// userSortValue returns the value of the sort field of user
+func userSortValue(user *models.User, sort string) interface{} {
+	switch sort {
+	case "email":
+		return user.Email
+	case "created_at":
+		return user.CreatedAt
+	}
+	return user.ID
+}
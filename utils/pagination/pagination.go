@@ -0,0 +1,228 @@
+// Package pagination implements cursor based pagination, filtering and
+// sorting of list endpoints.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9/orm"
+)
+
+const (
+	// DefaultLimit is the page size used when no limit is requested
+	DefaultLimit = 50
+	// MaxLimit is the largest page size served
+	MaxLimit = 200
+)
+
+// timeLayout formats timestamp sort values, timestamps are stored without zone
+const timeLayout = "2006-01-02 15:04:05.999999"
+
+// ErrInvalidCursor is returned for malformed cursors or cursors of another sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points after the last item of a page
+type Cursor struct {
+	Sort  string `json:"s"`
+	ID    int32  `json:"id"`
+	Value string `json:"v,omitempty"`
+}
+
+// String encodes the cursor for clients
+func (c *Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// Params holds the pagination, filtering and sorting parameters of a list request
+type Params struct {
+	Limit  int
+	Cursor *Cursor
+	// Sort is the sort field, "id" by default
+	Sort string
+	Desc bool
+
+	// Name filters by name prefix
+	Name string
+	// Email filters by email substring
+	Email string
+	// CreatedAfter filters items created after the time when set
+	CreatedAfter time.Time
+}
+
+// NewParams returns the first page parameters sorted by id
+func NewParams() *Params {
+	return &Params{Limit: DefaultLimit, Sort: "id"}
+}
+
+// ParseParams reads limit, cursor, sort, name, email and created_after from
+// the query string, sort must be "id" or one of sortable, prefixed with "-"
+// for descending order
+func ParseParams(r *http.Request, sortable ...string) (*Params, url.Values) {
+	query := r.URL.Query()
+	errs := make(url.Values)
+	params := NewParams()
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxLimit {
+			errs.Add("limit", fmt.Sprintf("The limit field must be between 1 and %d", MaxLimit))
+		}
+		params.Limit = n
+	}
+
+	if sort := query.Get("sort"); sort != "" {
+		params.Desc = strings.HasPrefix(sort, "-")
+		params.Sort = strings.TrimPrefix(sort, "-")
+		if !isSortable(params.Sort, sortable) {
+			errs.Add("sort", "The sort field must be one of id, "+strings.Join(sortable, ", "))
+		}
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		c, err := decodeCursor(cursor)
+		if err != nil || c.Sort != sortKey(params) {
+			errs.Add("cursor", ErrInvalidCursor.Error())
+		}
+		params.Cursor = c
+	}
+
+	params.Name = query.Get("name")
+	params.Email = query.Get("email")
+	if createdAfter := query.Get("created_after"); createdAfter != "" {
+		t, err := time.Parse(time.RFC3339, createdAfter)
+		if err != nil {
+			errs.Add("created_after", "The created_after field must be a RFC3339 timestamp")
+		}
+		params.CreatedAfter = t.UTC()
+	}
+	return params, errs
+}
+
+func isSortable(sort string, sortable []string) bool {
+	if sort == "id" {
+		return true
+	}
+	for _, s := range sortable {
+		if s == sort {
+			return true
+		}
+	}
+	return false
+}
+
+func sortKey(p *Params) string {
+	if p.Desc {
+		return "-" + p.Sort
+	}
+	return p.Sort
+}
+
+// Apply orders q by column then idColumn, resumes after the cursor and
+// limits q to one lookahead item past the page to detect a next page
+func (p *Params) Apply(q *orm.Query, column string, idColumn string) *orm.Query {
+	dir, cmp := "ASC", ">"
+	if p.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if p.Cursor != nil {
+		if column == idColumn {
+			q = q.Where(fmt.Sprintf("%s %s ?", idColumn, cmp), p.Cursor.ID)
+		} else {
+			q = q.Where(fmt.Sprintf("(%s, %s) %s (?, ?)", column, idColumn, cmp), p.Cursor.Value, p.Cursor.ID)
+		}
+	}
+	if column != idColumn {
+		q = q.OrderExpr(column + " " + dir)
+	}
+	return q.OrderExpr(idColumn + " " + dir).Limit(p.Limit + 1)
+}
+
+// Page trims the lookahead item of n fetched items and returns the number of
+// items to keep with the page metadata, cursor returns the id and sort value
+// of the item at index i
+func (p *Params) Page(n int, cursor func(i int) (int32, interface{})) (int, *Page) {
+	page := &Page{Limit: p.Limit}
+	if n <= p.Limit {
+		return n, page
+	}
+	id, value := cursor(p.Limit - 1)
+	next := &Cursor{Sort: sortKey(p), ID: id}
+	switch v := value.(type) {
+	case time.Time:
+		next.Value = v.UTC().Format(timeLayout)
+	case string:
+		next.Value = v
+	}
+	page.NextCursor = next.String()
+	return p.Limit, page
+}
+
+// Prefix returns a LIKE pattern matching values starting with s
+func Prefix(s string) string {
+	return escapeLike(s) + "%"
+}
+
+// Suffix returns a LIKE pattern matching values ending with s
+func Suffix(s string) string {
+	return "%" + escapeLike(s)
+}
+
+// Contains returns a LIKE pattern matching values containing s
+func Contains(s string) string {
+	return "%" + escapeLike(s) + "%"
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// Page is the pagination metadata of a list response
+type Page struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ListResponse is a page of a list endpoint
+type ListResponse struct {
+	Data []render.Renderer `json:"data"`
+	Page *Page             `json:"page"`
+}
+
+func (l *ListResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	for _, item := range l.Data {
+		if err := item.Render(w, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewListResponse construct a list response
+func NewListResponse(data []render.Renderer, page *Page) *ListResponse {
+	if data == nil {
+		data = make([]render.Renderer, 0)
+	}
+	return &ListResponse{Data: data, Page: page}
+}
@@ -2,16 +2,16 @@ package utils
 
 import (
 	"strconv"
-	"strings"
 )
 
-func GetIntID(name string) int32 {
-	idStr, err := strconv.ParseInt(strings.Split(name, "::")[1], 10, 32)
-	if err != nil {
-		panic(err)
+// ParseID parses a url identifier of a user or an organization, a positive
+// integer is its internal id and anything else is its external id
+func ParseID(identifier string) (id int32, externalID string) {
+	n, err := strconv.ParseInt(identifier, 10, 32)
+	if err != nil || n <= 0 {
+		return 0, identifier
 	}
-	id := int32(int(idStr))
-	return id
+	return int32(n), ""
 }
 
 // Find takes a slice and looks for an element in it. If found it will
@@ -34,6 +34,19 @@ func Exists(slice []int32, val int32) bool {
 	return false
 }
 
+// Unique returns the distinct values of slice keeping their order
+func Unique(slice []int32) []int32 {
+	seen := make(map[int32]bool, len(slice))
+	result := make([]int32, 0, len(slice))
+	for _, item := range slice {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 func Intersection(a, b []int32) (c []int32) {
 	m := make(map[int32]bool)
 
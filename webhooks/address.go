@@ -0,0 +1,110 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ErrBlockedAddress is returned for webhook urls and deliveries targeting a
+// loopback, link-local or private address which is not allowed
+var ErrBlockedAddress = errors.New("webhook address is not allowed")
+
+// blockedNetworks are the loopback, link-local, private and otherwise non
+// public networks webhooks may not target unless allowed
+var blockedNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// Options configures the webhooks
+type Options struct {
+	// AllowedNetworks are CIDRs of blocked networks webhooks may still
+	// target, e.g. "10.0.0.0/8" for receivers within a private network
+	AllowedNetworks []string
+}
+
+// addressPolicy blocks the addresses of blockedNetworks outside of the
+// allowed networks
+type addressPolicy struct {
+	allowed []*net.IPNet
+}
+
+func newAddressPolicy(opts Options) (*addressPolicy, error) {
+	policy := &addressPolicy{}
+	for _, cidr := range opts.AllowedNetworks {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("webhooks: invalid allowed network %q : %w", cidr, err)
+		}
+		policy.allowed = append(policy.allowed, network)
+	}
+	return policy, nil
+}
+
+// checkIP fails when ip is blocked
+func (p *addressPolicy) checkIP(ip net.IP) error {
+	for _, network := range p.allowed {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return ErrBlockedAddress
+		}
+	}
+	return nil
+}
+
+// checkHost fails when host is a blocked ip address or names the local
+// host, other names are checked once resolved by control
+func (p *addressPolicy) checkHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return p.checkIP(ip)
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return p.checkIP(net.IPv4(127, 0, 0, 1))
+	}
+	return nil
+}
+
+// control checks the resolved address of every connection, so names
+// resolving to blocked addresses are refused as well
+func (p *addressPolicy) control(network string, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrBlockedAddress
+	}
+	return p.checkIP(ip)
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,169 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/imtanmoy/authz/logger"
+	"github.com/imtanmoy/authz/models"
+)
+
+// Delivery request headers
+const (
+	// SignatureHeader carries "t=<unix timestamp>,v1=<hex hmac>", see Sign
+	SignatureHeader = "X-Authz-Signature"
+	EventHeader     = "X-Authz-Event"
+	DeliveryHeader  = "X-Authz-Delivery"
+)
+
+const (
+	// maxAttempts is the number of attempts before a webhook is given up
+	// for an event
+	maxAttempts = 8
+	// deliveryTimeout bounds one delivery attempt
+	deliveryTimeout = 10 * time.Second
+
+	// concurrency is the number of jobs delivered at once
+	concurrency = 16
+	// lease is how long claimed jobs are hidden from other workers
+	lease = time.Minute
+	// pollInterval is how often due jobs are checked without notification
+	pollInterval = 5 * time.Second
+
+	// initialBackoff is the delay before the first retry of a job, doubled
+	// on every following retry up to maxBackoff
+	initialBackoff = 10 * time.Second
+	maxBackoff     = time.Hour
+)
+
+// Sign returns the signature header value of the payload sent at timestamp,
+// receivers recompute the hex HMAC-SHA256 of "<timestamp>.<payload>" with
+// the webhook secret and compare it to v1
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%d.", timestamp)
+	_, _ = mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// deliverer posts events to webhooks and logs every attempt
+type deliverer struct {
+	repository Repository
+	client     *http.Client
+	log        logger.Logger
+}
+
+func newDeliverer(repository Repository, policy *addressPolicy, log logger.Logger) *deliverer {
+	dialer := &net.Dialer{Timeout: deliveryTimeout, Control: policy.control}
+	return &deliverer{
+		repository: repository,
+		client: &http.Client{
+			Timeout: deliveryTimeout,
+			// deliveries are not proxied, the dialer checks the address
+			// of every connection
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: deliveryTimeout,
+				MaxIdleConnsPerHost: 2,
+			},
+			// redirects are reported as failed deliveries
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		log: log,
+	}
+}
+
+// deliver posts the job to its webhook and logs the attempt, the job is
+// deleted once delivered or given up and rescheduled otherwise
+func (d *deliverer) deliver(ctx context.Context, job *models.WebhookJob) error {
+	webhook, err := d.repository.FindById(job.WebhookID)
+	if err != nil {
+		return err
+	}
+	if !webhook.Active {
+		return d.repository.DeleteJob(job)
+	}
+
+	delivery := &models.WebhookDelivery{
+		WebhookID: webhook.ID,
+		EventID:   job.EventID,
+		EventType: job.EventType,
+		Payload:   job.Payload,
+		Attempt:   job.Attempts,
+	}
+	start := time.Now()
+	statusCode, postErr := d.post(ctx, webhook, job)
+	if ctx.Err() != nil {
+		// stopped, the job is claimed again when its lease expires
+		return nil
+	}
+	delivery.StatusCode = statusCode
+	delivery.DurationMs = time.Since(start).Milliseconds()
+	if postErr != nil {
+		delivery.Error = postErr.Error()
+	}
+	if _, err := d.repository.CreateDelivery(delivery); err != nil {
+		return err
+	}
+	if postErr == nil {
+		return d.repository.DeleteJob(job)
+	}
+	if job.Attempts >= maxAttempts {
+		d.errorf("webhook %d: event %s given up after %d attempts", webhook.ID, job.EventID, job.Attempts)
+		return d.repository.DeleteJob(job)
+	}
+	return d.repository.Reschedule(job, backoff(job.Attempts))
+}
+
+func (d *deliverer) post(ctx context.Context, webhook *models.Webhook, job *models.WebhookJob) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(job.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, job.EventType)
+	req.Header.Set(DeliveryHeader, job.EventID)
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, time.Now().Unix(), job.Payload))
+	req.Header.Set("User-Agent", "authz-webhooks")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *deliverer) errorf(format string, args ...interface{}) {
+	if d.log != nil {
+		d.log.Errorf(format, args...)
+	}
+}
+
+// backoff returns the delay before the retry following attempt
+func backoff(attempt int) time.Duration {
+	delay := initialBackoff
+	for i := 1; i < attempt && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,212 @@
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+	"github.com/go-pg/pg/v9"
+	param "github.com/oceanicdev/chi-param"
+
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/httputil"
+	"github.com/imtanmoy/authz/utils/pagination"
+)
+
+// Handler handles webhooks http method
+type Handler interface {
+	WebhookCtx(next http.Handler) http.Handler
+	List(w http.ResponseWriter, r *http.Request)
+	Create(w http.ResponseWriter, r *http.Request)
+	Get(w http.ResponseWriter, r *http.Request)
+	Update(w http.ResponseWriter, r *http.Request)
+	Delete(w http.ResponseWriter, r *http.Request)
+	Deliveries(w http.ResponseWriter, r *http.Request)
+}
+
+type webhookHandler struct {
+	service Service
+	db      *pg.DB
+}
+
+var _ Handler = (*webhookHandler)(nil)
+
+// NewWebhookHandler construct webhook handler
+func NewWebhookHandler(db *pg.DB, service Service) Handler {
+	return &webhookHandler{
+		service: service,
+		db:      db,
+	}
+}
+
+func (h *webhookHandler) WebhookCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := param.Int32(r, "id")
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request parameter", err))
+			return
+		}
+		ctx := r.Context()
+		organization, ok := ctx.Value("organization").(*models.Organization)
+		if !ok {
+			_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+			return
+		}
+		webhook, err := h.service.FindByIdAndOrganizationId(id, organization.ID)
+		if err != nil {
+			_ = render.Render(w, r, httputil.NewAPIError(404, "webhook not found", err))
+			return
+		}
+		ctx = context.WithValue(r.Context(), "webhook", webhook)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *webhookHandler) List(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	webhooks, err := h.service.List(organization)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewWebhookListResponse(webhooks)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
+// Create registers a webhook, the signing secret is only returned once
+func (h *webhookHandler) Create(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	organization, ok := ctx.Value("organization").(*models.Organization)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	data := &WebhookPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+	validationErrors := h.validate(data)
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	webhook := &models.Webhook{OrganizationID: organization.ID}
+	data.apply(webhook)
+	webhook, err := h.service.Create(webhook)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+
+	resp := NewWebhookResponse(webhook)
+	resp.Secret = webhook.Secret
+	render.Status(r, http.StatusCreated)
+	_ = render.Render(w, r, resp)
+}
+
+func (h *webhookHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	webhook, ok := ctx.Value("webhook").(*models.Webhook)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	if err := render.Render(w, r, NewWebhookResponse(webhook)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
+func (h *webhookHandler) Update(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	webhook, ok := ctx.Value("webhook").(*models.Webhook)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	data := &WebhookPayload{}
+	if err := render.Bind(r, data); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "unable to decode the request content type"))
+		return
+	}
+	validationErrors := h.validate(data)
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	data.apply(webhook)
+	if err := h.service.Update(webhook); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	if err := render.Render(w, r, NewWebhookResponse(webhook)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
+func (h *webhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	webhook, ok := ctx.Value("webhook").(*models.Webhook)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	if err := h.service.Delete(webhook); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	render.NoContent(w, r)
+}
+
+// Deliveries serves the delivery log of the webhook, newest attempts first
+// with sort=-id
+func (h *webhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	webhook, ok := ctx.Value("webhook").(*models.Webhook)
+	if !ok {
+		_ = render.Render(w, r, httputil.NewAPIError(422, "Request Can not be processed"))
+		return
+	}
+	params, validationErrors := pagination.ParseParams(r)
+	if len(validationErrors) > 0 {
+		_ = render.Render(w, r, httputil.NewAPIError(400, "Invalid request", validationErrors))
+		return
+	}
+
+	deliveries, err := h.service.Deliveries(webhook, params)
+	if err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+	n, page := params.Page(len(deliveries), func(i int) (int32, interface{}) {
+		return deliveries[i].ID, deliveries[i].ID
+	})
+	if err := render.Render(w, r, pagination.NewListResponse(NewDeliveryListResponse(deliveries[:n]), page)); err != nil {
+		_ = render.Render(w, r, httputil.NewAPIError(err))
+		return
+	}
+}
+
+// validate validates the payload and the address of its url
+func (h *webhookHandler) validate(data *WebhookPayload) url.Values {
+	validationErrors := data.validate()
+	if _, invalid := validationErrors["url"]; !invalid {
+		u, _ := url.Parse(data.URL)
+		if err := h.service.CheckURL(u); err != nil {
+			validationErrors.Add("url", "The url field must not target a loopback, link-local or private address")
+		}
+	}
+	return validationErrors
+}
@@ -0,0 +1,157 @@
+package webhooks
+
+import (
+	"time"
+
+	"github.com/go-pg/pg/v9"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/pagination"
+)
+
+// channel is notified when jobs are queued
+const channel = "webhooks"
+
+type Repository interface {
+	List(organizationId int32) ([]*models.Webhook, error)
+	// ListSubscribed returns the active webhooks of the organization
+	// subscribed to the event type
+	ListSubscribed(organizationId int32, eventType string) ([]*models.Webhook, error)
+	Create(webhook *models.Webhook) (*models.Webhook, error)
+	FindById(Id int32) (*models.Webhook, error)
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.Webhook, error)
+	Update(webhook *models.Webhook) error
+	Delete(webhook *models.Webhook) error
+	CreateDelivery(delivery *models.WebhookDelivery) (*models.WebhookDelivery, error)
+	ListDeliveries(webhookId int32, params *pagination.Params) ([]*models.WebhookDelivery, error)
+	// Enqueue queues the jobs and notifies the workers, a job of an event
+	// already pending for its webhook is not queued again
+	Enqueue(jobs []*models.WebhookJob) error
+	// Claim leases up to limit due jobs for lease and counts the attempt,
+	// jobs which are neither deleted nor rescheduled before the lease
+	// expires are claimed again
+	Claim(limit int, lease time.Duration) ([]*models.WebhookJob, error)
+	// Reschedule makes the job available again after delay
+	Reschedule(job *models.WebhookJob, delay time.Duration) error
+	DeleteJob(job *models.WebhookJob) error
+}
+
+type webhookRepository struct {
+	db *pg.DB
+}
+
+var _ Repository = (*webhookRepository)(nil)
+
+func NewWebhookRepository(db *pg.DB) Repository {
+	return &webhookRepository{
+		db,
+	}
+}
+
+func (w *webhookRepository) List(organizationId int32) ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+	err := w.db.Model(&webhooks).
+		Where("organization_id = ?", organizationId).
+		Order("id ASC").
+		Select()
+	return webhooks, err
+}
+
+func (w *webhookRepository) ListSubscribed(organizationId int32, eventType string) ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+	err := w.db.Model(&webhooks).
+		Where("organization_id = ?", organizationId).
+		Where("active").
+		Where("? = ANY(events)", eventType).
+		Order("id ASC").
+		Select()
+	return webhooks, err
+}
+
+func (w *webhookRepository) Create(webhook *models.Webhook) (*models.Webhook, error) {
+	_, err := w.db.Model(webhook).Returning("*").Insert()
+	return webhook, err
+}
+
+func (w *webhookRepository) FindById(Id int32) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := w.db.Model(&webhook).Where("id = ?", Id).First()
+	return &webhook, err
+}
+
+func (w *webhookRepository) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.Webhook, error) {
+	var webhook models.Webhook
+	err := w.db.Model(&webhook).
+		Where("id = ?", Id).
+		Where("organization_id = ?", Oid).
+		First()
+	return &webhook, err
+}
+
+func (w *webhookRepository) Update(webhook *models.Webhook) error {
+	_, err := w.db.Model(webhook).
+		Set("url = ?url").
+		Set("events = ?events").
+		Set("active = ?active").
+		Set("updated_at = ?updated_at").
+		Where("id = ?id").
+		Update()
+	return err
+}
+
+func (w *webhookRepository) Delete(webhook *models.Webhook) error {
+	_, err := w.db.Model(webhook).Where("id = ?id").Delete()
+	return err
+}
+
+func (w *webhookRepository) CreateDelivery(delivery *models.WebhookDelivery) (*models.WebhookDelivery, error) {
+	_, err := w.db.Model(delivery).Returning("*").Insert()
+	return delivery, err
+}
+
+func (w *webhookRepository) ListDeliveries(webhookId int32, params *pagination.Params) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+	q := w.db.Model(&deliveries).Where("webhook_delivery.webhook_id = ?", webhookId)
+	if !params.CreatedAfter.IsZero() {
+		q = q.Where("webhook_delivery.created_at > ?", params.CreatedAfter)
+	}
+	err := params.Apply(q, "webhook_delivery.id", "webhook_delivery.id").Select()
+	return deliveries, err
+}
+
+func (w *webhookRepository) Enqueue(jobs []*models.WebhookJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	if _, err := w.db.Model(&jobs).OnConflict("(webhook_id, event_id) DO NOTHING").Insert(); err != nil {
+		return err
+	}
+	_, err := w.db.Exec("NOTIFY ?", pg.Ident(channel))
+	return err
+}
+
+func (w *webhookRepository) Claim(limit int, lease time.Duration) ([]*models.WebhookJob, error) {
+	var jobs []*models.WebhookJob
+	_, err := w.db.Query(&jobs, `
+		UPDATE webhook_jobs SET available_at = now() + ? * interval '1 millisecond', attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM webhook_jobs
+			WHERE available_at <= now()
+			ORDER BY available_at, id LIMIT ?
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, lease.Milliseconds(), limit)
+	return jobs, err
+}
+
+func (w *webhookRepository) Reschedule(job *models.WebhookJob, delay time.Duration) error {
+	_, err := w.db.Model(job).
+		Set("available_at = now() + ? * interval '1 millisecond'", delay.Milliseconds()).
+		Where("id = ?id").
+		Update()
+	return err
+}
+
+func (w *webhookRepository) DeleteJob(job *models.WebhookJob) error {
+	_, err := w.db.Model(job).Where("id = ?id").Delete()
+	return err
+}
@@ -0,0 +1,176 @@
+// Package webhooks delivers signed change events to the endpoints registered
+// by organizations.
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-pg/pg/v9"
+
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/logger"
+	"github.com/imtanmoy/authz/models"
+	"github.com/imtanmoy/authz/utils/pagination"
+)
+
+// secretPrefix marks webhook signing secrets
+const secretPrefix = "whsec_"
+
+type Service interface {
+	// Publish queues the event for the webhooks subscribed to it, the
+	// webhooks are delivered by Run
+	events.Publisher
+	// Run delivers the queued events until ctx is done, every delivery runs
+	// on its own so a slow webhook holds back neither other webhooks nor the
+	// other sinks of the outbox
+	Run(ctx context.Context)
+
+	List(organization *models.Organization) ([]*models.Webhook, error)
+	// Create registers the webhook with a generated signing secret
+	Create(webhook *models.Webhook) (*models.Webhook, error)
+	FindByIdAndOrganizationId(Id int32, Oid int32) (*models.Webhook, error)
+	Update(webhook *models.Webhook) error
+	Delete(webhook *models.Webhook) error
+	// Deliveries returns a page of delivery attempts, fetching one lookahead
+	// attempt past params.Limit
+	Deliveries(webhook *models.Webhook, params *pagination.Params) ([]*models.WebhookDelivery, error)
+	// CheckURL fails with ErrBlockedAddress when the host of the url is a
+	// loopback, link-local or private address which is not allowed
+	CheckURL(u *url.URL) error
+}
+
+type webhookService struct {
+	db         *pg.DB
+	repository Repository
+	policy     *addressPolicy
+	deliverer  *deliverer
+}
+
+var _ Service = (*webhookService)(nil)
+
+// NewWebhookService creates the webhook service, webhooks given up are
+// reported to log
+func NewWebhookService(db *pg.DB, opts Options, log logger.Logger) (Service, error) {
+	policy, err := newAddressPolicy(opts)
+	if err != nil {
+		return nil, err
+	}
+	repository := NewWebhookRepository(db)
+	return &webhookService{
+		db:         db,
+		repository: repository,
+		policy:     policy,
+		deliverer:  newDeliverer(repository, policy, log),
+	}, nil
+}
+
+func (w *webhookService) List(organization *models.Organization) ([]*models.Webhook, error) {
+	return w.repository.List(organization.ID)
+}
+
+func (w *webhookService) Create(webhook *models.Webhook) (*models.Webhook, error) {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	webhook.Secret = secretPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return w.repository.Create(webhook)
+}
+
+func (w *webhookService) FindByIdAndOrganizationId(Id int32, Oid int32) (*models.Webhook, error) {
+	return w.repository.FindByIdAndOrganizationId(Id, Oid)
+}
+
+func (w *webhookService) Update(webhook *models.Webhook) error {
+	return w.repository.Update(webhook)
+}
+
+func (w *webhookService) Delete(webhook *models.Webhook) error {
+	return w.repository.Delete(webhook)
+}
+
+func (w *webhookService) CheckURL(u *url.URL) error {
+	return w.policy.checkHost(u.Hostname())
+}
+
+func (w *webhookService) Deliveries(webhook *models.Webhook, params *pagination.Params) ([]*models.WebhookDelivery, error) {
+	return w.repository.ListDeliveries(webhook.ID, params)
+}
+
+// Publish queues the event for every webhook of its organization subscribed
+// to its type, an event still pending for a webhook is not queued again
+func (w *webhookService) Publish(ctx context.Context, event *events.Event) error {
+	webhooks, err := w.repository.ListSubscribed(event.OrganizationID, event.Type)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	jobs := make([]*models.WebhookJob, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		jobs = append(jobs, &models.WebhookJob{
+			WebhookID: webhook.ID,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   payload,
+		})
+	}
+	return w.repository.Enqueue(jobs)
+}
+
+func (w *webhookService) Run(ctx context.Context) {
+	listener := w.db.Listen(channel)
+	defer listener.Close()
+	notifications := listener.Channel()
+
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+
+	// slots holds a token per running delivery, freed wakes the loop when
+	// one ends
+	slots := make(chan struct{}, concurrency)
+	freed := make(chan struct{}, 1)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		if free := concurrency - len(slots); free > 0 && ctx.Err() == nil {
+			jobs, err := w.repository.Claim(free, lease)
+			if err != nil {
+				w.deliverer.errorf("webhook jobs could not be claimed : %s", err)
+			}
+			for _, job := range jobs {
+				slots <- struct{}{}
+				wg.Add(1)
+				go func(job *models.WebhookJob) {
+					defer func() {
+						<-slots
+						wg.Done()
+						select {
+						case freed <- struct{}{}:
+						default:
+						}
+					}()
+					if err := w.deliverer.deliver(ctx, job); err != nil {
+						w.deliverer.errorf("webhook %d: event %s : %s", job.WebhookID, job.EventID, err)
+					}
+				}(job)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-notifications:
+		case <-poll.C:
+		case <-freed:
+		}
+	}
+}
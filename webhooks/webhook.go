@@ -0,0 +1,132 @@
+package webhooks
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"gopkg.in/thedevsaddam/govalidator.v1"
+
+	"github.com/imtanmoy/authz/events"
+	"github.com/imtanmoy/authz/models"
+)
+
+type WebhookPayload struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	// Active defaults to true
+	Active *bool `json:"active"`
+}
+
+func (w *WebhookPayload) Bind(r *http.Request) error {
+	return nil
+}
+
+func (w *WebhookPayload) validate() url.Values {
+	rules := govalidator.MapData{
+		"url":    []string{"required", "url", "max:2048"},
+		"events": []string{"required"},
+	}
+	opts := govalidator.Options{
+		Data:  w,
+		Rules: rules,
+	}
+
+	v := govalidator.New(opts)
+	e := v.ValidateStruct()
+	if w.URL != "" {
+		if u, err := url.Parse(w.URL); err != nil || !u.IsAbs() || u.Hostname() == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			e.Add("url", "The url field must be an absolute http or https url")
+		}
+	}
+	for _, eventType := range w.Events {
+		if !events.IsType(eventType) {
+			e.Add("events", "The events field must only contain "+strings.Join(events.Types, ", "))
+			break
+		}
+	}
+	return e
+}
+
+// apply sets the payload fields on webhook
+func (w *WebhookPayload) apply(webhook *models.Webhook) {
+	webhook.URL = w.URL
+	webhook.Events = w.Events
+	webhook.Active = w.Active == nil || *w.Active
+}
+
+type WebhookResponse struct {
+	ID        int32      `json:"id"`
+	URL       string     `json:"url"`
+	Events    []string   `json:"events"`
+	Active    bool       `json:"active"`
+	Secret    string     `json:"secret,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+func (w *WebhookResponse) Render(rw http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewWebhookResponse(webhook *models.Webhook) *WebhookResponse {
+	resp := &WebhookResponse{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    webhook.Events,
+		Active:    webhook.Active,
+		CreatedAt: webhook.CreatedAt,
+	}
+	if !webhook.UpdatedAt.IsZero() {
+		resp.UpdatedAt = &webhook.UpdatedAt
+	}
+	return resp
+}
+
+func NewWebhookListResponse(webhooks []*models.Webhook) []render.Renderer {
+	list := make([]render.Renderer, 0)
+	for _, webhook := range webhooks {
+		list = append(list, NewWebhookResponse(webhook))
+	}
+	return list
+}
+
+type DeliveryResponse struct {
+	ID         int32     `json:"id"`
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	Succeeded  bool      `json:"succeeded"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (d *DeliveryResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+func NewDeliveryResponse(delivery *models.WebhookDelivery) *DeliveryResponse {
+	return &DeliveryResponse{
+		ID:         delivery.ID,
+		EventID:    delivery.EventID,
+		EventType:  delivery.EventType,
+		Attempt:    delivery.Attempt,
+		Succeeded:  delivery.Succeeded(),
+		StatusCode: delivery.StatusCode,
+		Error:      delivery.Error,
+		DurationMs: delivery.DurationMs,
+		CreatedAt:  delivery.CreatedAt,
+	}
+}
+
+func NewDeliveryListResponse(deliveries []*models.WebhookDelivery) []render.Renderer {
+	list := make([]render.Renderer, 0)
+	for _, delivery := range deliveries {
+		list = append(list, NewDeliveryResponse(delivery))
+	}
+	return list
+}